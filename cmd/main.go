@@ -1,58 +1,528 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"query-k8s-container-image-history/internal/docker-image-history"
 )
 
-var (
+// cliFlags are the parsed CLI flags
+type cliFlags struct {
 	clusterK8sContextName       string
 	imagesAccountAWSProfileName string
 	dockerImageKeyWordsFlag     string
 	dockerImageKeyWords         []string
 	ecrRegionsFlag              string
 	ecrRegions                  []string
-)
+	ecrRegionOverrideFlag       string
+	registryMirrorFlag          string
+	podDetailFieldsFlag         string
+	serveAddress                string
+	expectedImagesPath          string
+	timestampFormat             string
+	remote                      bool
+	remoteConcurrency           int
+	dockerHost                  string
+	dockerTLSCACert             string
+	dockerTLSCert               string
+	dockerTLSKey                string
+	preflight                   bool
+	caseSensitive               bool
+	outputFormat                string
+	deferCleanup                bool
+	insecureRegistriesFlag      string
+	insecureRegistries          []string
+	registryCAFile              string
+	concurrency                 int
+	maxDiskGB                   float64
+	keywordPoliciesPath         string
+	inventoryOutput             bool
+	scanAnnotations             bool
+	sinceFlag                   string
+	since                       time.Time
+	contextChars                int
+	syslog                      bool
+	syslogNetwork               string
+	syslogAddress               string
+	syslogOnly                  bool
+	resumeFrom                  string
+	namespace                   string
+	cleanupConcurrency          int
+	failFast                    bool
+	maxImageSizeMB              float64
+	lowMemory                   bool
+	nodeImageStats              bool
+	scanNodeCachedImages        bool
+	nodeInventory               bool
+	runOnly                     bool
+	allContexts                 bool
+	maxImages                   int
+	seed                        int64
+	ecrRegionOverrides          map[string]string
+	baselinePath                string
+	fullRescan                  bool
+	redact                      bool
+	outputAppend                bool
+	checkRunsAsRoot             bool
+	dockerSave                  bool
+	countMode                   string
+	registryMirrors             map[string]string
+	podDetailFields             []string
+	keywordRulesPath            string
+	groupBy                     string
+	workload                    string
+	failOnUnparseableRefs       bool
+	failOnMatch                 bool
+	debugAuth                   bool
+	decodeBase64                bool
+	noPullIfPresent             bool
+	summaryOnly                 bool
+	podEvents                   bool
+	crdImageSourcesPath         string
+	retryCleanup                bool
+	regexKeywords               bool
+	forcePull                   bool
+	pullRetries                 int
+	inCluster                   bool
+	includeNamespaces           []string
+	excludeNamespaces           []string
+	includeNamespacesFlag       string
+	excludeNamespacesFlag       string
+	dryRun                      bool
+	outputDir                   string
+	keepImages                  bool
+	keepOffendingImages         bool
+	workloadTemplates           bool
+	skipImages                  []string
+	skipImagesFlag              string
+	pullTimeout                 time.Duration
+	collapseReplicas            bool
+}
 
 func main() {
-	parseFlags()
-	log.Printf("Using K8s Context: '%s'", clusterK8sContextName)
-	log.Printf("Using AWS Profile '%s' to pull ECR permissions for the regions: %v", imagesAccountAWSProfileName, ecrRegions)
-	log.Printf("Searching for these keywords in image history of all pods in cluster: %v", dockerImageKeyWords)
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	cfg, err := docker_image_history.NewConfig(dockerImageKeyWords, clusterK8sContextName, imagesAccountAWSProfileName, ecrRegions)
+// exitMatchFound is the exit code when -failOnMatch or -summaryOnly is set and images matched keywords, distinct from the codes for
+// errors (1) and invalid flags (2), so a CI gate can tell a dirty scan from a broken one
+const exitMatchFound = 3
+
+// run parses the CLI flags from args and runs the scan, writing the findings to stdout and the logs to stderr
+// Returns the exit code: 0 on success, 1 if the scan failed, 2 if the flags are invalid and 3 if -failOnMatch or -summaryOnly is set
+// and images matched keywords
+func run(args []string, stdout, stderr io.Writer) int {
+	log.SetOutput(stderr)
+	f, err := parseFlags(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 	if err != nil {
-		log.Fatalf("loading config: %s", err)
+		log.Println(err)
+		return 2
 	}
 
-	if err = cfg.ProcessAllImagesHistoryForKeywords(); err != nil {
-		log.Fatalln(err)
+	if f.allContexts {
+		log.Println("Using every K8s Context in ${HOME}/.kube/config")
+	} else if len(f.clusterK8sContextName) == 0 {
+		log.Println("Using the in-cluster K8s config")
+	} else {
+		log.Printf("Using K8s Context: '%s'", f.clusterK8sContextName)
+	}
+	if len(f.ecrRegions) == 0 && len(f.ecrRegionOverrides) == 0 {
+		log.Println("No ECR regions are configured. The region of each ECR image found will be authenticated on demand")
+	} else if len(f.imagesAccountAWSProfileName) > 0 {
+		log.Printf("Using AWS Profile '%s' to pull ECR permissions for the regions: %v", f.imagesAccountAWSProfileName, f.ecrRegions)
+	} else {
+		log.Printf("Using the default AWS credential chain to pull ECR permissions for the regions: %v", f.ecrRegions)
+	}
+	if len(f.workload) > 0 {
+		log.Printf("Searching for these keywords in image history of workload '%s' in namespace '%s': %v", f.workload, f.namespace, f.dockerImageKeyWords)
+	} else if len(f.namespace) > 0 {
+		log.Printf("Searching for these keywords in image history of all pods in namespace '%s': %v", f.namespace, f.dockerImageKeyWords)
+	} else if len(f.includeNamespaces) > 0 {
+		log.Printf("Searching for these keywords in image history of all pods in namespaces %v: %v", f.includeNamespaces, f.dockerImageKeyWords)
+	} else if len(f.excludeNamespaces) > 0 {
+		log.Printf("Searching for these keywords in image history of all pods in cluster, except in namespaces %v: %v", f.excludeNamespaces, f.dockerImageKeyWords)
+	} else {
+		log.Printf("Searching for these keywords in image history of all pods in cluster: %v", f.dockerImageKeyWords)
 	}
+
+	opts := docker_image_history.Options{
+		DockerImageKeyWords:         f.dockerImageKeyWords,
+		ClusterK8sContextName:       f.clusterK8sContextName,
+		ImagesAccountAWSProfileName: f.imagesAccountAWSProfileName,
+		ECRRegions:                  f.ecrRegions,
+		ServeAddress:                f.serveAddress,
+		ExpectedImagesPath:          f.expectedImagesPath,
+		TimestampFormat:             f.timestampFormat,
+		Remote:                      f.remote,
+		RemoteConcurrency:           f.remoteConcurrency,
+		DockerHost:                  f.dockerHost,
+		DockerTLSCACert:             f.dockerTLSCACert,
+		DockerTLSCert:               f.dockerTLSCert,
+		DockerTLSKey:                f.dockerTLSKey,
+		CaseSensitive:               f.caseSensitive,
+		OutputFormat:                f.outputFormat,
+		DeferCleanup:                f.deferCleanup,
+		InsecureRegistries:          f.insecureRegistries,
+		RegistryCAFile:              f.registryCAFile,
+		Concurrency:                 f.concurrency,
+		MaxDiskGB:                   f.maxDiskGB,
+		KeywordPoliciesPath:         f.keywordPoliciesPath,
+		InventoryOutput:             f.inventoryOutput,
+		ScanAnnotations:             f.scanAnnotations,
+		Since:                       f.since,
+		ContextChars:                f.contextChars,
+		Syslog:                      f.syslog,
+		SyslogNetwork:               f.syslogNetwork,
+		SyslogAddress:               f.syslogAddress,
+		SyslogOnly:                  f.syslogOnly,
+		ResumeFrom:                  f.resumeFrom,
+		Namespace:                   f.namespace,
+		CleanupConcurrency:          f.cleanupConcurrency,
+		FailFast:                    f.failFast,
+		MaxImageSizeMB:              f.maxImageSizeMB,
+		LowMemory:                   f.lowMemory,
+		NodeImageStats:              f.nodeImageStats,
+		ScanNodeCachedImages:        f.scanNodeCachedImages,
+		NodeInventory:               f.nodeInventory,
+		RunOnly:                     f.runOnly,
+		MaxImages:                   f.maxImages,
+		Seed:                        f.seed,
+		ECRRegionOverrides:          f.ecrRegionOverrides,
+		BaselinePath:                f.baselinePath,
+		FullRescan:                  f.fullRescan,
+		Redact:                      f.redact,
+		OutputAppend:                f.outputAppend,
+		CheckRunsAsRoot:             f.checkRunsAsRoot,
+		DockerSave:                  f.dockerSave,
+		CountMode:                   f.countMode,
+		RegistryMirrors:             f.registryMirrors,
+		PodDetailFields:             f.podDetailFields,
+		KeywordRulesPath:            f.keywordRulesPath,
+		GroupBy:                     f.groupBy,
+		Workload:                    f.workload,
+		FailOnUnparseableRefs:       f.failOnUnparseableRefs,
+		DebugAuth:                   f.debugAuth,
+		DecodeBase64:                f.decodeBase64,
+		NoPullIfPresent:             f.noPullIfPresent,
+		SummaryOnly:                 f.summaryOnly,
+		PodEvents:                   f.podEvents,
+		CRDImageSourcesPath:         f.crdImageSourcesPath,
+		RetryCleanup:                f.retryCleanup,
+		RegexKeywords:               f.regexKeywords,
+		ForcePull:                   f.forcePull,
+		PullRetries:                 f.pullRetries,
+		InCluster:                   f.inCluster,
+		IncludeNamespaces:           f.includeNamespaces,
+		ExcludeNamespaces:           f.excludeNamespaces,
+		DryRun:                      f.dryRun,
+		OutputDir:                   f.outputDir,
+		KeepImages:                  f.keepImages,
+		KeepOffendingImages:         f.keepOffendingImages,
+		WorkloadTemplates:           f.workloadTemplates,
+		SkipImages:                  f.skipImages,
+		PullTimeout:                 f.pullTimeout,
+		CollapseReplicas:            f.collapseReplicas,
+		Stdout:                      stdout,
+		Stderr:                      stderr,
+	}
+
+	// Ctrl-C stops the scan, still cleaning up the images being processed and writing the results found so far
+	// It also cancels the K8s, registry and AWS requests made whilst loading the config and running the preflight checks
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if f.preflight {
+		if !docker_image_history.Preflight(ctx, opts, stdout) {
+			return 1
+		}
+		return 0
+	}
+
+	if f.allContexts {
+		matched, err := docker_image_history.ProcessAllContexts(ctx, opts)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if f.failOnMatch && matched {
+			log.Printf("Images matched keywords in at least one context, exiting with code %d", exitMatchFound)
+			return exitMatchFound
+		}
+		return 0
+	}
+
+	cfg, err := docker_image_history.NewConfig(ctx, opts)
+	if err != nil {
+		log.Printf("loading config: %s", err)
+		return 1
+	}
+
+	if err = cfg.ProcessAllImagesHistoryForKeywordsContext(ctx); err != nil {
+		log.Println(err)
+		return 1
+	}
+	exitCode := 0
+	if (f.failOnMatch || f.summaryOnly) && !cfg.Clean() {
+		log.Printf("Images matched keywords, exiting with code %d", exitMatchFound)
+		exitCode = exitMatchFound
+	}
+
+	// Keep serving the final results until the user is done browsing them
+	if len(f.serveAddress) > 0 {
+		log.Printf("Scan complete. Results are still being served on http://%s, press Ctrl-C to exit", f.serveAddress)
+		<-ctx.Done()
+	}
+	return exitCode
 }
 
-// parseFlags parses the CLI flags passed
-func parseFlags() {
-	flag.StringVar(&clusterK8sContextName, "clusterK8sContextName", "", "Context to use in K8s config file in ${HOME}/.kube/config")
-	flag.StringVar(&imagesAccountAWSProfileName, "imagesAccountAWSProfileName", "", "AWS profile name to use to authenticate for pulling ECR based Docker images")
-	flag.StringVar(&dockerImageKeyWordsFlag, "dockerImageKeyWords", "", "Comma separated list of keywords to search for in image history of K8s pods running in the cluster")
-	flag.StringVar(&ecrRegionsFlag, "ecrRegions", "", "Optional: Comma separated list of AWS regions which private ECR registries are present in. Auth tokens will be generated for each")
-	flag.Parse()
+// parseFlags parses and validates the CLI flags in args. Usage and flag parsing errors are written to stderr
+func parseFlags(args []string, stderr io.Writer) (cliFlags, error) {
+	var f cliFlags
+	fs := flag.NewFlagSet("query-k8s-container-image-history", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&f.clusterK8sContextName, "clusterK8sContextName", "", "Context to use in K8s config file in ${HOME}/.kube/config")
+	fs.StringVar(&f.imagesAccountAWSProfileName, "imagesAccountAWSProfileName", "", "Optional: AWS profile name to use to authenticate for pulling ECR based Docker images. Falls back to the default credential chain (env vars, instance role, IRSA) if not set")
+	fs.StringVar(&f.dockerImageKeyWordsFlag, "dockerImageKeyWords", "", "Comma separated list of keywords to search for in image history of K8s pods running in the cluster. Prefix a keyword with '!' to flag images where it is absent from the history. Prefix with 'env:', 'label:', 'entrypoint:', 'run:' or 'history:' to only match in that field")
+	fs.StringVar(&f.ecrRegionsFlag, "ecrRegions", "", "Optional: Comma separated list of AWS regions which private ECR registries are present in. Auth tokens will be generated for each up front. Other regions are authenticated on demand when an image in them is found")
+	fs.StringVar(&f.serveAddress, "serve", "", "Optional: Address (e.g. 'localhost:8080') to serve an auto-refreshing page showing scan progress and the offending images found so far")
+	fs.StringVar(&f.expectedImagesPath, "expectedImages", "", "Optional: Path to a file of approved digests, one '<namespace>/<workload>/<container> sha256:<hex>' per line. Running containers which do not match are reported as drift")
+	fs.StringVar(&f.timestampFormat, "timestampFormat", docker_image_history.DefaultTimestampFormat, "Optional: Go time layout used for the timestamp in results filenames. e.g. '2-Jan-2006-15:04' for the legacy format")
+	fs.BoolVar(&f.remote, "remote", false, "Optional: Read the image history directly from the registries rather than pulling the images using the local Docker instance")
+	fs.IntVar(&f.remoteConcurrency, "remoteConcurrency", 8, "Optional: Maximum number of images to read concurrently from the registries when -remote is set")
+	fs.StringVar(&f.dockerHost, "dockerHost", "", "Optional: Docker daemon to connect to (e.g. 'tcp://build-host:2376'). Overrides DOCKER_HOST")
+	fs.StringVar(&f.dockerTLSCACert, "dockerTLSCACert", "", "Optional: Path to the CA certificate used to verify the Docker daemon")
+	fs.StringVar(&f.dockerTLSCert, "dockerTLSCert", "", "Optional: Path to the client certificate used to authenticate with the Docker daemon")
+	fs.StringVar(&f.dockerTLSKey, "dockerTLSKey", "", "Optional: Path to the client key used to authenticate with the Docker daemon")
+	fs.BoolVar(&f.preflight, "preflight", false, "Optional: Check Docker, the K8s context, ECR credentials and the output directory are usable, print a pass/fail table and exit without scanning. Exits 1 if any check fails")
+	fs.BoolVar(&f.caseSensitive, "caseSensitive", false, "Optional: Match keywords against the image history case-sensitively. Defaults to case-insensitive matching")
+	fs.StringVar(&f.outputFormat, "outputFormat", docker_image_history.OutputFormatText, "Optional: Format of the results files. Either 'text' or 'json'")
+	fs.BoolVar(&f.deferCleanup, "deferCleanup", false, "Optional: Keep pulled images until the end of the scan and then remove them all in a single pass, reporting the total space reclaimed. Requires enough disk to hold every image")
+	fs.StringVar(&f.insecureRegistriesFlag, "insecureRegistries", "", "Optional: Comma separated list of registry hosts (e.g. 'harbor.internal:5000') to skip TLS verification for. SECURITY SENSITIVE: connections to them can be intercepted")
+	fs.StringVar(&f.registryCAFile, "registryCAFile", "", "Optional: Path to a PEM file of additional CA certificates to trust for private registries")
+	fs.IntVar(&f.concurrency, "concurrency", 0, "Optional: Maximum number of images to pull and scan at once using the local Docker instance. Defaults to one per CPU, or fewer if -maxDiskGB only fits fewer images of the average size")
+	fs.Float64Var(&f.maxDiskGB, "maxDiskGB", 0, "Optional: Pause new pulls whilst the estimated disk usage of the images stored locally would exceed this many GB. 0 means unlimited")
+	fs.StringVar(&f.keywordPoliciesPath, "keywordPolicies", "", "Optional: Path to a JSON file of per-namespace keyword policies, selecting namespaces by name or labels. Namespaces without a matching policy use -dockerImageKeyWords")
+	fs.BoolVar(&f.inventoryOutput, "inventoryOutput", false, "Optional: Write every image running in the cluster, the pods running it and its ECR/region classification to a JSON file as soon as discovery completes")
+	fs.BoolVar(&f.scanAnnotations, "scanAnnotations", false, "Optional: Also match keywords against each image's manifest annotations and config labels, read from its registry")
+	fs.StringVar(&f.sinceFlag, "since", "", "Optional: Only scan images created after this RFC3339 timestamp (e.g. '2023-01-31T00:00:00Z') or duration before now (e.g. '168h')")
+	fs.IntVar(&f.contextChars, "contextChars", 0, "Optional: Include this many characters of the history command either side of each keyword match in the results")
+	fs.BoolVar(&f.syslog, "syslog", false, "Optional: Also send each offending image to syslog as a single structured message")
+	fs.StringVar(&f.syslogNetwork, "syslogNetwork", "", "Optional: Network used to reach the syslog server when -syslog is set (e.g. 'udp', 'tcp'). Defaults to the local syslog daemon")
+	fs.StringVar(&f.syslogAddress, "syslogAddress", "", "Optional: Address of the syslog server when -syslog is set (e.g. 'syslog.internal:514'). Defaults to the local syslog daemon")
+	fs.BoolVar(&f.syslogOnly, "syslogOnly", false, "Optional: Only send the results to syslog, without writing any results files. Requires -syslog")
+	fs.StringVar(&f.resumeFrom, "resumeFrom", "", "Optional: Path to a checkpoint file. Each image is recorded in it as soon as it has been scanned, and images already recorded by a previous run are not scanned again")
+	fs.StringVar(&f.namespace, "namespace", "", "Optional: Only scan the pods in this namespace. Faster, and only requires permission to list pods in the namespace rather than cluster-wide")
+	fs.IntVar(&f.cleanupConcurrency, "cleanupConcurrency", 1, "Optional: Maximum number of images to remove at once when -deferCleanup is set")
+	fs.BoolVar(&f.failFast, "failFast", false, "Optional: Abort the scan when an ECR image is in a region which cannot be authenticated, rather than skipping it and reporting the missing regions at the end")
+	fs.Float64Var(&f.maxImageSizeMB, "maxImageSizeMB", 0, "Optional: Report images larger than this many MB as oversized. Uses the local image size, or the compressed size from the registry manifest when -remote is set. 0 disables the check")
+	fs.BoolVar(&f.lowMemory, "lowMemory", false, "Optional: Minimise memory usage on constrained machines. Scans one image at a time and writes each offending image to a JSON lines file as soon as it is found, rather than holding the results in memory")
+	fs.BoolVar(&f.nodeImageStats, "nodeImageStats", false, "Optional: Read the images cached on each node from node.status.images, to log how many running images are cached and to size them for -maxDiskGB without querying the registry. Images are still pulled to be scanned unless -scanNodeCachedImages is set")
+	fs.BoolVar(&f.scanNodeCachedImages, "scanNodeCachedImages", false, "Optional: Read the history of the running images which are cached on the nodes (node.status.images) directly from their registries instead of pulling them. Only the images not cached on any node are pulled")
+	fs.BoolVar(&f.nodeInventory, "nodeInventory", false, "Optional: Write an inventory of the running images, the nodes they are cached on and their sizes from node.status.images, and exit without pulling or scanning any images")
+	fs.BoolVar(&f.runOnly, "runOnly", false, "Optional: Only match keywords against history entries created by RUN instructions, ignoring COPY/ADD paths, ENV, LABEL etc.")
+	fs.BoolVar(&f.allContexts, "allContexts", false, "Optional: Scan every context in ${HOME}/.kube/config in turn instead of -clusterK8sContextName, skipping contexts which cannot be reached, and write a consolidated report")
+	fs.IntVar(&f.maxImages, "maxImages", 0, "Optional: Only scan this many of the unique images, for a quick spot-check of a large cluster. Images are sorted by ref and truncated unless -seed is set. 0 scans every image")
+	fs.Int64Var(&f.seed, "seed", 0, "Optional: Select a random sample of images when -maxImages is set, using this seed so the sample can be repeated. 0 selects the first images sorted by ref")
+	fs.StringVar(&f.ecrRegionOverrideFlag, "ecrRegionOverride", "", "Optional: Comma separated list of '<host-prefix>=<region>' pairs. ECR images whose ref starts with a host prefix are authenticated against that region rather than the region in their host")
+	fs.StringVar(&f.baselinePath, "baseline", "", "Optional: Path to a baseline of results keyed by image digest. Images whose registry digest is unchanged since it was written are not pulled, their results are carried forward. The baseline is rewritten at the end of the scan")
+	fs.BoolVar(&f.fullRescan, "fullRescan", false, "Optional: Scan every image even if its digest is in the -baseline, and then rewrite the baseline")
+	fs.BoolVar(&f.redact, "redact", false, "Optional: Mask the matched token, and any other high entropy tokens, in the match contexts, matched layer instructions and matched annotation/label values, so the results are safe to share when the keywords target secrets")
+	fs.BoolVar(&f.outputAppend, "outputAppend", true, "Optional: Append to results files which already exist, e.g. when re-running within the same timestamp. Set to false to overwrite them instead. Defaults to false when -timestampFormat has no time in it, as the results paths are then the same on every run")
+	fs.BoolVar(&f.checkRunsAsRoot, "checkRunsAsRoot", false, "Optional: Also report the images which run as root, as their config has no USER or sets it to root/UID 0")
+	fs.BoolVar(&f.dockerSave, "dockerSave", false, "Optional: Read the history of each pulled image from a 'docker save' archive written to a temporary file, rather than the image history API. For daemons which restrict the history API")
+	fs.StringVar(&f.countMode, "countMode", docker_image_history.CountModeLayers, "Optional: Metric reported for each matched keyword. Either 'layers' (the number of history layers it is in) or 'occurrences' (its total number of occurrences, counting repeats in the same layer)")
+	fs.StringVar(&f.registryMirrorFlag, "registryMirror", "", "Optional: Comma separated list of '<registry>=<mirror>' pairs (e.g. 'docker.io=mirror.internal/docker.io'). Images from these registries are read through the mirror, but reported with their original ref")
+	fs.StringVar(&f.podDetailFieldsFlag, "podDetailFields", strings.Join(docker_image_history.DefaultPodDetailFields, ","), "Optional: Comma separated list of the pod details to include in the results. Any of: "+strings.Join(docker_image_history.AllPodDetailFields, ", "))
+	fs.StringVar(&f.keywordRulesPath, "keywordRules", "", "Optional: Path to a JSON file of named keyword rules combining terms with all (AND), any (OR) and none (NOT). Images triggering a rule are offending, and the rules they triggered are reported")
+	fs.StringVar(&f.groupBy, "groupBy", docker_image_history.GroupByImage, "Optional: Layout of the offending images results. Either 'image' (a line per image listing its keywords) or 'keyword' (a section per keyword listing all the images which matched it and their pods)")
+	fs.StringVar(&f.workload, "workload", "", "Optional: Only scan the container images in the pod template of this workload, as '<kind>/<name>' (e.g. 'deployment/my-app'). Kind is one of deployment, statefulset, daemonset, job or cronjob. Requires -namespace")
+	fs.BoolVar(&f.failOnUnparseableRefs, "failOnUnparseableRefs", false, "Optional: Exit with an error, before scanning any images, if the registry host of any image ref in the cluster cannot be parsed. The refs are written to the unparseable image refs report")
+	fs.BoolVar(&f.debugAuth, "debugAuth", false, "Optional: Log the non-secret metadata of each ECR auth token (region, proxy endpoint and expiry) to debug auth issues. The token and password are never logged")
+	fs.BoolVar(&f.decodeBase64, "decodeBase64", false, "Optional: Also match keywords against the base64 decoded form of long tokens in each history entry, to catch encoded secrets. Keywords only found after decoding are reported as decoded. Slower")
+	fs.BoolVar(&f.noPullIfPresent, "noPullIfPresent", false, "Optional: Do not pull images which are already present in the local Docker instance, inspect the local copy instead. Images which were already present are not removed after the scan")
+	fs.BoolVar(&f.summaryOnly, "summaryOnly", false, "Optional: For CI gating. Print only a single JSON object with the offending and non ECR image counts and whether the scan was clean to stdout, write no results files, and exit with code 3 if any offending images were found")
+	fs.BoolVar(&f.podEvents, "podEvents", false, "Optional: Also scan the images of pods which ran recently but no longer exist (e.g. cleaned up Job pods), found in the kubelet's image pull events. They are marked as historical in the results")
+	fs.StringVar(&f.crdImageSourcesPath, "crdImageSources", "", "Optional: Path to a JSON file of custom resources (e.g. Argo Workflows) to also discover images from, each as a group, version and resource and a list of JSONPath expressions selecting its images. See README")
+	fs.BoolVar(&f.retryCleanup, "retryCleanup", false, "Optional: Retry removing any image pulled during the scan which is still present locally once the scan has finished, forcing its removal. Images which still cannot be removed are written to the leaked images report")
+	fs.BoolVar(&f.regexKeywords, "regexKeywords", false, "Optional: Match each keyword as a Go regular expression (e.g. 'curl\\s+https?://') rather than a substring. Matches are still reported by the original pattern. Invalid patterns fail before any images are scanned")
+	fs.BoolVar(&f.forcePull, "forcePull", false, "Optional: Always pull every image, even if it is already present in the local Docker instance. By default present images are reused if their ref is pinned by digest or a tag other than 'latest', whilst images with a mutable tag ('latest' or no tag) are still pulled in case the tag has moved. Set -forcePull=false explicitly to also reuse present images with mutable tags, trading freshness for speed (the same as -noPullIfPresent)")
+	fs.IntVar(&f.pullRetries, "pullRetries", 3, "Optional: Number of times to retry pulling an image after a transient failure (e.g. a timeout or a registry error), with exponential backoff and jitter. Permanent failures such as a missing image or rejected credentials are not retried. Images which still fail are listed in the scan errors")
+	fs.BoolVar(&f.inCluster, "inCluster", false, "Optional: Use the in-cluster service account config instead of a context in ${HOME}/.kube/config, e.g. when running as a CronJob in the cluster being scanned. Detected automatically when -clusterK8sContextName is not set and the tool is running inside a pod")
+	fs.StringVar(&f.includeNamespacesFlag, "includeNamespaces", "", "Optional: Comma separated list of namespaces to scan, listing the pods in each of them in turn. Cannot be used with -namespace or -excludeNamespaces")
+	fs.StringVar(&f.excludeNamespacesFlag, "excludeNamespaces", "", "Optional: Comma separated list of namespaces to skip, e.g. 'kube-system,kube-public'. Cannot be used with -namespace or -includeNamespaces")
+	fs.BoolVar(&f.dryRun, "dryRun", false, "Optional: List every unique image ref and the pods running it, along with the non ECR images, without pulling or reading any image. Useful to estimate the scan time and spot unexpected registries")
+	fs.StringVar(&f.outputDir, "outputDir", "", "Optional: Directory to write the results files to, created if it does not exist. Defaults to the current working directory")
+	fs.BoolVar(&f.keepImages, "keepImages", false, "Optional: Keep every pulled image in the local Docker instance after it has been scanned instead of removing it, e.g. to rescan or inspect them by hand. The images kept and the disk they use are logged")
+	fs.BoolVar(&f.keepOffendingImages, "keepOffendingImages", false, "Optional: Keep only the pulled images which matched keywords in the local Docker instance, removing the rest as usual. Cannot be used with -keepImages")
+	fs.BoolVar(&f.workloadTemplates, "workloadTemplates", false, "Optional: Also scan the images declared in the pod templates of every Deployment, StatefulSet, DaemonSet and CronJob, including those scaled to zero or without running pods. Requires permission to list them")
+	fs.StringVar(&f.skipImagesFlag, "skipImages", "", "Optional: Comma separated list of image ref patterns to neither pull nor scan, e.g. trusted vendor images. A pattern without wildcards is a prefix (e.g. 'quay.io/vendor/'), otherwise '*' matches any text and '?' a single character (e.g. '*:latest'). Skipped images are listed in the skipped images results")
+	fs.BoolVar(&f.failOnMatch, "failOnMatch", false, "Optional: For CI gating. Exit with code 3 if any image matched a keyword, after all the results files have been written. Exit codes: 0 clean scan, 1 operational error, 2 invalid flags, 3 images matched keywords")
+	fs.DurationVar(&f.pullTimeout, "pullTimeout", 10*time.Minute, "Optional: How long a single image pull can take before it is cancelled as stalled, e.g. '30m' for very large images. Timed out pulls are retried as per -pullRetries")
+	fs.BoolVar(&f.collapseReplicas, "collapseReplicas", false, "Optional: In the text results, collapse the pods of the same workload running the same container into a single entry showing the workload (e.g. 'Deployment/web') and its replica count, instead of listing every replica")
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
 
-	if len(dockerImageKeyWordsFlag) > 0 {
-		dockerImageKeyWords = strings.Split(dockerImageKeyWordsFlag, ",")
+	if len(f.dockerImageKeyWordsFlag) > 0 {
+		f.dockerImageKeyWords = strings.Split(f.dockerImageKeyWordsFlag, ",")
+	}
+	if (len(f.clusterK8sContextName) == 0 && !f.allContexts && !f.inCluster && !docker_image_history.RunningInPod()) || (len(f.dockerImageKeyWords) == 0 && len(f.keywordPoliciesPath) == 0 && len(f.keywordRulesPath) == 0 && !f.preflight && !f.nodeInventory && !f.dryRun) {
+		return f, errors.New("Usage: query-k8s-container-image-history -clusterK8sContextName=<context> [-imagesAccountAWSProfileName=<profile>] -dockerImageKeyWords='keyword1,keyword2'")
+	}
+	if f.allContexts && (len(f.clusterK8sContextName) > 0 || f.preflight || len(f.serveAddress) > 0 || len(f.resumeFrom) > 0 || f.lowMemory) {
+		return f, errors.New("-allContexts cannot be used with -clusterK8sContextName, -preflight, -serve, -resumeFrom or -lowMemory")
+	}
+	if f.inCluster && (len(f.clusterK8sContextName) > 0 || f.allContexts) {
+		return f, errors.New("-inCluster cannot be used with -clusterK8sContextName or -allContexts")
+	}
+	if f.scanNodeCachedImages && f.remote {
+		return f, errors.New("-scanNodeCachedImages cannot be used with -remote as no images are pulled")
+	}
+	if f.dockerSave && f.remote {
+		return f, errors.New("-dockerSave cannot be used with -remote as no images are pulled")
+	}
+	if f.pullTimeout <= 0 {
+		return f, errors.New("-pullTimeout must be positive")
+	}
+	if f.pullRetries < 0 {
+		return f, errors.New("-pullRetries cannot be negative")
+	}
+	if f.fullRescan && len(f.baselinePath) == 0 {
+		return f, errors.New("-fullRescan requires -baseline")
 	}
-	if len(clusterK8sContextName) == 0 || len(imagesAccountAWSProfileName) == 0 || len(dockerImageKeyWords) == 0 {
-		log.Fatalln("Usage: query-k8s-container-image-history -clusterK8sContextName=<context> -imagesAccountAWSProfileName=<profile> -dockerImageKeyWords='keyword1,keyword2'")
+	if f.seed != 0 && f.maxImages <= 0 {
+		return f, errors.New("-seed requires -maxImages")
 	}
-	if len(ecrRegionsFlag) > 0 {
-		ecrRegions = strings.Split(ecrRegionsFlag, ",")
-		if !docker_image_history.ValidateAWSRegions(ecrRegions) {
-			log.Fatalf("One or more parsed AWS regions are invalid: %v, Allowed regions: %v", ecrRegions, docker_image_history.AllAWSRegions)
+	if f.syslogOnly && !f.syslog {
+		return f, errors.New("-syslogOnly requires -syslog")
+	}
+	if (len(f.syslogNetwork) > 0) != (len(f.syslogAddress) > 0) {
+		return f, errors.New("-syslogNetwork and -syslogAddress must be set together")
+	}
+	if f.lowMemory && (f.outputFormat == docker_image_history.OutputFormatJSON || f.syslog) {
+		return f, errors.New("-lowMemory cannot be used with -outputFormat=json or -syslog as they need all the results in memory")
+	}
+	if f.deferCleanup && f.maxDiskGB > 0 {
+		return f, errors.New("-maxDiskGB cannot be used with -deferCleanup as no disk space is freed until the end of the scan")
+	}
+	outputAppendSet, forcePullSet := false, false
+	fs.Visit(func(fl *flag.Flag) {
+		outputAppendSet = outputAppendSet || fl.Name == "outputAppend"
+		forcePullSet = forcePullSet || fl.Name == "forcePull"
+	})
+	if f.forcePull && f.noPullIfPresent {
+		return f, errors.New("-forcePull cannot be used with -noPullIfPresent")
+	}
+	// Explicitly opting out of forced pulls also reuses present images with mutable tags
+	if forcePullSet && !f.forcePull {
+		f.noPullIfPresent = true
+	}
+	if !outputAppendSet && docker_image_history.IsFixedTimestampFormat(f.timestampFormat) {
+		log.Printf("-timestampFormat '%s' gives the same results paths on every run, existing results files will be overwritten", f.timestampFormat)
+		f.outputAppend = false
+	}
+	if len(f.sinceFlag) > 0 {
+		var err error
+		if f.since, err = docker_image_history.ParseSince(f.sinceFlag); err != nil {
+			return f, fmt.Errorf("Invalid -since: %w", err)
+		}
+		log.Printf("Only scanning images created after: %s", f.since.Format(time.RFC3339))
+	}
+	if len(f.includeNamespacesFlag) > 0 && len(f.excludeNamespacesFlag) > 0 {
+		return f, errors.New("-includeNamespaces cannot be used with -excludeNamespaces")
+	}
+	if len(f.namespace) > 0 && (len(f.includeNamespacesFlag) > 0 || len(f.excludeNamespacesFlag) > 0) {
+		return f, errors.New("-namespace cannot be used with -includeNamespaces or -excludeNamespaces")
+	}
+	if len(f.skipImagesFlag) > 0 {
+		f.skipImages = strings.Split(f.skipImagesFlag, ",")
+	}
+	if len(f.includeNamespacesFlag) > 0 {
+		f.includeNamespaces = strings.Split(f.includeNamespacesFlag, ",")
+	}
+	if len(f.excludeNamespacesFlag) > 0 {
+		f.excludeNamespaces = strings.Split(f.excludeNamespacesFlag, ",")
+	}
+	if len(f.insecureRegistriesFlag) > 0 {
+		f.insecureRegistries = strings.Split(f.insecureRegistriesFlag, ",")
+	}
+	if f.outputFormat != docker_image_history.OutputFormatText && f.outputFormat != docker_image_history.OutputFormatJSON {
+		return f, fmt.Errorf("Unsupported output format '%s'. Allowed: %s, %s", f.outputFormat, docker_image_history.OutputFormatText, docker_image_history.OutputFormatJSON)
+	}
+	if f.countMode != docker_image_history.CountModeLayers && f.countMode != docker_image_history.CountModeOccurrences {
+		return f, fmt.Errorf("Unsupported count mode '%s'. Allowed: %s, %s", f.countMode, docker_image_history.CountModeLayers, docker_image_history.CountModeOccurrences)
+	}
+	if len(f.workload) > 0 {
+		if f.podEvents {
+			return f, errors.New("-podEvents cannot be used with -workload")
+		}
+		if len(f.crdImageSourcesPath) > 0 {
+			return f, errors.New("-crdImageSources cannot be used with -workload")
+		}
+		if len(f.namespace) == 0 {
+			return f, errors.New("-workload requires -namespace")
+		}
+		if _, _, err := docker_image_history.ParseWorkload(f.workload); err != nil {
+			return f, fmt.Errorf("Invalid -workload: %w", err)
+		}
+	}
+	if f.groupBy != docker_image_history.GroupByImage && f.groupBy != docker_image_history.GroupByKeyword {
+		return f, fmt.Errorf("Unsupported grouping '%s'. Allowed: %s, %s", f.groupBy, docker_image_history.GroupByImage, docker_image_history.GroupByKeyword)
+	}
+	if f.summaryOnly && (f.lowMemory || f.allContexts || f.inventoryOutput || f.nodeInventory || len(f.expectedImagesPath) > 0) {
+		return f, errors.New("-summaryOnly cannot be used with -lowMemory, -allContexts, -inventoryOutput, -nodeInventory or -expectedImages as they write results files")
+	}
+	if f.dryRun && (f.summaryOnly || f.nodeInventory || f.preflight) {
+		return f, errors.New("-dryRun cannot be used with -summaryOnly, -nodeInventory or -preflight")
+	}
+	if f.workloadTemplates && len(f.workload) > 0 {
+		return f, errors.New("-workloadTemplates cannot be used with -workload")
+	}
+	if f.keepImages && f.keepOffendingImages {
+		return f, errors.New("-keepImages cannot be used with -keepOffendingImages")
+	}
+	if (f.keepImages || f.keepOffendingImages) && f.remote {
+		return f, errors.New("-keepImages and -keepOffendingImages cannot be used with -remote as no images are pulled")
+	}
+	if f.lowMemory && f.groupBy == docker_image_history.GroupByKeyword {
+		return f, errors.New("-lowMemory cannot be used with -groupBy=keyword as grouping needs all the results in memory")
+	}
+	if (len(f.dockerTLSCert) > 0) != (len(f.dockerTLSKey) > 0) {
+		return f, errors.New("-dockerTLSCert and -dockerTLSKey must be set together")
+	}
+	f.podDetailFields = strings.Split(f.podDetailFieldsFlag, ",")
+	if !docker_image_history.ValidatePodDetailFields(f.podDetailFields) {
+		return f, fmt.Errorf("One or more pod detail fields are invalid: %v, Allowed fields: %v", f.podDetailFields, docker_image_history.AllPodDetailFields)
+	}
+	if len(f.registryMirrorFlag) > 0 {
+		var err error
+		if f.registryMirrors, err = docker_image_history.ParseRegistryMirrors(f.registryMirrorFlag); err != nil {
+			return f, fmt.Errorf("Invalid -registryMirror: %w", err)
+		}
+	}
+	if len(f.ecrRegionOverrideFlag) > 0 {
+		var err error
+		if f.ecrRegionOverrides, err = docker_image_history.ParseECRRegionOverrides(f.ecrRegionOverrideFlag); err != nil {
+			return f, fmt.Errorf("Invalid -ecrRegionOverride: %w", err)
+		}
+	}
+	if len(f.ecrRegionsFlag) > 0 {
+		f.ecrRegions = strings.Split(f.ecrRegionsFlag, ",")
+		if !docker_image_history.ValidateAWSRegions(f.ecrRegions) {
+			return f, fmt.Errorf("One or more parsed AWS regions are invalid: %v, Allowed regions: %v", f.ecrRegions, docker_image_history.AllAWSRegions)
 		}
-	} else {
-		log.Println("No AWS regions have been configured via the ecrRegions flag. Only public registries will be allowed")
 	}
+	return f, nil
 }
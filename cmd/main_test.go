@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestRunInvalidFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "unknown flag", args: []string{"-notAFlag"}, wantErr: "flag provided but not defined"},
+		{name: "no context or keywords", args: nil, wantErr: "Usage: query-k8s-container-image-history"},
+		{name: "no keywords", args: []string{"-clusterK8sContextName=test"}, wantErr: "Usage: query-k8s-container-image-history"},
+		{
+			name:    "allContexts with a context",
+			args:    []string{"-allContexts", "-clusterK8sContextName=test", "-dockerImageKeyWords=curl"},
+			wantErr: "-allContexts cannot be used with",
+		},
+		{name: "allContexts with lowMemory", args: []string{"-allContexts", "-lowMemory", "-dockerImageKeyWords=curl"}, wantErr: "-allContexts cannot be used with"},
+		{
+			name:    "summaryOnly with allContexts",
+			args:    []string{"-allContexts", "-summaryOnly", "-dockerImageKeyWords=curl"},
+			wantErr: "-summaryOnly cannot be used with",
+		},
+		{
+			name:    "inCluster with a context",
+			args:    []string{"-inCluster", "-clusterK8sContextName=test", "-dockerImageKeyWords=curl"},
+			wantErr: "-inCluster cannot be used with",
+		},
+		{
+			name:    "lowMemory with JSON output",
+			args:    []string{"-clusterK8sContextName=test", "-lowMemory", "-outputFormat=json", "-dockerImageKeyWords=curl"},
+			wantErr: "-lowMemory cannot be used with",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("expected exit code 2, got %d", code)
+			}
+			if !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("expected the error '%s', got: %s", tt.wantErr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-h"}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "-dockerImageKeyWords") {
+		t.Errorf("expected the flag usage to be printed, got: %s", stderr.String())
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	imageRef := pushTestImage(t, "/bin/sh -c curl -sSL https://example.com/install.sh | sh")
+	setupTestCluster(t, imageRef)
+
+	tests := []struct {
+		name     string
+		keywords string
+		flags    []string
+		wantCode int
+		// wantStdout is printed by -summaryOnly
+		wantStdout string
+	}{
+		{name: "match without failOnMatch", keywords: "curl", wantCode: 0},
+		{name: "failOnMatch with a match", keywords: "curl", flags: []string{"-failOnMatch"}, wantCode: 3},
+		{name: "failOnMatch without a match", keywords: "wget", flags: []string{"-failOnMatch"}, wantCode: 0},
+		{name: "summaryOnly with a match", keywords: "curl", flags: []string{"-summaryOnly"}, wantCode: 3, wantStdout: `"offendingCount":1`},
+		{name: "summaryOnly without a match", keywords: "wget", flags: []string{"-summaryOnly"}, wantCode: 0, wantStdout: `"clean":true`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-clusterK8sContextName=test", "-remote", "-dockerImageKeyWords=" + tt.keywords, "-outputDir=" + t.TempDir()}, tt.flags...)
+			var stdout, stderr bytes.Buffer
+			if code := run(args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d. Logs: %s", tt.wantCode, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("expected '%s' in stdout, got: %s", tt.wantStdout, stdout.String())
+			}
+		})
+	}
+}
+
+// pushTestImage pushes an image whose history has a single layer created by createdBy to an in-memory registry, returning its ref
+func pushTestImage(t *testing.T, createdBy string) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile = configFile.DeepCopy()
+	configFile.History = []v1.History{{CreatedBy: createdBy}}
+	if img, err = mutate.ConfigFile(img, configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	registryURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := registryURL.Host + "/app:1.0"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img); err != nil {
+		t.Fatalf("pushing test image: %s", err)
+	}
+	return imageRef
+}
+
+// setupTestCluster serves a K8s API with a single pod running the image, and writes a kubeconfig with a 'test' context for it into a
+// temporary HOME. Any other list is empty
+func setupTestCluster(t *testing.T, imageRef string) {
+	t.Helper()
+	pods := fmt.Sprintf(`{"kind":"PodList","apiVersion":"v1","items":[{"metadata":{"name":"app-0","namespace":"default"},`+
+		`"spec":{"containers":[{"name":"app","image":"%s"}]},"status":{"phase":"Running"}}]}`, imageRef)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/pods") {
+			_, _ = w.Write([]byte(pods))
+			return
+		}
+		_, _ = w.Write([]byte(`{"items":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	home := t.TempDir()
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+users:
+- name: test
+  user:
+    token: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`, server.URL)
+	if err := os.MkdirAll(filepath.Join(home, ".kube"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".kube", "config"), []byte(kubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, "aws-credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
@@ -0,0 +1,214 @@
+package docker_image_history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+// contextResult is the outcome of scanning a single context when scanning every context in the kubeconfig
+// cfg is nil if the context could not be configured. err is set if the context was skipped
+type contextResult struct {
+	contextName string
+	cfg         *Config
+	err         error
+}
+
+// jsonAllContextsReport is the consolidated JSON results document when every context in the kubeconfig is scanned
+type jsonAllContextsReport struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	GeneratedAt   time.Time         `json:"generatedAt"`
+	Keywords      []string          `json:"keywords"`
+	Contexts      []jsonContextScan `json:"contexts"`
+}
+
+// jsonContextScan is the report of a single context in the consolidated JSON results, or the reason it was skipped
+type jsonContextScan struct {
+	Context string      `json:"context"`
+	Error   string      `json:"error,omitempty"`
+	Report  *jsonReport `json:"report,omitempty"`
+}
+
+// KubeconfigContexts returns the names of all the contexts in ${HOME}/.kube/config, sorted
+func KubeconfigContexts() ([]string, error) {
+	kubeconfigPath := filepath.Join(homedir.HomeDir(), ".kube", "config")
+	kubeconfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading k8s config file '%s': %w", kubeconfigPath, err)
+	}
+
+	contexts := make([]string, 0, len(kubeconfig.Contexts))
+	for name := range kubeconfig.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// ProcessAllContexts scans every context in the kubeconfig in turn, writing the usual results files for each of them
+// Contexts which cannot be configured, reached or authenticated against are skipped with a warning rather than aborting the others
+// A consolidated report attributing each offending image to its context is written once all the contexts have been scanned, or the
+// context is cancelled. Returns whether any scanned context had offending images, so a CI gate can fail on a match in any cluster
+// Returns an error, after writing the consolidated report of the reasons, if every context was skipped
+func ProcessAllContexts(ctx context.Context, opts Options) (bool, error) {
+	contexts, err := KubeconfigContexts()
+	if err != nil {
+		return false, err
+	}
+	if len(contexts) == 0 {
+		return false, fmt.Errorf("no contexts found in the k8s config file")
+	}
+	log.Printf("Scanning %d contexts: %v", len(contexts), contexts)
+
+	results := make([]contextResult, 0, len(contexts))
+	for _, contextName := range contexts {
+		log.Printf("Scanning context '%s'", contextName)
+		result := contextResult{contextName: contextName}
+		// Once cancelled the remaining contexts are not scanned, but the results of those already scanned are still written
+		if err := ctx.Err(); err != nil {
+			result.err = fmt.Errorf("scan cancelled: %w", err)
+			results = append(results, result)
+			continue
+		}
+
+		contextOpts := opts
+		contextOpts.ClusterK8sContextName = contextName
+		result.cfg, result.err = NewConfig(ctx, contextOpts)
+		if result.err == nil {
+			result.err = result.cfg.ProcessAllImagesHistoryForKeywordsContext(ctx)
+		}
+		if result.err != nil {
+			log.Printf("WARNING: skipping context '%s': %s", contextName, result.err)
+		}
+		results = append(results, result)
+	}
+
+	timestampFormat := opts.TimestampFormat
+	if len(timestampFormat) == 0 {
+		timestampFormat = DefaultTimestampFormat
+	}
+	timestamp := time.Now().Format(timestampFormat)
+	if err := ensureOutputDir(opts.OutputDir); err != nil {
+		return false, err
+	}
+
+	if opts.OutputFormat == OutputFormatJSON {
+		err = outputAllContextsJSON(results, opts.DockerImageKeyWords, filepath.Join(opts.OutputDir, fmt.Sprintf("results-all-contexts-%s.json", timestamp)))
+	} else {
+		err = outputAllContexts(results, filepath.Join(opts.OutputDir, fmt.Sprintf("offending-images-all-contexts-%s.txt", timestamp)), opts.OutputAppend)
+	}
+	if err != nil {
+		return false, err
+	}
+	if scannedContexts(results) == 0 {
+		return false, fmt.Errorf("none of the %d contexts could be scanned", len(results))
+	}
+	return anyContextMatched(results), nil
+}
+
+// scannedContexts returns the number of contexts which were scanned rather than skipped
+func scannedContexts(results []contextResult) int {
+	scanned := 0
+	for _, r := range results {
+		if r.err == nil {
+			scanned++
+		}
+	}
+	return scanned
+}
+
+// anyContextMatched returns whether any of the scanned contexts had offending images
+func anyContextMatched(results []contextResult) bool {
+	for _, r := range results {
+		if r.err == nil && !r.cfg.Clean() {
+			return true
+		}
+	}
+	return false
+}
+
+// outputAllContexts writes the offending images of every scanned context to a single file, prefixing each image with its context
+// The header records the contexts which were skipped and why
+func outputAllContexts(results []contextResult, path string, outputAppend bool) error {
+	f, err := os.OpenFile(path, resultsFileFlags(outputAppend), 0644)
+	if err != nil {
+		return fmt.Errorf("opening file '%s': %w", path, err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", path, err)
+		}
+	}(f)
+
+	header := fmt.Sprintf("# tool version: %s\n# generated at: %s\n", Version, time.Now().UTC().Format(time.RFC3339))
+	for _, r := range results {
+		if r.err != nil {
+			header += fmt.Sprintf("# context %s: skipped (%s)\n", r.contextName, r.err)
+			continue
+		}
+		counts := r.cfg.runConfig().Counts
+		header += fmt.Sprintf("# context %s: %d images, %d offending\n", r.contextName, counts.Images, counts.OffendingImages)
+	}
+	if _, err = f.WriteString(header); err != nil {
+		return fmt.Errorf("writing header to '%s': %w", path, err)
+	}
+
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		for _, i := range r.cfg.offendingDockerImages {
+			_, err = f.WriteString(fmt.Sprintf("%s\t%s\t", r.contextName, i.imageRef))
+			for _, match := range r.cfg.formatPods(r.cfg.dockerImages[i.imageRef]) {
+				_, err = f.WriteString(fmt.Sprintf("(%s, matched-keywords: %v, absent-keywords: %v) ", match, i.matchedKeywords, i.absentKeywords))
+			}
+			_, err = f.WriteString("\n")
+			if err != nil {
+				return fmt.Errorf("writing results to '%s': %w", path, err)
+			}
+		}
+	}
+	log.Printf("Scanned %d of %d contexts. Consolidated results written to: %s", scannedContexts(results), len(results), path)
+
+	return nil
+}
+
+// outputAllContextsJSON writes the JSON report of every scanned context, and the reason any context was skipped, to a single JSON file
+func outputAllContextsJSON(results []contextResult, keywords []string, path string) error {
+	report := jsonAllContextsReport{
+		SchemaVersion: jsonSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Keywords:      keywords,
+		Contexts:      make([]jsonContextScan, 0, len(results)),
+	}
+	for _, r := range results {
+		scan := jsonContextScan{Context: r.contextName}
+		if r.err != nil {
+			scan.Error = r.err.Error()
+		} else {
+			contextReport := r.cfg.buildJSONReport()
+			scan.Report = &contextReport
+		}
+		report.Contexts = append(report.Contexts, scan)
+	}
+
+	jsonBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling consolidated results into JSON: %w", err)
+	}
+	if err = os.WriteFile(path, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("writing results to '%s': %w", path, err)
+	}
+	log.Printf("Consolidated JSON results written to: %s", path)
+
+	return nil
+}
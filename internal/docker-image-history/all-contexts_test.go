@@ -0,0 +1,43 @@
+package docker_image_history
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAnyContextMatched(t *testing.T) {
+	clean := newTestConfig(nil, "curl")
+	offending := newTestConfig(nil, "curl")
+	offending.recordResult(offendingDockerImage{imageRef: "app:1.0", matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+
+	tests := []struct {
+		name    string
+		results []contextResult
+		want    bool
+	}{
+		{name: "every context clean", results: []contextResult{{contextName: "a", cfg: clean}, {contextName: "b", cfg: clean}}},
+		{name: "one context matched", results: []contextResult{{contextName: "a", cfg: clean}, {contextName: "b", cfg: offending}}, want: true},
+		{name: "skipped context is ignored", results: []contextResult{{contextName: "a", err: errors.New("unreachable")}, {contextName: "b", cfg: clean}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := anyContextMatched(tt.results); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScannedContexts(t *testing.T) {
+	results := []contextResult{
+		{contextName: "a", err: errors.New("unreachable")},
+		{contextName: "b", cfg: newTestConfig(nil, "curl")},
+		{contextName: "c", err: errors.New("scan cancelled")},
+	}
+	if got := scannedContexts(results); got != 1 {
+		t.Errorf("expected 1 scanned context, got %d", got)
+	}
+	if got := scannedContexts(results[:1]); got != 0 {
+		t.Errorf("expected no scanned contexts, got %d", got)
+	}
+}
@@ -0,0 +1,58 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// matchMetadataForKeyWords checks an image's manifest annotations and config labels in its registry for the (non-negated, untargeted) keywords
+// Matches are added to the result, recording which annotation or label matched each keyword
+func (c *Config) matchMetadataForKeyWords(ctx context.Context, imageRef string, result *offendingDockerImage) error {
+	img, err := c.remoteImage(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return fmt.Errorf("fetching remote manifest for '%s': %w", imageRef, err)
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("fetching remote image config for '%s': %w", imageRef, err)
+	}
+
+	metadata := make([]string, 0, len(manifest.Annotations)+len(configFile.Config.Labels))
+	for k, v := range manifest.Annotations {
+		metadata = append(metadata, fmt.Sprintf("annotation %s=%s", k, v))
+	}
+	for k, v := range configFile.Config.Labels {
+		metadata = append(metadata, fmt.Sprintf("label %s=%s", k, v))
+	}
+	sort.Strings(metadata)
+
+	for _, keyword := range c.keywordsForImage(imageRef) {
+		if _, negated := parseNegatedKeyword(keyword); negated {
+			continue
+		}
+		if field, _ := parseKeywordField(keyword); len(field) > 0 {
+			continue
+		}
+		for _, m := range metadata {
+			if c.containsKeyword(m, keyword) {
+				if result.matchedMetadata == nil {
+					result.matchedMetadata = make(map[string][]string)
+				}
+				if c.redact {
+					m = redactMetadata(m)
+				}
+				result.matchFound = true
+				result.imageRef = imageRef
+				result.matchedMetadata[keyword] = append(result.matchedMetadata[keyword], m)
+				_, _ = fmt.Fprintf(c.findings, "FOUND (%s): %+v\n", m, result)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package docker_image_history
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// base64Encodings are the encodings a token is decoded with, in order. Padding is split off tokens with the other delimiters
+var base64Encodings = []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding}
+
+// decodeBase64Tokens returns the decoded text of every token in a history command which is valid base64 and decodes to printable text,
+// one per line. Only tokens at least minSecretTokenLength long are decoded, as shorter tokens are rarely encoded values
+// Returns an empty string if no token decodes
+func decodeBase64Tokens(text string) string {
+	var decoded []string
+	for _, token := range strings.FieldsFunc(text, func(r rune) bool { return r < utf8.RuneSelf && isTokenDelimiter(byte(r)) }) {
+		if decodedText, ok := decodeBase64Token(token); ok {
+			decoded = append(decoded, decodedText)
+		}
+	}
+	return strings.Join(decoded, "\n")
+}
+
+// decodeBase64Token returns the decoded text of a token, and whether it is at least minSecretTokenLength long, valid base64 and decodes
+// to printable text
+func decodeBase64Token(token string) (string, bool) {
+	if len(token) < minSecretTokenLength {
+		return "", false
+	}
+	for _, encoding := range base64Encodings {
+		decodedBytes, err := encoding.DecodeString(token)
+		if err == nil && isPrintableText(decodedBytes) {
+			return string(decodedBytes), true
+		}
+	}
+	return "", false
+}
+
+// isPrintableText returns whether decoded bytes are valid UTF-8 text without control characters (other than whitespace), which rules out
+// random tokens that happen to be valid base64
+func isPrintableText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
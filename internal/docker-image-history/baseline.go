@@ -0,0 +1,179 @@
+package docker_image_history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/sync/errgroup"
+)
+
+// baselineFile is the results of a previous run keyed by the image digest in the registry at the time it was scanned, along with
+// the match settings they were generated with
+type baselineFile struct {
+	matchSettings
+	Images map[string]checkpointEntry `json:"images"`
+}
+
+// applyBaseline looks up the current digest of each image in its registry and carries forward the results from the baseline for the
+// images whose digest has not changed since, so only the changed images are pulled and scanned
+// Images whose digest cannot be looked up are scanned as usual. With fullRescan set the digests are still looked up, so the baseline
+// can be rewritten, but every image is scanned
+func (c *Config) applyBaseline(ctx context.Context) error {
+	c.imageDigests = make(map[string]string)
+	c.baselineResults = make(map[string]checkpointEntry)
+	c.carriedForward = make(map[string]bool)
+
+	baseline, err := c.loadBaseline()
+	if err != nil {
+		return err
+	}
+
+	c.resolveImageDigests(ctx)
+
+	if c.fullRescan || baseline == nil {
+		log.Printf("Scanning all images. The baseline '%s' will be written at the end of the scan", c.baselinePath)
+		return nil
+	}
+
+	for _, image := range c.imageRefs() {
+		digest, ok := c.imageDigests[image]
+		if !ok || c.skipScan(image) {
+			continue
+		}
+		e, unchanged := baseline.Images[digest]
+		if !unchanged {
+			continue
+		}
+		c.carriedForward[image] = true
+		c.recordResult(offendingDockerImage{
+			matchFound:          e.MatchFound,
+			imageRef:            image,
+			matchedKeywords:     e.MatchedKeywords,
+			absentKeywords:      e.AbsentKeywords,
+			matchedMetadata:     e.MatchedMetadata,
+			matchContexts:       e.MatchContexts,
+			matchedInstructions: e.MatchedInstructions,
+			matchedLayers:       e.MatchedLayers,
+			matchedRules:        e.MatchedRules,
+			decodedKeywords:     e.DecodedKeywords,
+		})
+	}
+	log.Printf("Baseline '%s': %d images are unchanged and their results have been carried forward, %d images will be scanned",
+		c.baselinePath, len(c.carriedForward), len(c.dockerImages)-len(c.carriedForward))
+
+	return nil
+}
+
+// loadBaseline reads the baseline file. Returns nil if it does not exist yet, or was generated with different match settings
+func (c *Config) loadBaseline() (*baselineFile, error) {
+	baselineBytes, err := os.ReadFile(c.baselinePath)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Baseline '%s' does not exist yet", c.baselinePath)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading baseline file '%s': %w", c.baselinePath, err)
+	}
+
+	var baseline baselineFile
+	if err = json.Unmarshal(baselineBytes, &baseline); err != nil {
+		return nil, fmt.Errorf("parsing baseline file '%s': %w", c.baselinePath, err)
+	}
+	settings, err := c.currentMatchSettings()
+	if err != nil {
+		return nil, err
+	}
+	if diffs := settings.differences(baseline.matchSettings); len(diffs) > 0 {
+		log.Printf("WARNING: baseline '%s' was generated with different match settings, so its results may be stale. Ignoring it. This run uses: %s",
+			c.baselinePath, strings.Join(diffs, ", "))
+		return nil, nil
+	}
+	return &baseline, nil
+}
+
+// resolveImageDigests looks up the digest each image's tag currently points to with a HEAD request to its registry
+// Failed lookups are logged and the image is left without a digest
+func (c *Config) resolveImageDigests(ctx context.Context) {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.remoteConcurrency)
+
+	for _, image := range c.imageRefs() {
+		image := image
+		if c.skipScan(image) {
+			continue
+		}
+		g.Go(func() error {
+			digest, err := c.registryDigest(ctx, image)
+			if err != nil {
+				log.Printf("unable to look up the registry digest of '%s', it will be scanned: %s", image, err)
+				return nil
+			}
+			c.mu.Lock()
+			c.imageDigests[image] = digest
+			c.mu.Unlock()
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// registryDigest returns the digest of the manifest (or index) which an image ref currently points to in its registry
+func (c *Config) registryDigest(ctx context.Context, imageReference string) (string, error) {
+	sourceRef := c.sourceRef(imageReference)
+	ref, err := parseImageRef(sourceRef, sliceContains(c.insecureRegistries, registryHost(sourceRef)))
+	if err != nil {
+		return "", fmt.Errorf("parsing image reference '%s': %w", imageReference, err)
+	}
+
+	authOption, err := c.remoteAuthOption(ctx, sourceRef)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithTransport(c.registryTransport), authOption)
+	if err != nil {
+		return "", fmt.Errorf("fetching the manifest digest of '%s': %w", imageReference, err)
+	}
+	return desc.Digest.String(), nil
+}
+
+// recordBaselineResult stores the result of an image against its digest, to be written to the baseline at the end of the scan
+// Must be called whilst holding c.mu
+func (c *Config) recordBaselineResult(e checkpointEntry) {
+	digest, ok := c.imageDigests[e.ImageRef]
+	if !ok {
+		return
+	}
+	c.baselineResults[digest] = e
+}
+
+// writeBaseline replaces the baseline with the results of this run, so the next run only scans the images which change after it
+// Images which are no longer running, or were not scanned successfully, are dropped from it
+func (c *Config) writeBaseline() error {
+	settings, err := c.currentMatchSettings()
+	if err != nil {
+		return err
+	}
+	baselineBytes, err := json.MarshalIndent(baselineFile{matchSettings: settings, Images: c.baselineResults}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling baseline into JSON: %w", err)
+	}
+
+	// Written to a temporary file first so a failed write does not lose the previous baseline
+	tmpPath := c.baselinePath + ".tmp"
+	if err = os.WriteFile(tmpPath, baselineBytes, 0644); err != nil {
+		return fmt.Errorf("writing baseline to '%s': %w", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, c.baselinePath); err != nil {
+		return fmt.Errorf("replacing baseline '%s': %w", c.baselinePath, err)
+	}
+	log.Printf("Baseline of %d image digests written to: %s", len(c.baselineResults), c.baselinePath)
+
+	return nil
+}
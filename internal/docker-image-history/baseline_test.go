@@ -0,0 +1,53 @@
+package docker_image_history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBaselineMatchSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(c *Config)
+		wantReuse bool
+	}{
+		{name: "same settings", change: func(c *Config) {}, wantReuse: true},
+		{name: "different keywords", change: func(c *Config) { c.dockerImageKeyWords = []string{"wget"} }},
+		{name: "case-sensitive", change: func(c *Config) { c.caseSensitive = true }},
+		{name: "regex keywords", change: func(c *Config) { c.regexKeywords = true }},
+		{name: "occurrences count mode", change: func(c *Config) { c.countMode = CountModeOccurrences }},
+		{name: "layers count mode is the default", change: func(c *Config) { c.countMode = CountModeLayers }, wantReuse: true},
+		{name: "decode base64", change: func(c *Config) { c.decodeBase64 = true }},
+		{name: "run only", change: func(c *Config) { c.runOnly = true }},
+		{name: "keyword rules changed", change: func(c *Config) {
+			if err := os.WriteFile(c.keywordRulesPath, []byte("rules: []\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := newTestConfig(nil, "curl")
+			c.baselinePath = filepath.Join(dir, "baseline.json")
+			c.keywordRulesPath = filepath.Join(dir, "rules.yaml")
+			if err := os.WriteFile(c.keywordRulesPath, []byte("rules:\n- name: curl\n  all: [curl]\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c.baselineResults = map[string]checkpointEntry{"sha256:abc": {ImageRef: "app:1.0", MatchFound: true}}
+			if err := c.writeBaseline(); err != nil {
+				t.Fatalf("writing baseline: %s", err)
+			}
+
+			tt.change(c)
+			baseline, err := c.loadBaseline()
+			if err != nil {
+				t.Fatalf("loading baseline: %s", err)
+			}
+			if reused := baseline != nil; reused != tt.wantReuse {
+				t.Errorf("expected the baseline to be reused to be %t, got %t", tt.wantReuse, reused)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package docker_image_history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// checkpointHeader is the first line of a checkpoint file. It records the match settings the results were generated with
+type checkpointHeader struct {
+	matchSettings
+}
+
+// checkpointEntry is an image which has been completely scanned, written as a single line of the checkpoint file
+type checkpointEntry struct {
+	ImageRef            string                        `json:"imageRef"`
+	SkippedReason       string                        `json:"skippedReason,omitempty"`
+	MatchFound          bool                          `json:"matchFound"`
+	MatchedKeywords     map[string]int                `json:"matchedKeywords,omitempty"`
+	AbsentKeywords      []string                      `json:"absentKeywords,omitempty"`
+	MatchedMetadata     map[string][]string           `json:"matchedMetadata,omitempty"`
+	MatchContexts       map[string][]string           `json:"matchContexts,omitempty"`
+	MatchedInstructions map[string][]string           `json:"matchedInstructions,omitempty"`
+	MatchedLayers       map[string][]layerMatchDetail `json:"matchedLayers,omitempty"`
+	MatchedRules        []string                      `json:"matchedRules,omitempty"`
+	DecodedKeywords     []string                      `json:"decodedKeywords,omitempty"`
+}
+
+// openCheckpoint loads the images completed by a previous run from the checkpoint file (if it exists), so they are not scanned again,
+// and then opens the file so the images completed by this run are appended to it as they finish
+// Images which failed to be scanned are not checkpointed, so they are retried
+func (c *Config) openCheckpoint() error {
+	c.checkpointed = make(map[string]bool)
+
+	entries, hasHeader, err := c.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		// Images which are no longer running are dropped from the results
+		if _, running := c.dockerImages[e.ImageRef]; !running {
+			continue
+		}
+		c.checkpointed[e.ImageRef] = true
+		if len(e.SkippedReason) > 0 {
+			c.skippedImages[e.ImageRef] = e.SkippedReason
+			c.progress.processedImages++
+			continue
+		}
+		c.recordResult(offendingDockerImage{
+			matchFound:          e.MatchFound,
+			imageRef:            e.ImageRef,
+			matchedKeywords:     e.MatchedKeywords,
+			absentKeywords:      e.AbsentKeywords,
+			matchedMetadata:     e.MatchedMetadata,
+			matchContexts:       e.MatchContexts,
+			matchedInstructions: e.MatchedInstructions,
+			matchedLayers:       e.MatchedLayers,
+			matchedRules:        e.MatchedRules,
+			decodedKeywords:     e.DecodedKeywords,
+		})
+	}
+	if len(c.checkpointed) > 0 {
+		log.Printf("Resuming from '%s': %d images have already been scanned", c.resumeFrom, len(c.checkpointed))
+	}
+
+	f, err := os.OpenFile(c.resumeFrom, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening checkpoint file '%s': %w", c.resumeFrom, err)
+	}
+	c.checkpoint = &recordFile{File: f}
+
+	// An empty file (e.g. created ahead of the first run) has no header yet, so it is written before the first entry
+	if !hasHeader {
+		settings, err := c.currentMatchSettings()
+		if err != nil {
+			return err
+		}
+		return c.writeCheckpointLine(checkpointHeader{matchSettings: settings})
+	}
+	return nil
+}
+
+// loadCheckpoint reads the completed images from the checkpoint file, and whether it has a header line. Returns nil if the file does not
+// exist yet. A partially written last line (e.g. the previous run crashed mid-write) is ignored
+func (c *Config) loadCheckpoint() ([]checkpointEntry, bool, error) {
+	f, err := os.Open(c.resumeFrom)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("opening checkpoint file '%s': %w", c.resumeFrom, err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", c.resumeFrom, err)
+		}
+	}(f)
+
+	settings, err := c.currentMatchSettings()
+	if err != nil {
+		return nil, false, err
+	}
+
+	entries := make([]checkpointEntry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if lineNumber == 1 {
+			var header checkpointHeader
+			if err = json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				return nil, false, fmt.Errorf("parsing header of checkpoint file '%s': %w", c.resumeFrom, err)
+			}
+			if diffs := settings.differences(header.matchSettings); len(diffs) > 0 {
+				return nil, false, fmt.Errorf("checkpoint file '%s' was created with different match settings, this run uses: %s. Use a new checkpoint file",
+					c.resumeFrom, strings.Join(diffs, ", "))
+			}
+			continue
+		}
+
+		var e checkpointEntry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("ignoring unreadable line %d of checkpoint file '%s': %s", lineNumber, c.resumeFrom, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("reading checkpoint file '%s': %w", c.resumeFrom, err)
+	}
+
+	return entries, lineNumber > 0, nil
+}
+
+// checkpointResult appends a completed image to the checkpoint file. Must be called whilst holding c.mu
+// Failing to write the checkpoint does not fail the scan, the image will just be scanned again on resume
+func (c *Config) checkpointResult(e checkpointEntry) {
+	if c.checkpoint == nil || c.checkpointed[e.ImageRef] {
+		return
+	}
+	if err := c.writeCheckpointLine(e); err != nil {
+		log.Printf("problem checkpointing image '%s': %s", e.ImageRef, err)
+		return
+	}
+	c.checkpointed[e.ImageRef] = true
+}
+
+// writeCheckpointLine writes a single JSON line to the checkpoint file
+func (c *Config) writeCheckpointLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshalling checkpoint line: %w", err)
+	}
+	if err = c.checkpoint.writeRecord(line); err != nil {
+		return fmt.Errorf("writing to checkpoint file '%s': %w", c.resumeFrom, err)
+	}
+	return nil
+}
+
+// closeCheckpoint closes the checkpoint file. It is kept so a completed scan can be re-run to regenerate the results without scanning again
+func (c *Config) closeCheckpoint() {
+	if c.checkpoint == nil {
+		return
+	}
+	if err := c.checkpoint.Close(); err != nil {
+		log.Printf("problem closing file '%s': %s", c.resumeFrom, err)
+	}
+}
@@ -0,0 +1,81 @@
+package docker_image_history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenCheckpointMatchSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{name: "same settings", change: func(c *Config) {}},
+		{name: "different keywords", change: func(c *Config) { c.dockerImageKeyWords = []string{"wget"} }, wantErr: "keywords"},
+		{name: "case-sensitive", change: func(c *Config) { c.caseSensitive = true }, wantErr: "caseSensitive"},
+		{name: "decode base64", change: func(c *Config) { c.decodeBase64 = true }, wantErr: "decodeBase64"},
+		{name: "occurrences count mode", change: func(c *Config) { c.countMode = CountModeOccurrences }, wantErr: "countMode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const image = "app:1.0"
+			c := newTestConfig(nil, "curl")
+			c.resumeFrom = filepath.Join(t.TempDir(), "checkpoint.jsonl")
+			c.dockerImages[image] = []podDetails{{podName: "app-0", namespace: "default"}}
+			if err := c.openCheckpoint(); err != nil {
+				t.Fatal(err)
+			}
+			c.recordResult(offendingDockerImage{imageRef: image, matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+			c.closeCheckpoint()
+
+			resumed := newTestConfig(nil, "curl")
+			resumed.resumeFrom = c.resumeFrom
+			resumed.dockerImages[image] = c.dockerImages[image]
+			tt.change(resumed)
+			err := resumed.openCheckpoint()
+			defer resumed.closeCheckpoint()
+
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error about '%s', got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resuming from the checkpoint: %s", err)
+			}
+			if !resumed.checkpointed[image] || len(resumed.offendingDockerImages) != 1 {
+				t.Errorf("expected the checkpointed result to be resumed, got %+v", resumed.offendingDockerImages)
+			}
+		})
+	}
+}
+
+func TestOpenCheckpointEmptyFile(t *testing.T) {
+	const image = "app:1.0"
+	c := newTestConfig(nil, "curl")
+	c.resumeFrom = filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	if err := os.WriteFile(c.resumeFrom, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.dockerImages[image] = []podDetails{{podName: "app-0", namespace: "default"}}
+	if err := c.openCheckpoint(); err != nil {
+		t.Fatalf("opening an empty checkpoint file: %s", err)
+	}
+	c.recordResult(offendingDockerImage{imageRef: image, matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+	c.closeCheckpoint()
+
+	resumed := newTestConfig(nil, "curl")
+	resumed.resumeFrom = c.resumeFrom
+	resumed.dockerImages[image] = c.dockerImages[image]
+	if err := resumed.openCheckpoint(); err != nil {
+		t.Fatalf("resuming from a checkpoint file which was empty when first opened: %s", err)
+	}
+	defer resumed.closeCheckpoint()
+	if !resumed.checkpointed[image] {
+		t.Errorf("expected '%s' to be resumed from the checkpoint", image)
+	}
+}
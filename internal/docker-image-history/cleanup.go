@@ -0,0 +1,140 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// pulledImage is an image pulled into the local Docker instance, along with the local image it resolved to
+// alreadyPresent is set if the image was not pulled as it was already present locally, so it must not be removed
+type pulledImage struct {
+	imageRef       string
+	imageID        string
+	size           int64
+	alreadyPresent bool
+}
+
+// skipPull returns whether an image does not need to be pulled as it is already present in the local Docker instance. Unless forcePull
+// is set, present images are not pulled again if their ref is pinned by digest or a tag other than 'latest', as those are expected to
+// be unchanged. Refs with a mutable tag are pulled again in case the tag has moved, unless noPullIfPresent is set
+func (c *Config) skipPull(ctx context.Context, imageRef string) bool {
+	if c.forcePull {
+		return false
+	}
+	if _, mutable := mutableTag(imageRef); mutable && !c.noPullIfPresent {
+		return false
+	}
+	return c.localImagePresent(ctx, imageRef)
+}
+
+// localImagePresent returns whether an image ref is already present in the local Docker instance
+func (c *Config) localImagePresent(ctx context.Context, imageRef string) bool {
+	_, _, err := c.dockerClient.ImageInspectWithRaw(ctx, imageRef)
+	return err == nil
+}
+
+// retainLocalImage records that a worker is using a pulled image. Different refs (e.g. a tag and a digest) can resolve to the
+// same local image, so it is only removed once every worker using it has finished. Safe for concurrent use
+func (c *Config) retainLocalImage(imageRef string) (pulledImage, error) {
+	// Not cancelled with the scan, so an image pulled just before it is cancelled is still tracked and removed
+	inspect, _, err := c.dockerClient.ImageInspectWithRaw(context.Background(), imageRef)
+	if err != nil {
+		return pulledImage{}, fmt.Errorf("inspecting local image '%s': %w", imageRef, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localImageUsers[inspect.ID]++
+
+	return pulledImage{imageRef: imageRef, imageID: inspect.ID, size: inspect.Size}, nil
+}
+
+// cleanupImage removes a single Docker image from the local cache. Safe for concurrent use
+// If another worker is still using the same local image, only this ref is untagged so the image stays available to it
+// Images which were already present locally before the scan, and images which are kept (see keepImage), are left in place
+func (c *Config) cleanupImage(image pulledImage) error {
+	defer c.timePhase(phaseCleanup, time.Now())
+
+	c.mu.Lock()
+	c.localImageUsers[image.imageID]--
+	inUse := c.localImageUsers[image.imageID] > 0
+	c.mu.Unlock()
+	if image.alreadyPresent {
+		return nil
+	}
+	if c.keepImage(image) {
+		c.recordKeptImage(image)
+		return nil
+	}
+
+	// Not cancelled with the scan, so the images being processed when it is cancelled are still removed
+	responses, err := c.dockerClient.ImageRemove(context.Background(), image.imageRef, types.ImageRemoveOptions{Force: !inUse, PruneChildren: !inUse})
+	c.recordCleanupAttempt(image, err)
+	if err != nil {
+		c.countError(scanStageCleanup)
+		return fmt.Errorf("cleaning up local image '%s': %w", image.imageRef, err)
+	}
+
+	for _, r := range responses {
+		if len(r.Deleted) > 0 {
+			c.mu.Lock()
+			c.removedImages++
+			c.reclaimedBytes += image.size
+			c.mu.Unlock()
+			break
+		}
+	}
+	return nil
+}
+
+// cleanupPulledImages removes all the images pulled during the scan, using up to cleanupConcurrency workers
+// Every image is attempted even if some fail to be removed, and the failures are returned together
+func (c *Config) cleanupPulledImages() error {
+	log.Printf("Removing %d images pulled during the scan with a concurrency of %d", len(c.pulledImages), c.cleanupConcurrency)
+
+	var (
+		errsMu sync.Mutex
+		errs   []string
+	)
+	var g errgroup.Group
+	g.SetLimit(c.cleanupConcurrency)
+	for _, image := range c.pulledImages {
+		image := image
+		g.Go(func() error {
+			if err := c.cleanupImage(image); err != nil {
+				errsMu.Lock()
+				errs = append(errs, err.Error())
+				errsMu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d images could not be removed: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// cleanupTimedOutPull removes an image whose pull timed out, in case the pull completed just as it was cancelled. The daemon discards
+// the incomplete layer downloads of a cancelled pull itself, so nothing is left behind if the image was not created
+func (c *Config) cleanupTimedOutPull(imageRef string) {
+	ctx := context.Background()
+	if !c.localImagePresent(ctx, imageRef) {
+		log.Printf("Pull of '%s' timed out after %s, the partially downloaded layers have been discarded", imageRef, c.pullTimeout)
+		return
+	}
+	if _, err := c.dockerClient.ImageRemove(ctx, imageRef, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
+		log.Printf("WARNING: removing '%s' after its pull timed out: %s", imageRef, err)
+		return
+	}
+	log.Printf("Removed '%s' after its pull timed out", imageRef)
+}
@@ -0,0 +1,58 @@
+package docker_image_history
+
+import "fmt"
+
+// replicaGroupKey identifies the pods of a workload which run the same container, and so differ only by their pod name
+type replicaGroupKey struct {
+	namespace     string
+	workloadKind  string
+	workloadName  string
+	containerName string
+	containerType string
+	historical    bool
+}
+
+// formatPods returns the pods running an image for the text results. When collapseReplicas is set the pods which share an owning
+// workload (from their controller owner reference) and container are collapsed into one entry, showing the workload in place of
+// the pod name along with the number of replicas. Pods without an owner are never collapsed
+func (c *Config) formatPods(pods []podDetails) []string {
+	if !c.collapseReplicas {
+		formatted := make([]string, 0, len(pods))
+		for _, pd := range pods {
+			formatted = append(formatted, c.formatPod(pd))
+		}
+		return formatted
+	}
+
+	var (
+		keys     []replicaGroupKey
+		first    = make(map[replicaGroupKey]podDetails)
+		replicas = make(map[replicaGroupKey]int)
+	)
+	for i, pd := range pods {
+		key := replicaGroupKey{namespace: pd.namespace, workloadKind: pd.workloadKind, workloadName: pd.workloadName,
+			containerName: pd.containerName, containerType: pd.containerType, historical: pd.historical}
+		if len(pd.workloadKind) == 0 || pd.workloadKind == "Pod" {
+			// Unique per pod, so standalone pods each keep their own entry
+			key.workloadName = fmt.Sprintf("%s#%d", pd.podName, i)
+		}
+		if _, seen := first[key]; !seen {
+			keys = append(keys, key)
+			first[key] = pd
+		}
+		replicas[key]++
+	}
+
+	formatted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pd := first[key]
+		if replicas[key] == 1 {
+			formatted = append(formatted, c.formatPod(pd))
+			continue
+		}
+		pd.podName = fmt.Sprintf("%s/%s", pd.workloadKind, pd.workloadName)
+		pd.nodeName, pd.podUID = "", ""
+		formatted = append(formatted, fmt.Sprintf("%s, replicas: %d", c.formatPod(pd), replicas[key]))
+	}
+	return formatted
+}
@@ -0,0 +1,47 @@
+package docker_image_history
+
+import (
+	"log"
+	"runtime"
+
+	"github.com/docker/go-units"
+)
+
+// autoConcurrency picks the number of images to pull and scan at once when concurrency is not set. One image per CPU, unless a disk
+// budget is set and fewer images of the average size fit in it
+// The average image size is taken from the node image cache (if -nodeImageStats is set), or otherwise assumed to be defaultImageSizeEstimate
+func (c *Config) autoConcurrency() int {
+	concurrency := runtime.NumCPU()
+	if c.maxDiskBytes == 0 {
+		log.Printf("Concurrency not set, pulling and scanning %d images at once: one per CPU", concurrency)
+		return concurrency
+	}
+
+	averageSize, source := c.averageImageSize()
+	fit := int(c.maxDiskBytes / averageSize)
+	if fit < 1 {
+		fit = 1
+	}
+	if fit < concurrency {
+		concurrency = fit
+	}
+	log.Printf("Concurrency not set, pulling and scanning %d images at once: the lower of %d CPUs and %d images of the %s average size (%s) fitting in the disk budget of %s",
+		concurrency, runtime.NumCPU(), fit, source, units.HumanSize(float64(averageSize)), units.HumanSize(float64(c.maxDiskBytes)))
+	return concurrency
+}
+
+// averageImageSize returns the average size of the images to scan which are cached on the nodes, and where it came from
+// Returns defaultImageSizeEstimate if none of their sizes are known
+func (c *Config) averageImageSize() (int64, string) {
+	var total, known int64
+	for image := range c.dockerImages {
+		if e, ok := c.nodeImageFor(image); ok && e.sizeBytes > 0 {
+			total += e.sizeBytes
+			known++
+		}
+	}
+	if known == 0 {
+		return defaultImageSizeEstimate, "assumed"
+	}
+	return total / known, "node cached"
+}
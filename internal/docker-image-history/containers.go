@@ -0,0 +1,32 @@
+package docker_image_history
+
+import corev1 "k8s.io/api/core/v1"
+
+// Types of container in a pod, recorded against each container so the results distinguish images only used by init or ephemeral containers
+const (
+	ContainerTypeContainer = "container"
+	ContainerTypeInit      = "init"
+	ContainerTypeEphemeral = "ephemeral"
+)
+
+// podContainer is a container of any type in a pod spec
+type podContainer struct {
+	name          string
+	image         string
+	containerType string
+}
+
+// podContainers returns the containers, init containers and ephemeral (debug) containers of a pod spec
+func podContainers(spec corev1.PodSpec) []podContainer {
+	containers := make([]podContainer, 0, len(spec.Containers)+len(spec.InitContainers)+len(spec.EphemeralContainers))
+	for _, container := range spec.InitContainers {
+		containers = append(containers, podContainer{name: container.Name, image: container.Image, containerType: ContainerTypeInit})
+	}
+	for _, container := range spec.Containers {
+		containers = append(containers, podContainer{name: container.Name, image: container.Image, containerType: ContainerTypeContainer})
+	}
+	for _, container := range spec.EphemeralContainers {
+		containers = append(containers, podContainer{name: container.Name, image: container.Image, containerType: ContainerTypeEphemeral})
+	}
+	return containers
+}
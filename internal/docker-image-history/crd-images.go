@@ -0,0 +1,133 @@
+package docker_image_history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// crdImageSources is the file format used to discover images from the specs of custom resources (e.g. Argo Workflows, Tekton Tasks)
+type crdImageSources struct {
+	Sources []crdImageSource `json:"sources"`
+}
+
+// crdImageSource is a custom resource, identified by its group/version/resource, and the JSONPath expressions which select the
+// images in its spec, e.g. '{.spec.templates[*].container.image}'
+type crdImageSource struct {
+	Group      string   `json:"group"`
+	Version    string   `json:"version"`
+	Resource   string   `json:"resource"`
+	ImagePaths []string `json:"imagePaths"`
+}
+
+// gvr returns the group/version/resource of the custom resource
+func (s crdImageSource) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: s.Group, Version: s.Version, Resource: s.Resource}
+}
+
+// loadCRDImageSources parses the CRD image sources file, and checks the JSONPath expressions are valid
+func loadCRDImageSources(path string) (crdImageSources, error) {
+	var sources crdImageSources
+
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return sources, fmt.Errorf("reading CRD image sources file '%s': %w", path, err)
+	}
+	if err = json.Unmarshal(jsonBytes, &sources); err != nil {
+		return sources, fmt.Errorf("parsing CRD image sources file '%s': %w", path, err)
+	}
+
+	for i, s := range sources.Sources {
+		if len(s.Version) == 0 || len(s.Resource) == 0 || len(s.ImagePaths) == 0 {
+			return sources, fmt.Errorf("CRD image source %d in '%s' must set version, resource and at least one of imagePaths", i, path)
+		}
+		for _, p := range s.ImagePaths {
+			if err = jsonpath.New(s.Resource).Parse(p); err != nil {
+				return sources, fmt.Errorf("parsing the image path '%s' of '%s' in '%s': %w", p, s.gvr(), path, err)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// queryCRDImages adds the images selected by the JSONPath expressions from every custom resource of the configured kinds
+// As no pods are listed, each image is attributed to its custom resource in place of a pod name (e.g. 'workflows/nightly-build')
+func (c *Config) queryCRDImages(ctx context.Context) error {
+	sources, err := loadCRDImageSources(c.crdImageSourcesPath)
+	if err != nil {
+		return err
+	}
+
+	k8sConfig, err := newK8sRestConfig(c.clusterK8sContextName, c.inCluster)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+	if err != nil {
+		return fmt.Errorf("creating k8s dynamic client: %w", err)
+	}
+
+	for _, s := range sources.Sources {
+		resources, err := dynamicClient.Resource(s.gvr()).Namespace(c.namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("querying for '%s': %w", s.gvr(), err)
+		}
+
+		found := 0
+		for _, r := range resources.Items {
+			for _, p := range s.ImagePaths {
+				images, err := jsonPathStrings(s.Resource, p, r.Object)
+				if err != nil {
+					return fmt.Errorf("evaluating the image path '%s' of %s '%s/%s': %w", p, s.Resource, r.GetNamespace(), r.GetName(), err)
+				}
+				for _, image := range images {
+					pd := podDetails{
+						podName:      fmt.Sprintf("%s/%s", s.Resource, r.GetName()),
+						namespace:    r.GetNamespace(),
+						workloadKind: r.GetKind(),
+						workloadName: r.GetName(),
+					}
+					if _, seen := c.dockerImages[image]; !seen {
+						if reason, mutable := mutableTag(image); mutable {
+							c.mutableTagImages[image] = reason
+						}
+					}
+					c.dockerImages[image] = append(c.dockerImages[image], pd)
+					found++
+				}
+			}
+		}
+		log.Printf("Found %d images in %d '%s' resources", found, len(resources.Items), s.gvr())
+	}
+
+	return nil
+}
+
+// jsonPathStrings returns the non-empty string values selected by a JSONPath expression. Missing keys select nothing
+func jsonPathStrings(name, path string, object map[string]interface{}) ([]string, error) {
+	j := jsonpath.New(name).AllowMissingKeys(true)
+	if err := j.Parse(path); err != nil {
+		return nil, err
+	}
+	results, err := j.FindResults(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, result := range results {
+		for _, v := range result {
+			if s, ok := v.Interface().(string); ok && len(s) > 0 {
+				values = appendUnique(values, s)
+			}
+		}
+	}
+	return values, nil
+}
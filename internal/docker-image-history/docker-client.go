@@ -0,0 +1,21 @@
+package docker_image_history
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
+
+// imageClient is the subset of the Docker client used to pull, read and remove images. Config holds it rather than the concrete
+// client, so the scanning logic can be exercised against a fake which returns canned pull streams and histories instead of a daemon
+type imageClient interface {
+	HistoryClient
+	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	ImageRemove(ctx context.Context, image string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
+	ImageSave(ctx context.Context, images []string) (io.ReadCloser, error)
+	ImageTag(ctx context.Context, image, ref string) error
+	Info(ctx context.Context) (types.Info, error)
+	Close() error
+}
@@ -0,0 +1,235 @@
+package docker_image_history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+)
+
+// fakeImageClient is an imageClient which serves canned histories and pull streams from memory instead of a Docker daemon
+// images are the images present locally, keyed by ref. A successful pull adds the image, and removing it deletes it again
+type fakeImageClient struct {
+	mu        sync.Mutex
+	histories map[string][]image.HistoryResponseItem
+	images    map[string]types.ImageInspect
+	removed   []string
+
+	// pull returns the progress stream of a pull. If it is not set the pull succeeds straight away
+	pull func(ctx context.Context, ref string) (io.ReadCloser, error)
+	// historyErrs are returned from ImageHistory for the refs in it
+	historyErrs map[string]error
+}
+
+// newFakeImageClient returns a fake client with no images present locally, which serves the histories when they have been pulled
+func newFakeImageClient(histories map[string][]image.HistoryResponseItem) *fakeImageClient {
+	return &fakeImageClient{histories: histories, images: make(map[string]types.ImageInspect)}
+}
+
+// addImage makes an image present locally, as if it had been pulled or built
+func (f *fakeImageClient) addImage(ref, id string, size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[ref] = types.ImageInspect{ID: id, Size: size}
+}
+
+// removedRefs returns the refs which have been removed, in the order they were removed
+func (f *fakeImageClient) removedRefs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.removed...)
+}
+
+func (f *fakeImageClient) ImageHistory(_ context.Context, ref string) ([]image.HistoryResponseItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err, ok := f.historyErrs[ref]; ok {
+		return nil, err
+	}
+	history, ok := f.histories[ref]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("No such image: %s", ref))
+	}
+	return history, nil
+}
+
+func (f *fakeImageClient) ImagePull(ctx context.Context, ref string, _ types.ImagePullOptions) (io.ReadCloser, error) {
+	if f.pull != nil {
+		return f.pull(ctx, ref)
+	}
+	f.addImage(ref, "sha256:"+ref, 1024)
+	return io.NopCloser(strings.NewReader(fmt.Sprintf(`{"status":"Status: Downloaded newer image for %s"}`, ref))), nil
+}
+
+func (f *fakeImageClient) ImageInspectWithRaw(_ context.Context, ref string) (types.ImageInspect, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inspect, ok := f.images[ref]
+	if !ok {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("No such image: %s", ref))
+	}
+	return inspect, nil, nil
+}
+
+func (f *fakeImageClient) ImageRemove(_ context.Context, ref string, _ types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inspect, ok := f.images[ref]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("No such image: %s", ref))
+	}
+	delete(f.images, ref)
+	f.removed = append(f.removed, ref)
+	return []types.ImageDeleteResponseItem{{Untagged: ref}, {Deleted: inspect.ID}}, nil
+}
+
+func (f *fakeImageClient) ImageSave(context.Context, []string) (io.ReadCloser, error) {
+	return nil, errors.New("image save is not supported by the fake client")
+}
+
+func (f *fakeImageClient) ImageTag(_ context.Context, source, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inspect, ok := f.images[source]
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("No such image: %s", source))
+	}
+	f.images[target] = inspect
+	return nil
+}
+
+func (f *fakeImageClient) Info(context.Context) (types.Info, error) {
+	return types.Info{}, nil
+}
+
+func (f *fakeImageClient) Close() error {
+	return nil
+}
+
+// newTestConfig returns a Config which uses the client and matches the keywords, with the state a scan needs initialised
+func newTestConfig(client imageClient, keywords ...string) *Config {
+	return &Config{
+		dockerImageKeyWords: keywords,
+		dockerImages:        make(map[string][]podDetails),
+		offendingImageIndex: make(map[string]int),
+		dockerClient:        client,
+		localImageUsers:     make(map[string]int),
+		errorCounts:         make(map[string]int),
+		skippedImages:       make(map[string]string),
+		findings:            io.Discard,
+		stdout:              io.Discard,
+		stderr:              io.Discard,
+		pullTimeout:         defaultPullTimeout,
+	}
+}
+
+func TestCheckImageHistoryForKeyWords(t *testing.T) {
+	client := newFakeImageClient(map[string][]image.HistoryResponseItem{
+		"app:1.0": {
+			{ID: "sha256:top", CreatedBy: `/bin/sh -c #(nop)  CMD ["./app"]`},
+			{ID: "<missing>", CreatedBy: "/bin/sh -c curl -sSL https://example.com/install.sh | sh"},
+			{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		},
+	})
+	c := newTestConfig(client, "curl", "wget")
+
+	result, err := c.checkImageHistoryForKeyWords(context.Background(), "app:1.0")
+	if err != nil {
+		t.Fatalf("checking image history: %s", err)
+	}
+	if !result.matchFound {
+		t.Fatal("expected the image to match")
+	}
+	if result.matchedKeywords["curl"] != 1 {
+		t.Errorf("expected 'curl' to match 1 layer, got %v", result.matchedKeywords)
+	}
+	if _, found := result.matchedKeywords["wget"]; found {
+		t.Errorf("expected 'wget' not to match, got %v", result.matchedKeywords)
+	}
+}
+
+func TestCheckImageHistoryForKeyWordsHistoryError(t *testing.T) {
+	c := newTestConfig(newFakeImageClient(nil), "curl")
+
+	if _, err := c.checkImageHistoryForKeyWords(context.Background(), "missing:1.0"); err == nil {
+		t.Fatal("expected an error reading the history of an image which is not present")
+	}
+}
+
+func TestCleanupImage(t *testing.T) {
+	tests := []struct {
+		name                string
+		alreadyPresent      bool
+		keepImages          bool
+		keepOffendingImages bool
+		offending           bool
+		wantRemoved         bool
+	}{
+		{name: "pulled image is removed", wantRemoved: true},
+		{name: "image present before the scan is kept", alreadyPresent: true},
+		{name: "keepImages keeps every image", keepImages: true},
+		{name: "keepOffendingImages keeps an offending image", keepOffendingImages: true, offending: true},
+		{name: "keepOffendingImages removes a clean image", keepOffendingImages: true, wantRemoved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeImageClient(nil)
+			client.addImage("app:1.0", "sha256:app", 2048)
+			c := newTestConfig(client)
+			c.keepImages, c.keepOffendingImages = tt.keepImages, tt.keepOffendingImages
+			if tt.offending {
+				c.offendingRefs = map[string]bool{"app:1.0": true}
+			}
+
+			pulled, err := c.retainLocalImage("app:1.0")
+			if err != nil {
+				t.Fatalf("retaining local image: %s", err)
+			}
+			if pulled.imageID != "sha256:app" || pulled.size != 2048 {
+				t.Errorf("expected the local image 'sha256:app' of 2048 bytes, got %+v", pulled)
+			}
+			pulled.alreadyPresent = tt.alreadyPresent
+
+			if err = c.cleanupImage(pulled); err != nil {
+				t.Fatalf("cleaning up image: %s", err)
+			}
+			removed := len(client.removedRefs()) > 0
+			if removed != tt.wantRemoved {
+				t.Errorf("expected removed to be %t, got %t", tt.wantRemoved, removed)
+			}
+			if tt.wantRemoved && (c.removedImages != 1 || c.reclaimedBytes != 2048) {
+				t.Errorf("expected 1 image and 2048 bytes reclaimed, got %d images and %d bytes", c.removedImages, c.reclaimedBytes)
+			}
+			if c.localImageUsers["sha256:app"] != 0 {
+				t.Errorf("expected no remaining users of the local image, got %d", c.localImageUsers["sha256:app"])
+			}
+		})
+	}
+}
+
+func TestRetainLocalImageNotPresent(t *testing.T) {
+	c := newTestConfig(newFakeImageClient(nil))
+
+	if _, err := c.retainLocalImage("app:1.0"); err == nil {
+		t.Fatal("expected an error retaining an image which is not present locally")
+	}
+}
+
+func TestCleanupImageFailureIsRecorded(t *testing.T) {
+	c := newTestConfig(newFakeImageClient(nil))
+	c.localImageUsers["sha256:app"] = 1
+
+	if err := c.cleanupImage(pulledImage{imageRef: "app:1.0", imageID: "sha256:app"}); err == nil {
+		t.Fatal("expected an error removing an image which is not present locally")
+	}
+	if c.errorCounts[scanStageCleanup] != 1 {
+		t.Errorf("expected 1 cleanup error, got %d", c.errorCounts[scanStageCleanup])
+	}
+}
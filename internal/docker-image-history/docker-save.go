@@ -0,0 +1,89 @@
+package docker_image_history
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// savedImageManifest is an entry of the manifest.json at the root of a 'docker save' archive
+type savedImageManifest struct {
+	Config string `json:"Config"`
+}
+
+// savedImageHistory reads the history of a pulled image from a 'docker save' archive rather than the ImageHistory API
+// The archive is streamed to a temporary file, which is removed again once the config has been read from it
+func (c *Config) savedImageHistory(ctx context.Context, imageRef string) ([]historyEntry, error) {
+	tmp, err := os.CreateTemp("", "image-save-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file to save '%s' to: %w", imageRef, err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		if err := os.Remove(tmp.Name()); err != nil {
+			log.Printf("problem removing temporary file '%s': %s", tmp.Name(), err)
+		}
+	}()
+
+	saved, err := c.dockerClient.ImageSave(ctx, []string{imageRef})
+	if err != nil {
+		return nil, fmt.Errorf("saving image '%s': %w", imageRef, err)
+	}
+	_, err = io.Copy(tmp, saved)
+	_ = saved.Close()
+	if err != nil {
+		return nil, fmt.Errorf("writing saved image '%s' to '%s': %w", imageRef, tmp.Name(), err)
+	}
+
+	// The order of the files in the archive is not defined, so the manifest is found first and then the config it points to
+	manifestBytes, err := readTarFile(tmp, "manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest of saved image '%s': %w", imageRef, err)
+	}
+	var manifests []savedImageManifest
+	if err = json.Unmarshal(manifestBytes, &manifests); err != nil {
+		return nil, fmt.Errorf("parsing manifest of saved image '%s': %w", imageRef, err)
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no images in the manifest of saved image '%s'", imageRef)
+	}
+
+	configBytes, err := readTarFile(tmp, manifests[0].Config)
+	if err != nil {
+		return nil, fmt.Errorf("reading config of saved image '%s': %w", imageRef, err)
+	}
+	configFile, err := v1.ParseConfigFile(bytes.NewReader(configBytes))
+	if err != nil {
+		return nil, fmt.Errorf("parsing config of saved image '%s': %w", imageRef, err)
+	}
+
+	return configHistory(configFile), nil
+}
+
+// readTarFile returns the contents of a single file in a tar archive, reading the archive from the start
+func readTarFile(f *os.File, name string) ([]byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("'%s' not found in archive", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
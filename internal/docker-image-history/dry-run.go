@@ -0,0 +1,53 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// outputDryRun writes to a file every unique image ref in the cluster and the pods running it, without pulling or reading any image
+// The non ECR and unparseable images are written as usual, as they do not require pulling either. The number of images from each
+// registry host is logged to help estimate the scan time and spot unexpected registries
+func (c *Config) outputDryRun() error {
+	dryRunResultsPath := c.resultsPath("dry-run-images", "txt")
+	f, err := c.openResultsFile(dryRunResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", dryRunResultsPath, err)
+		}
+	}(f)
+
+	registries := make(map[string]int)
+	for _, image := range c.imageRefs() {
+		registries[registryHost(image)]++
+		_, err = f.WriteString(fmt.Sprintf("%s\t(%d containers) ", image, len(c.dockerImages[image])))
+		for _, match := range c.formatPods(c.dockerImages[image]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", dryRunResultsPath, err)
+		}
+	}
+	log.Printf("Dry run: %d unique images would be scanned. Results written to: %s", len(c.dockerImages), dryRunResultsPath)
+
+	hosts := make([]string, 0, len(registries))
+	for host := range registries {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		log.Printf("Dry run: %d images from registry '%s'", registries[host], host)
+	}
+
+	if err = c.outputNonECRImages(); err != nil {
+		return err
+	}
+	return c.outputUnparseableImages()
+}
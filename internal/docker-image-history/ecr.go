@@ -0,0 +1,267 @@
+package docker_image_history
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// ecrUsername is the username ECR expects alongside the password from an authorization token
+const ecrUsername = "AWS"
+
+// ecrTokenAttempts is the number of times an ECR auth token is requested before giving up
+const ecrTokenAttempts = 4
+
+// ecrTokenRetryDelay is the delay before the first retry of an ECR auth token request. It doubles after each failed attempt
+const ecrTokenRetryDelay = 2 * time.Second
+
+// ecrTokenRetryables classifies the ECR auth token errors which are retried: throttling, 5xx responses and transport errors
+// Any other error (e.g. AccessDenied or expired credentials) fails straight away, as retrying cannot fix it
+var ecrTokenRetryables = retry.IsErrorRetryables(append([]retry.IsErrorRetryable{
+	retry.RetryableHTTPStatusCode{Codes: map[int]struct{}{http.StatusTooManyRequests: {}}},
+}, retry.DefaultRetryables...))
+
+// ecrTokenRefreshWindow is how long before it expires an ECR auth token is refreshed. Tokens are valid for 12 hours, so long scans
+// outlive the tokens fetched when they started
+const ecrTokenRefreshWindow = 30 * time.Minute
+
+// ecrToken is the Docker login credentials generated from an ECR auth token, and when the token expires
+// expiresAt is zero if ECR did not return an expiry, in which case the token is never refreshed
+type ecrToken struct {
+	auth      string
+	expiresAt time.Time
+}
+
+// nearExpiry returns whether the token expires within the refresh window
+func (t ecrToken) nearExpiry(now time.Time) bool {
+	return !t.expiresAt.IsZero() && now.Add(ecrTokenRefreshWindow).After(t.expiresAt)
+}
+
+// unconfiguredECRRegionError is returned for an ECR image in a region which no auth token could be generated for
+// cause is the error from generating the token on demand, or nil if the region could not be parsed from the image's host
+type unconfiguredECRRegionError struct {
+	imageRef   string
+	region     string
+	configured []string
+	cause      error
+}
+
+func (e *unconfiguredECRRegionError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("ECR image '%s' is in the region '%s', which could not be authenticated: %s", e.imageRef, e.region, e.cause)
+	}
+	return fmt.Sprintf("ECR image '%s' is in the unconfigured region '%s'. Currently supported: %v", e.imageRef, e.region, e.configured)
+}
+
+func (e *unconfiguredECRRegionError) Unwrap() error {
+	return e.cause
+}
+
+// ecrCredentialsForRegion returns the Docker login credentials for the ECR registries in a region. Regions which were not configured
+// are authenticated on demand the first time an image in them is found, and the credentials (or the failure) cached for the rest of
+// the run. Cached credentials are refreshed when their token is near expiry. Safe for concurrent use
+// ecrCredentialsMu is not held whilst the credentials are fetched, so the workers using other regions are not blocked by the retries of
+// a slow region. Concurrent fetches of the same region are shared
+func (c *Config) ecrCredentialsForRegion(ctx context.Context, region string) (string, error) {
+	c.ecrCredentialsMu.Lock()
+	token, cached := c.ecrCredentials[region]
+	err, failed := c.ecrCredentialErrors[region]
+	c.ecrCredentialsMu.Unlock()
+
+	now := time.Now()
+	if cached {
+		if !token.nearExpiry(now) {
+			return token.auth, nil
+		}
+
+		log.Printf("ECR credentials for the region '%s' expire at %s, refreshing them", region, token.expiresAt.UTC().Format(time.RFC3339))
+		auth, err := c.sharedRefreshECRCredentials(ctx, region)
+		if err != nil && now.Before(token.expiresAt) {
+			// The existing token can still be used until it expires, and the refresh is retried on the next pull
+			log.Printf("WARNING: refreshing ECR credentials for the region '%s' failed, using the existing credentials until they expire: %s", region, err)
+			return token.auth, nil
+		}
+		return auth, err
+	}
+	if failed {
+		return "", err
+	}
+
+	log.Printf("Generating ECR credentials on demand for the region '%s', which was not configured via ecrRegions", region)
+	auth, err := c.sharedRefreshECRCredentials(ctx, region)
+	if err != nil {
+		c.ecrCredentialsMu.Lock()
+		if c.ecrCredentialErrors == nil {
+			c.ecrCredentialErrors = make(map[string]error)
+		}
+		c.ecrCredentialErrors[region] = err
+		c.ecrCredentialsMu.Unlock()
+		return "", err
+	}
+	return auth, nil
+}
+
+// sharedRefreshECRCredentials refreshes the credentials of a region, sharing a single fetch between the workers which need them at once
+func (c *Config) sharedRefreshECRCredentials(ctx context.Context, region string) (string, error) {
+	auth, err, _ := c.ecrCredentialFetches.Do(region, func() (interface{}, error) {
+		return c.refreshECRCredentials(ctx, region)
+	})
+	if err != nil {
+		return "", err
+	}
+	return auth.(string), nil
+}
+
+// refreshECRCredentials fetches new Docker login credentials for the ECR registries in a region and caches them along with their expiry
+// Used both for the initial credentials and to refresh them. Safe for concurrent use
+func (c *Config) refreshECRCredentials(ctx context.Context, region string) (string, error) {
+	fetch := c.fetchECRToken
+	if fetch == nil {
+		fetch = fetchECRCredentials
+	}
+	token, err := fetch(ctx, c.imagesAccountAWSProfileName, region, c.debugAuth)
+	if err != nil {
+		return "", err
+	}
+	c.ecrCredentialsMu.Lock()
+	defer c.ecrCredentialsMu.Unlock()
+	c.ecrCredentials[region] = token
+	return token.auth, nil
+}
+
+// ecrAuthTokenAPI is the subset of the ECR client used to generate auth tokens
+type ecrAuthTokenAPI interface {
+	GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+}
+
+// fetchECRCredentials generates Docker login credentials for the ECR registries in a region using the AWS profile
+// If no profile is set the default credential chain is used (env vars, instance role, IRSA etc.)
+// Returns the credentials as base64 encoded JSON, ready to be used as the RegistryAuth when pulling images, along with their expiry
+// The credentials are never logged. With debugAuth set only the non-secret token metadata is logged. Retries stop if the context is cancelled
+func fetchECRCredentials(ctx context.Context, profile, region string, debugAuth bool) (ecrToken, error) {
+	configOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if len(profile) > 0 {
+		configOpts = append(configOpts, config.WithSharedConfigProfile(profile))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, configOpts...)
+	if err != nil {
+		return ecrToken{}, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	return ecrCredentialsFromClient(ctx, ecr.NewFromConfig(awsConfig), region, debugAuth)
+}
+
+// ecrCredentialsFromClient generates Docker login credentials for the ECR registries in a region using the ECR client
+func ecrCredentialsFromClient(ctx context.Context, ecrClient ecrAuthTokenAPI, region string, debugAuth bool) (ecrToken, error) {
+	ecrResp, err := getAuthorizationTokenWithRetry(ctx, ecrClient, ecrTokenRetryDelay)
+	if err != nil {
+		return ecrToken{}, err
+	}
+	if len(ecrResp.AuthorizationData) == 0 || ecrResp.AuthorizationData[0].AuthorizationToken == nil {
+		return ecrToken{}, fmt.Errorf("no ECR auth token returned for region '%s'", region)
+	}
+
+	authData := ecrResp.AuthorizationData[0]
+	if debugAuth {
+		logECRAuthMetadata(region, authData)
+	}
+
+	// The decoding errors deliberately do not wrap or include the token, so it cannot end up in the logs
+	decodedToken, err := base64.StdEncoding.DecodeString(*authData.AuthorizationToken)
+	if err != nil {
+		return ecrToken{}, fmt.Errorf("decoding ECR auth token for region '%s': token is not valid base64", region)
+	}
+	_, password, found := strings.Cut(string(decodedToken), ":")
+	if !found {
+		return ecrToken{}, fmt.Errorf("decoding ECR auth token for region '%s': token is not in the format '<username>:<password>'", region)
+	}
+	auth, err := registryCredentials{username: ecrUsername, password: password}.encode()
+	if err != nil {
+		return ecrToken{}, err
+	}
+
+	token := ecrToken{auth: auth}
+	if authData.ExpiresAt != nil {
+		token.expiresAt = *authData.ExpiresAt
+	}
+	return token, nil
+}
+
+// logECRAuthMetadata logs the non-secret metadata of an ECR auth token, to help debug auth issues. Never logs the token itself
+func logECRAuthMetadata(region string, authData types.AuthorizationData) {
+	proxyEndpoint, expiresAt := "unknown", "unknown"
+	if authData.ProxyEndpoint != nil {
+		proxyEndpoint = *authData.ProxyEndpoint
+	}
+	if authData.ExpiresAt != nil {
+		expiresAt = authData.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	log.Printf("ECR auth for region '%s': proxy endpoint: %s, username: %s, token expires at: %s", region, proxyEndpoint, ecrUsername, expiresAt)
+}
+
+// getAuthorizationTokenWithRetry requests an ECR auth token, retrying with exponential backoff so a transient STS/ECR error does not abort the scan
+// Only throttling, 5xx and transport errors are retried (see ecrTokenRetryables). Stops early if the context is cancelled whilst waiting to retry
+func getAuthorizationTokenWithRetry(ctx context.Context, client ecrAuthTokenAPI, retryDelay time.Duration) (*ecr.GetAuthorizationTokenOutput, error) {
+	var err error
+	for attempt := 1; attempt <= ecrTokenAttempts; attempt++ {
+		var resp *ecr.GetAuthorizationTokenOutput
+		resp, err = client.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+		if err == nil {
+			return resp, nil
+		}
+		if ecrTokenRetryables.IsErrorRetryable(err) != aws.TrueTernary {
+			return nil, fmt.Errorf("getting ECR auth token: %w", err)
+		}
+		if attempt == ecrTokenAttempts {
+			break
+		}
+
+		log.Printf("getting ECR auth token failed (attempt %d / %d), retrying in %s: %s", attempt, ecrTokenAttempts, retryDelay, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("getting ECR auth token: %w", ctx.Err())
+		case <-time.After(retryDelay):
+		}
+		retryDelay *= 2
+	}
+	return nil, fmt.Errorf("getting ECR auth token after %d attempts: %w", ecrTokenAttempts, err)
+}
+
+// ParseECRRegionOverrides parses a comma separated list of '<host-prefix>=<region>' pairs into a map of host prefix to region
+// e.g. '123456789012.dkr.ecr.eu-west-1.amazonaws.com/replicated=us-east-1'
+func ParseECRRegionOverrides(s string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		prefix, region, found := strings.Cut(pair, "=")
+		if !found || len(prefix) == 0 || len(region) == 0 {
+			return nil, fmt.Errorf("expected '<host-prefix>=<region>', got '%s'", pair)
+		}
+		if !sliceContains(AllAWSRegions, region) {
+			return nil, fmt.Errorf("invalid AWS region '%s' for host prefix '%s'", region, prefix)
+		}
+		overrides[prefix] = region
+	}
+	return overrides, nil
+}
+
+// ecrRegionOverride returns the region to obtain auth from for an image whose ref starts with one of the override host prefixes
+// The longest matching prefix wins, so a specific repository can be overridden separately from the rest of its registry
+func (c *Config) ecrRegionOverride(imageReference string) (string, bool) {
+	var matchedPrefix, region string
+	for prefix, r := range c.ecrRegionOverrides {
+		if strings.HasPrefix(imageReference, prefix) && len(prefix) > len(matchedPrefix) {
+			matchedPrefix, region = prefix, r
+		}
+	}
+	return region, len(matchedPrefix) > 0
+}
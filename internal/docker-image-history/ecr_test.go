@@ -0,0 +1,209 @@
+package docker_image_history
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// fakeECRAuthTokenAPI fails the first failures requests for an auth token with err (throttling if not set), and then returns the token
+type fakeECRAuthTokenAPI struct {
+	failures int
+	err      error
+	calls    int
+	token    string
+}
+
+func (f *fakeECRAuthTokenAPI) GetAuthorizationToken(context.Context, *ecr.GetAuthorizationTokenInput, ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		if f.err != nil {
+			return nil, f.err
+		}
+		return nil, &fakeAPIError{code: "ThrottlingException"}
+	}
+	return &ecr.GetAuthorizationTokenOutput{AuthorizationData: []types.AuthorizationData{{AuthorizationToken: aws.String(f.token)}}}, nil
+}
+
+func TestGetAuthorizationTokenWithRetry(t *testing.T) {
+	client := &fakeECRAuthTokenAPI{failures: 2, token: "QVdTOnNlY3JldA=="}
+
+	resp, err := getAuthorizationTokenWithRetry(context.Background(), client, time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected the token after retrying, got: %s", err)
+	}
+	if client.calls != 3 {
+		t.Errorf("expected 3 requests, got %d", client.calls)
+	}
+	if got := aws.ToString(resp.AuthorizationData[0].AuthorizationToken); got != client.token {
+		t.Errorf("expected token '%s', got '%s'", client.token, got)
+	}
+}
+
+// fakeAPIError is an AWS API error with an error code, e.g. 'ThrottlingException' or 'AccessDeniedException'
+type fakeAPIError struct {
+	code string
+}
+
+func (e *fakeAPIError) Error() string     { return "api error " + e.code }
+func (e *fakeAPIError) ErrorCode() string { return e.code }
+
+// fakeStatusError is an AWS error response with a HTTP status code
+type fakeStatusError struct {
+	status int
+}
+
+func (e *fakeStatusError) Error() string {
+	return fmt.Sprintf("http response error StatusCode: %d", e.status)
+}
+func (e *fakeStatusError) HTTPStatusCode() int { return e.status }
+
+func TestGetAuthorizationTokenWithRetryClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{name: "throttling is retried", err: &fakeAPIError{code: "ThrottlingException"}, wantCalls: 2},
+		{name: "5xx is retried", err: &fakeStatusError{status: http.StatusServiceUnavailable}, wantCalls: 2},
+		{name: "429 is retried", err: &fakeStatusError{status: http.StatusTooManyRequests}, wantCalls: 2},
+		{name: "transport error is retried", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, wantCalls: 2},
+		{name: "access denied is not retried", err: &fakeAPIError{code: "AccessDeniedException"}, wantCalls: 1},
+		{name: "expired credentials are not retried", err: &fakeAPIError{code: "ExpiredTokenException"}, wantCalls: 1},
+		{name: "4xx is not retried", err: &fakeStatusError{status: http.StatusForbidden}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeECRAuthTokenAPI{failures: 1, err: tt.err, token: "QVdTOnNlY3JldA=="}
+
+			_, err := getAuthorizationTokenWithRetry(context.Background(), client, time.Millisecond)
+			if client.calls != tt.wantCalls {
+				t.Errorf("expected %d requests, got %d", tt.wantCalls, client.calls)
+			}
+			if retried := tt.wantCalls > 1; retried != (err == nil) {
+				t.Errorf("expected the error to be returned only when it is not retried, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetAuthorizationTokenWithRetryGivesUp(t *testing.T) {
+	client := &fakeECRAuthTokenAPI{failures: ecrTokenAttempts}
+
+	if _, err := getAuthorizationTokenWithRetry(context.Background(), client, time.Millisecond); err == nil {
+		t.Fatal("expected an error once every attempt has failed")
+	}
+	if client.calls != ecrTokenAttempts {
+		t.Errorf("expected %d requests, got %d", ecrTokenAttempts, client.calls)
+	}
+}
+
+func TestGetAuthorizationTokenWithRetryCancelled(t *testing.T) {
+	client := &fakeECRAuthTokenAPI{failures: ecrTokenAttempts}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := getAuthorizationTokenWithRetry(ctx, client, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation to stop the retries, got: %v", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("expected 1 request before stopping, got %d", client.calls)
+	}
+}
+
+func TestECRCredentialsNeverLogged(t *testing.T) {
+	const secret = "s3cr3t-ecr-password"
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid token", token: base64.StdEncoding.EncodeToString([]byte("AWS:" + secret))},
+		{name: "token is not base64", token: "AWS:" + secret, wantErr: true},
+		{name: "token has no username", token: base64.StdEncoding.EncodeToString([]byte(secret)), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			client := &fakeECRAuthTokenAPI{token: tt.token}
+			_, err := ecrCredentialsFromClient(context.Background(), client, "eu-west-1", true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error to be %t, got: %v", tt.wantErr, err)
+			}
+			// The error is logged by the caller
+			if err != nil {
+				log.Printf("loading config: %s", err)
+			}
+
+			if !strings.Contains(logs.String(), "ECR auth for region 'eu-west-1'") {
+				t.Errorf("expected the token metadata to be logged with debugAuth, got: %s", logs.String())
+			}
+			if strings.Contains(logs.String(), secret) || strings.Contains(logs.String(), tt.token) {
+				t.Errorf("the ECR password was logged: %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestECRCredentialsForRegionDoesNotBlockOtherRegions(t *testing.T) {
+	slowFetchStarted, releaseSlowFetch := make(chan struct{}), make(chan struct{})
+	var started sync.Once
+	c := newTestConfig(nil)
+	c.ecrCredentials = map[string]ecrToken{"eu-west-1": {auth: "eu-west-1-auth"}}
+	c.fetchECRToken = func(_ context.Context, _, region string, _ bool) (ecrToken, error) {
+		started.Do(func() { close(slowFetchStarted) })
+		<-releaseSlowFetch
+		return ecrToken{auth: region + "-auth"}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.ecrCredentialsForRegion(context.Background(), "us-east-1")
+		}(i)
+	}
+	<-slowFetchStarted
+
+	done := make(chan string)
+	go func() {
+		auth, _ := c.ecrCredentialsForRegion(context.Background(), "eu-west-1")
+		done <- auth
+	}()
+	select {
+	case auth := <-done:
+		if auth != "eu-west-1-auth" {
+			t.Errorf("expected the cached credentials, got '%s'", auth)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the credentials of a cached region were blocked by fetching another region")
+	}
+
+	close(releaseSlowFetch)
+	wg.Wait()
+	for _, auth := range results {
+		if auth != "us-east-1-auth" {
+			t.Errorf("expected the fetched credentials, got '%s'", auth)
+		}
+	}
+}
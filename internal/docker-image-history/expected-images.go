@@ -0,0 +1,111 @@
+package docker_image_history
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// digestDrift stores a running container whose image digest does not match the approved digest for its workload
+type digestDrift struct {
+	workloadContainer string
+	expectedDigest    string
+	imageRef          string
+	pod               podDetails
+}
+
+// loadExpectedImages parses a file of approved digests into a map of '<namespace>/<workload>/<container>' to digest
+// Each line is in the format '<namespace>/<workload>/<container> sha256:<hex>'. Blank lines and lines starting with '#' are ignored
+func loadExpectedImages(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening expected images file '%s': %w", path, err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", path, err)
+		}
+	}(f)
+
+	expected := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.Count(fields[0], "/") != 2 || !strings.HasPrefix(fields[1], "sha256:") {
+			return nil, fmt.Errorf("parsing line %d of '%s': expected '<namespace>/<workload>/<container> sha256:<hex>', got '%s'", lineNumber, path, line)
+		}
+		expected[fields[0]] = fields[1]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading expected images file '%s': %w", path, err)
+	}
+
+	return expected, nil
+}
+
+// findDigestDrift compares the digests running in the cluster against the approved digests
+// Containers which have not reported a digest yet (e.g. pending pods) cannot be verified and are skipped
+func (c *Config) findDigestDrift(expected map[string]string) []digestDrift {
+	var drift []digestDrift
+	for image, details := range c.dockerImages {
+		for _, pd := range details {
+			key := fmt.Sprintf("%s/%s/%s", pd.namespace, pd.workloadName, pd.containerName)
+			expectedDigest, ok := expected[key]
+			if !ok || len(pd.imageDigest) == 0 {
+				continue
+			}
+			if pd.imageDigest != expectedDigest {
+				drift = append(drift, digestDrift{workloadContainer: key, expectedDigest: expectedDigest, imageRef: image, pod: pd})
+			}
+		}
+	}
+	return drift
+}
+
+// outputDigestDrift writes to a file all the running containers whose image digest does not match the approved digest
+func (c *Config) outputDigestDrift() error {
+	expected, err := loadExpectedImages(c.expectedImagesPath)
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded %d approved digests from: %s", len(expected), c.expectedImagesPath)
+
+	drift := c.findDigestDrift(expected)
+	if len(drift) == 0 {
+		log.Println("All running images match their approved digests. Nothing to output.")
+		return nil
+	}
+
+	digestDriftResultsPath := c.resultsPath("digest-drift", "txt")
+	f, err := c.openResultsFile(digestDriftResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", digestDriftResultsPath, err)
+		}
+	}(f)
+
+	for _, d := range drift {
+		_, err = f.WriteString(fmt.Sprintf("%s\trunning image does not match approved digest (image: %s, podName: %s, expected: %s, running: %s)\n",
+			d.workloadContainer, d.imageRef, d.pod.podName, d.expectedDigest, d.pod.imageDigest))
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", digestDriftResultsPath, err)
+		}
+	}
+	log.Printf("Found %d containers running an unapproved digest. Results written to: %s", len(drift), digestDriftResultsPath)
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fields a keyword can be targeted at with the '<field>:<keyword>' syntax, e.g. 'env:AWS_SECRET'. Untargeted keywords are matched
+// against every history entry (and the annotations and labels with scanAnnotations)
+const (
+	fieldHistory    = "history"
+	fieldRun        = "run"
+	fieldEnv        = "env"
+	fieldLabel      = "label"
+	fieldEntrypoint = "entrypoint"
+)
+
+// keywordFields are the fields supported by the '<field>:<keyword>' syntax
+var keywordFields = []string{fieldHistory, fieldRun, fieldEnv, fieldLabel, fieldEntrypoint}
+
+// parseKeywordField splits a (non-negated) keyword targeted at a field into the field and the term to search for
+// Returns an empty field if the keyword is not targeted, so keywords which happen to contain a colon (e.g. 'http://') are unaffected
+func parseKeywordField(keyword string) (string, string) {
+	field, term, found := strings.Cut(keyword, ":")
+	if !found || len(term) == 0 || !sliceContains(keywordFields, strings.ToLower(field)) {
+		return "", keyword
+	}
+	return strings.ToLower(field), term
+}
+
+// isConfigField returns whether a field is read from the image config rather than its history
+func isConfigField(field string) bool {
+	return field == fieldEnv || field == fieldLabel || field == fieldEntrypoint
+}
+
+// validateKeywordFields checks that no negated keyword targets a field of the image config, as absence is only checked in the history
+func validateKeywordFields(keywords []string) error {
+	for _, keyword := range keywords {
+		term, negated := parseNegatedKeyword(keyword)
+		if field, _ := parseKeywordField(term); negated && isConfigField(field) {
+			return fmt.Errorf("the negated keyword '%s' cannot target the %s field, only the history and run fields can be negated", keyword, field)
+		}
+	}
+	return nil
+}
+
+// historyFieldMatches returns whether a keyword's field applies to a history entry created by the given instruction, along with the term to search for
+// Keywords targeting the image config never match a history entry
+func historyFieldMatches(keyword, instruction string) (string, bool) {
+	field, term := parseKeywordField(keyword)
+	switch field {
+	case fieldRun:
+		return term, instruction == instructionRun
+	case fieldEnv, fieldLabel, fieldEntrypoint:
+		return term, false
+	default:
+		return term, true
+	}
+}
+
+// configFieldKeywords returns the keywords for an image which target a field of its config
+func (c *Config) configFieldKeywords(imageRef string) []string {
+	var keywords []string
+	for _, keyword := range c.keywordsForImage(imageRef) {
+		if field, _ := parseKeywordField(keyword); isConfigField(field) {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// configValue is a single value of an image config field, e.g. an env var, along with where it came from (env, label, entrypoint or cmd)
+type configValue struct {
+	source string
+	text   string
+}
+
+// String formats the value as '<source> <text>' for reporting
+func (v configValue) String() string {
+	return v.source + " " + v.text
+}
+
+// imageConfigFields are the env vars, labels and entrypoint (and cmd) of an image, keyed by the field keywords target them with
+type imageConfigFields map[string][]configValue
+
+// newImageConfigFields collects the fields of an image config which keywords can target
+func newImageConfigFields(env []string, labels map[string]string, entrypoint, cmd []string) imageConfigFields {
+	fields := imageConfigFields{}
+	for _, e := range env {
+		fields[fieldEnv] = append(fields[fieldEnv], configValue{source: fieldEnv, text: e})
+	}
+	for k, v := range labels {
+		fields[fieldLabel] = append(fields[fieldLabel], configValue{source: fieldLabel, text: k + "=" + v})
+	}
+	sort.Slice(fields[fieldLabel], func(i, j int) bool { return fields[fieldLabel][i].text < fields[fieldLabel][j].text })
+	if len(entrypoint) > 0 {
+		fields[fieldEntrypoint] = append(fields[fieldEntrypoint], configValue{source: fieldEntrypoint, text: strings.Join(entrypoint, " ")})
+	}
+	if len(cmd) > 0 {
+		fields[fieldEntrypoint] = append(fields[fieldEntrypoint], configValue{source: "cmd", text: strings.Join(cmd, " ")})
+	}
+	return fields
+}
+
+// matchConfigFieldsForKeyWords checks the targeted fields of an image config for the keywords targeting them
+// Matches are added to the result's matched metadata, recording the field and value which matched each keyword
+func (c *Config) matchConfigFieldsForKeyWords(imageRef string, keywords []string, fields imageConfigFields, result *offendingDockerImage) {
+	for _, keyword := range keywords {
+		field, term := parseKeywordField(keyword)
+		for _, v := range fields[field] {
+			if !c.containsKeyword(v.text, term) {
+				continue
+			}
+			value := v.String()
+			if result.matchedMetadata == nil {
+				result.matchedMetadata = make(map[string][]string)
+			}
+			if c.redact {
+				value = redactMetadata(value)
+			}
+			result.matchFound = true
+			result.imageRef = imageRef
+			result.matchedMetadata[keyword] = append(result.matchedMetadata[keyword], value)
+			_, _ = fmt.Fprintf(c.findings, "FOUND (%s): %+v\n", value, result)
+		}
+	}
+}
+
+// matchLocalConfigFields checks the config of a pulled image for the keywords targeting its env vars, labels or entrypoint
+func (c *Config) matchLocalConfigFields(ctx context.Context, imageRef string, result *offendingDockerImage) error {
+	keywords := c.configFieldKeywords(imageRef)
+	if len(keywords) == 0 {
+		return nil
+	}
+
+	inspect, _, err := c.dockerClient.ImageInspectWithRaw(ctx, imageRef)
+	if err != nil {
+		return fmt.Errorf("inspecting local image '%s': %w", imageRef, err)
+	}
+	if inspect.Config == nil {
+		return nil
+	}
+	fields := newImageConfigFields(inspect.Config.Env, inspect.Config.Labels, inspect.Config.Entrypoint, inspect.Config.Cmd)
+	c.matchConfigFieldsForKeyWords(imageRef, keywords, fields, result)
+	return nil
+}
+
+// matchRemoteConfigFields checks the config blob of an image in its registry for the keywords targeting its env vars, labels or entrypoint
+func (c *Config) matchRemoteConfigFields(ctx context.Context, imageRef string, result *offendingDockerImage) error {
+	keywords := c.configFieldKeywords(imageRef)
+	if len(keywords) == 0 {
+		return nil
+	}
+
+	img, err := c.remoteImage(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("fetching remote image config for '%s': %w", imageRef, err)
+	}
+	fields := newImageConfigFields(configFile.Config.Env, configFile.Config.Labels, configFile.Config.Entrypoint, configFile.Config.Cmd)
+	c.matchConfigFieldsForKeyWords(imageRef, keywords, fields, result)
+	return nil
+}
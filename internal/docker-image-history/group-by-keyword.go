@@ -0,0 +1,115 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// Supported layouts of the offending images results. Either a line per image listing its keywords, or a section per keyword listing its images
+const (
+	GroupByImage   = "image"
+	GroupByKeyword = "keyword"
+)
+
+// keywordGroup is a keyword along with all the offending images which matched it
+type keywordGroup struct {
+	keyword string
+	images  []offendingDockerImage
+}
+
+// jsonKeywordGroup is a keyword along with all the offending images which matched it in the JSON results
+type jsonKeywordGroup struct {
+	Keyword string      `json:"keyword"`
+	Images  []jsonImage `json:"images"`
+}
+
+// groupKeywords returns the keywords an offending image is grouped under: its matched keywords (in its history or metadata),
+// its absent keywords prefixed with '!' and its triggered keyword rules prefixed with 'rule:'
+func groupKeywords(i offendingDockerImage) []string {
+	var keywords []string
+	for keyword := range i.matchedKeywords {
+		keywords = appendUnique(keywords, keyword)
+	}
+	for keyword := range i.matchedMetadata {
+		keywords = appendUnique(keywords, keyword)
+	}
+	for _, keyword := range i.absentKeywords {
+		keywords = appendUnique(keywords, "!"+keyword)
+	}
+	for _, rule := range i.matchedRules {
+		keywords = appendUnique(keywords, "rule:"+rule)
+	}
+	return keywords
+}
+
+// keywordGroups inverts the offending images into a group per keyword. Keywords are sorted, and the images in each group keep their scan order
+func (c *Config) keywordGroups() []keywordGroup {
+	groupIndex := make(map[string]int)
+	var groups []keywordGroup
+	for _, i := range c.offendingDockerImages {
+		for _, keyword := range groupKeywords(i) {
+			g, found := groupIndex[keyword]
+			if !found {
+				g = len(groups)
+				groupIndex[keyword] = g
+				groups = append(groups, keywordGroup{keyword: keyword})
+			}
+			groups[g].images = append(groups[g].images, i)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].keyword < groups[j].keyword })
+	return groups
+}
+
+// jsonKeywordGroups returns the offending images grouped by keyword for the JSON results
+func (c *Config) jsonKeywordGroups() []jsonKeywordGroup {
+	groups := make([]jsonKeywordGroup, 0)
+	for _, g := range c.keywordGroups() {
+		group := jsonKeywordGroup{Keyword: g.keyword, Images: make([]jsonImage, 0, len(g.images))}
+		for _, i := range g.images {
+			group.Images = append(group.Images, c.jsonImage(i.imageRef))
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// outputOffendingImagesByKeyword writes a section per keyword to a file, listing every image which matched it and the pods running them
+// An image which matched several keywords is listed in each of their sections
+func (c *Config) outputOffendingImagesByKeyword() error {
+	if len(c.offendingDockerImages) == 0 {
+		log.Println("No images matched keywords. Nothing to output.")
+		return nil
+	}
+
+	offendingImageResultsPath := c.resultsPath("offending-images-by-keyword", "txt")
+	f, err := c.openResultsFile(offendingImageResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", offendingImageResultsPath, err)
+		}
+	}(f)
+
+	for _, g := range c.keywordGroups() {
+		_, err = f.WriteString(fmt.Sprintf("keyword %s: %d images\n", g.keyword, len(g.images)))
+		for _, i := range g.images {
+			_, err = f.WriteString(fmt.Sprintf("\t%s\t", i.imageRef))
+			for _, match := range c.formatPods(c.dockerImages[i.imageRef]) {
+				_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+			}
+			_, err = f.WriteString("\n")
+		}
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", offendingImageResultsPath, err)
+		}
+	}
+	log.Printf("Offending image results grouped by keyword written to: %s", offendingImageResultsPath)
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventImagePattern extracts the image from the message of a kubelet image event, e.g. 'Pulling image "nginx:1.23"'
+var eventImagePattern = regexp.MustCompile(`image "([^"]+)"`)
+
+// eventContainerPattern extracts the container name from the field path of an event's pod, e.g. 'spec.containers{nginx}'
+var eventContainerPattern = regexp.MustCompile(`^spec\.containers\{([^}]+)\}$`)
+
+// isTerminatedPod returns whether a pod has finished running (e.g. a completed Job pod) but is still present in the K8s API
+func isTerminatedPod(pod corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// queryEventImages adds the images of pods which ran recently but no longer exist (e.g. Job pods which have been cleaned up) from the
+// kubelet's image pull events, attributed to their pod as historical. Events are only kept for a limited time (an hour by default)
+// Pods which still exist have already been discovered, so only events for images not already attributed to their pod are added
+func (c *Config) queryEventImages(ctx context.Context) error {
+	events, err := c.k8sClient.CoreV1().Events(c.namespace).List(ctx, metav1.ListOptions{FieldSelector: "involvedObject.kind=Pod"})
+	if err != nil {
+		return fmt.Errorf("querying for k8s pod events: %w", err)
+	}
+
+	attributed := make(map[string]bool)
+	for image, details := range c.dockerImages {
+		for _, pd := range details {
+			attributed[pd.namespace+"/"+pd.podName+"/"+image] = true
+		}
+	}
+
+	added := 0
+	for _, event := range events.Items {
+		if event.Reason != "Pulling" && event.Reason != "Pulled" {
+			continue
+		}
+		match := eventImagePattern.FindStringSubmatch(event.Message)
+		if match == nil {
+			continue
+		}
+		image := match[1]
+		key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name + "/" + image
+		if attributed[key] {
+			continue
+		}
+		attributed[key] = true
+
+		var containerName string
+		if m := eventContainerPattern.FindStringSubmatch(event.InvolvedObject.FieldPath); m != nil {
+			containerName = m[1]
+		}
+		pd := podDetails{
+			podName:       event.InvolvedObject.Name,
+			containerName: containerName,
+			namespace:     event.InvolvedObject.Namespace,
+			workloadKind:  "Pod",
+			workloadName:  event.InvolvedObject.Name,
+			podUID:        string(event.InvolvedObject.UID),
+			historical:    true,
+		}
+		if _, seen := c.dockerImages[image]; !seen {
+			if reason, mutable := mutableTag(image); mutable {
+				c.mutableTagImages[image] = reason
+			}
+		}
+		c.dockerImages[image] = append(c.dockerImages[image], pd)
+		added++
+	}
+	log.Printf("Found %d containers of pods which no longer exist in the pod events, now %d unique images", added, len(c.dockerImages))
+
+	return nil
+}
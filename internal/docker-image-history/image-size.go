@@ -0,0 +1,60 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/docker/go-units"
+)
+
+// oversizedImage stores an image which is larger than the maximum image size
+type oversizedImage struct {
+	imageRef string
+	size     int64
+}
+
+// checkImageSize records the image as oversized if it is larger than the maximum image size. Safe for concurrent use
+func (c *Config) checkImageSize(imageRef string, size int64) {
+	if c.maxImageSizeBytes <= 0 || size <= c.maxImageSizeBytes {
+		return
+	}
+	_, _ = fmt.Fprintf(c.findings, "OVERSIZED: %s (%s)\n", imageRef, units.HumanSize(float64(size)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.oversizedImages = append(c.oversizedImages, oversizedImage{imageRef: imageRef, size: size})
+}
+
+// outputOversizedImages writes to a file all the images which are larger than the maximum image size, along with the pods running them
+func (c *Config) outputOversizedImages() error {
+	if len(c.oversizedImages) == 0 {
+		return nil
+	}
+
+	oversizedImagesResultsPath := c.resultsPath("oversized-images", "txt")
+	f, err := c.openResultsFile(oversizedImagesResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", oversizedImagesResultsPath, err)
+		}
+	}(f)
+
+	for _, o := range c.oversizedImages {
+		_, err = f.WriteString(fmt.Sprintf("%s\t(size: %s) ", o.imageRef, units.HumanSize(float64(o.size))))
+		for _, match := range c.formatPods(c.dockerImages[o.imageRef]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", oversizedImagesResultsPath, err)
+		}
+	}
+	log.Printf("%d images are larger than the maximum image size. Results written to: %s", len(c.oversizedImages), oversizedImagesResultsPath)
+
+	return nil
+}
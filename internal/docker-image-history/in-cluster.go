@@ -0,0 +1,63 @@
+package docker_image_history
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/homedir"
+)
+
+// inClusterContextName labels the results of a scan which used the in-cluster config, in place of a K8s context name
+const inClusterContextName = "in-cluster"
+
+// serviceAccountTokenPath is where K8s mounts the service account token into a pod
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// RunningInPod returns whether the tool is running inside a K8s pod, detected by its mounted service account token and the API
+// server address K8s sets in the environment
+func RunningInPod() bool {
+	if len(os.Getenv("KUBERNETES_SERVICE_HOST")) == 0 {
+		return false
+	}
+	_, err := os.Stat(serviceAccountTokenPath)
+	return err == nil
+}
+
+// resolveInCluster returns whether to use the in-cluster config rather than a context in ${HOME}/.kube/config. Exactly one source
+// must be resolved: an explicit context, InCluster, or neither when running inside a pod, in which case the in-cluster config is detected
+func resolveInCluster(contextName string, inCluster bool) (bool, error) {
+	switch {
+	case inCluster && len(contextName) > 0:
+		return false, errors.New("only one K8s config source can be used: set either ClusterK8sContextName or InCluster")
+	case inCluster:
+		return true, nil
+	case len(contextName) > 0:
+		return false, nil
+	case RunningInPod():
+		log.Println("No K8s context is set and running inside a pod. Using the in-cluster service account config")
+		return true, nil
+	default:
+		return false, errors.New("no K8s config source: set ClusterK8sContextName to a context in ${HOME}/.kube/config, or InCluster when running inside a pod")
+	}
+}
+
+// newK8sRestConfig returns the in-cluster service account config, or the config for the context in ${HOME}/.kube/config
+func newK8sRestConfig(contextName string, inCluster bool) (*rest.Config, error) {
+	if inCluster {
+		k8sConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("loading in-cluster k8s config: %w", err)
+		}
+		return k8sConfig, nil
+	}
+
+	k8sConfig, err := buildConfigWithContextFromFlags(contextName, filepath.Join(homedir.HomeDir(), ".kube", "config"))
+	if err != nil {
+		return nil, fmt.Errorf("loading k8s config file: %w", err)
+	}
+	return k8sConfig, nil
+}
@@ -0,0 +1,83 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// inconsistentDigest is an image ref which resolves to different digests across the pods running it, e.g. a ':latest' tag which some
+// nodes pulled before it was updated
+type inconsistentDigest struct {
+	imageRef string
+	digests  map[string][]podDetails
+}
+
+// findInconsistentDigests finds the image refs which are running with more than one digest, using the digest each container reported in
+// its status. Containers which have not reported a digest yet (e.g. pending pods) are ignored. Sorted by image ref
+func (c *Config) findInconsistentDigests() {
+	c.inconsistentDigests = nil
+	for image, details := range c.dockerImages {
+		digests := make(map[string][]podDetails)
+		for _, pd := range details {
+			if len(pd.imageDigest) > 0 {
+				digests[pd.imageDigest] = append(digests[pd.imageDigest], pd)
+			}
+		}
+		if len(digests) > 1 {
+			c.inconsistentDigests = append(c.inconsistentDigests, inconsistentDigest{imageRef: image, digests: digests})
+		}
+	}
+	sort.Slice(c.inconsistentDigests, func(i, j int) bool { return c.inconsistentDigests[i].imageRef < c.inconsistentDigests[j].imageRef })
+
+	if len(c.inconsistentDigests) > 0 {
+		log.Printf("WARNING: %d images are running with different digests across their pods", len(c.inconsistentDigests))
+	}
+}
+
+// sortedDigests returns the distinct digests of an inconsistent image, sorted
+func (d inconsistentDigest) sortedDigests() []string {
+	digests := make([]string, 0, len(d.digests))
+	for digest := range d.digests {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+// outputInconsistentDigests writes to a file each image ref running with more than one digest, along with which pods run each digest
+func (c *Config) outputInconsistentDigests() error {
+	if len(c.inconsistentDigests) == 0 {
+		return nil
+	}
+
+	inconsistentDigestsResultsPath := c.resultsPath("inconsistent-digests", "txt")
+	f, err := c.openResultsFile(inconsistentDigestsResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", inconsistentDigestsResultsPath, err)
+		}
+	}(f)
+
+	for _, d := range c.inconsistentDigests {
+		_, err = f.WriteString(fmt.Sprintf("%s\t", d.imageRef))
+		for _, digest := range d.sortedDigests() {
+			_, err = f.WriteString(fmt.Sprintf("[digest: %s] ", digest))
+			for _, match := range c.formatPods(d.digests[digest]) {
+				_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+			}
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", inconsistentDigestsResultsPath, err)
+		}
+	}
+	log.Printf("Inconsistent image digests written to: %s", inconsistentDigestsResultsPath)
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package docker_image_history
+
+import "strings"
+
+// instructionRun is the Dockerfile instruction which executes a shell command
+const instructionRun = "RUN"
+
+// dockerfileInstructions are the instructions which BuildKit records at the start of a history entry
+var dockerfileInstructions = []string{"ADD", "ARG", "CMD", "COPY", "ENTRYPOINT", "ENV", "EXPOSE", "HEALTHCHECK", "LABEL", "MAINTAINER",
+	"ONBUILD", "RUN", "SHELL", "STOPSIGNAL", "USER", "VOLUME", "WORKDIR"}
+
+// instructionType returns the Dockerfile instruction which created a history entry, or an empty string if it cannot be determined
+// The legacy builder records metadata instructions as '/bin/sh -c #(nop) <INSTRUCTION> ...' and RUN as '/bin/sh -c <command>'
+// BuildKit records '<INSTRUCTION> ...', with RUN commands that use build args prefixed by '|<count> <args>'
+func instructionType(createdBy string) string {
+	createdBy = strings.TrimSpace(createdBy)
+
+	if i := strings.Index(createdBy, "#(nop)"); i >= 0 {
+		fields := strings.Fields(createdBy[i+len("#(nop)"):])
+		if len(fields) > 0 && sliceContains(dockerfileInstructions, strings.ToUpper(fields[0])) {
+			return strings.ToUpper(fields[0])
+		}
+		return ""
+	}
+	if strings.HasPrefix(createdBy, "|") || strings.HasPrefix(createdBy, "/bin/sh -c") || strings.HasPrefix(strings.ToLower(createdBy), "cmd /s /c") {
+		return instructionRun
+	}
+
+	fields := strings.Fields(createdBy)
+	if len(fields) > 0 && sliceContains(dockerfileInstructions, fields[0]) {
+		return fields[0]
+	}
+	return ""
+}
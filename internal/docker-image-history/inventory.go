@@ -0,0 +1,122 @@
+package docker_image_history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// ecrHostPattern matches the host of a private ECR registry, capturing the AWS region
+var ecrHostPattern = regexp.MustCompile(`^[0-9]{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?/`)
+
+// inventory is the JSON document describing every image running in the cluster and where it is running
+type inventory struct {
+	ClusterContext string           `json:"clusterContext"`
+	GeneratedAt    time.Time        `json:"generatedAt"`
+	Images         []inventoryImage `json:"images"`
+	NodeSummary    *nodeSummary     `json:"nodeSummary,omitempty"`
+}
+
+// nodeSummary totals the images cached on the nodes, and whether they are used by the running pods
+type nodeSummary struct {
+	CachedImages       int   `json:"cachedImages"`
+	CachedBytes        int64 `json:"cachedBytes"`
+	UncachedImages     int   `json:"uncachedImages"`
+	UnusedCachedImages int   `json:"unusedCachedImages"`
+	UnusedCachedBytes  int64 `json:"unusedCachedBytes"`
+}
+
+// inventoryImage is a single image in the inventory along with its registry classification
+type inventoryImage struct {
+	ImageRef      string         `json:"imageRef"`
+	ECR           bool           `json:"ecr"`
+	ECRRegion     string         `json:"ecrRegion,omitempty"`
+	NodeSizeBytes int64          `json:"nodeSizeBytes,omitempty"`
+	Nodes         []string       `json:"nodes,omitempty"`
+	Pods          []inventoryPod `json:"pods"`
+}
+
+// inventoryPod provides the K8s context for an image in the inventory
+type inventoryPod struct {
+	PodName       string `json:"podName"`
+	ContainerName string `json:"containerName"`
+	Namespace     string `json:"namespace"`
+	WorkloadKind  string `json:"workloadKind"`
+	WorkloadName  string `json:"workloadName"`
+	ImageDigest   string `json:"imageDigest,omitempty"`
+}
+
+// parseECRRegion returns the AWS region from the host of an ECR image ref, and whether the image is in a private ECR registry
+func parseECRRegion(imageRef string) (string, bool) {
+	match := ecrHostPattern.FindStringSubmatch(imageRef)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// buildInventory builds the inventory from the images discovered in the cluster. Images are sorted by ref so the output is deterministic
+// If the node images have been queried, each image includes the nodes it is cached on and its size there
+func (c *Config) buildInventory() inventory {
+	inv := inventory{
+		ClusterContext: c.clusterK8sContextName,
+		GeneratedAt:    time.Now().UTC(),
+		Images:         make([]inventoryImage, 0, len(c.dockerImages)),
+	}
+
+	for image, details := range c.dockerImages {
+		region, _ := parseECRRegion(image)
+		ii := inventoryImage{ImageRef: image, ECR: isECRImage(image), ECRRegion: region, Pods: make([]inventoryPod, 0, len(details))}
+		for _, pd := range details {
+			ii.Pods = append(ii.Pods, inventoryPod{
+				PodName:       pd.podName,
+				ContainerName: pd.containerName,
+				Namespace:     pd.namespace,
+				WorkloadKind:  pd.workloadKind,
+				WorkloadName:  pd.workloadName,
+				ImageDigest:   pd.imageDigest,
+			})
+		}
+		if e, ok := c.nodeImageFor(image); ok {
+			ii.NodeSizeBytes = e.sizeBytes
+			ii.Nodes = e.nodes
+		}
+		inv.Images = append(inv.Images, ii)
+	}
+	sort.Slice(inv.Images, func(i, j int) bool { return inv.Images[i].ImageRef < inv.Images[j].ImageRef })
+
+	if c.nodeImages != nil {
+		inv.NodeSummary = c.buildNodeSummary()
+	}
+
+	return inv
+}
+
+// outputInventory writes the images discovered in the cluster, and the pods running them, to a JSON file
+func (c *Config) outputInventory() error {
+	inventoryPath := c.resultsPath("inventory", "json")
+
+	inv := c.buildInventory()
+	jsonBytes, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling inventory into JSON: %w", err)
+	}
+
+	if err = os.WriteFile(inventoryPath, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("writing inventory to '%s': %w", inventoryPath, err)
+	}
+	log.Printf("Image inventory written to: %s", inventoryPath)
+	if inv.NodeSummary != nil {
+		log.Printf("Node images: %d running images cached (%s), %d running images not cached, %d cached images not used by any pod (%s)",
+			inv.NodeSummary.CachedImages, units.HumanSize(float64(inv.NodeSummary.CachedBytes)), inv.NodeSummary.UncachedImages,
+			inv.NodeSummary.UnusedCachedImages, units.HumanSize(float64(inv.NodeSummary.UnusedCachedBytes)))
+	}
+
+	return nil
+}
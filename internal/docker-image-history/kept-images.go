@@ -0,0 +1,47 @@
+package docker_image_history
+
+import (
+	"log"
+
+	"github.com/docker/go-units"
+)
+
+// keepImage returns whether a pulled image is kept in the local Docker instance after it has been scanned rather than removed:
+// every image when keepImages is set, or only those which matched keywords when keepOffendingImages is set. Safe for concurrent use
+func (c *Config) keepImage(image pulledImage) bool {
+	if c.keepImages {
+		return true
+	}
+	if !c.keepOffendingImages {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offendingRefs[image.imageRef]
+}
+
+// recordKeptImage logs and counts a pulled image which is kept rather than removed, so the disk it uses is not a surprise
+// Safe for concurrent use
+func (c *Config) recordKeptImage(image pulledImage) {
+	log.Printf("Keeping image '%s' (%s, %s) in the local Docker instance", image.imageRef, image.imageID, units.HumanSize(float64(image.size)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keptImageIDs == nil {
+		c.keptImageIDs = make(map[string]bool)
+	}
+	// Several refs can resolve to the same local image, which only uses the disk once
+	if !c.keptImageIDs[image.imageID] {
+		c.keptImageIDs[image.imageID] = true
+		c.keptBytes += image.size
+	}
+}
+
+// logKeptImages logs how many pulled images were kept in the local Docker instance and the disk they use
+func (c *Config) logKeptImages() {
+	if len(c.keptImageIDs) == 0 {
+		return
+	}
+	log.Printf("Kept %d pulled images in the local Docker instance, using %s. Remove them with 'docker image rm' when they are no longer needed",
+		len(c.keptImageIDs), units.HumanSize(float64(c.keptBytes)))
+}
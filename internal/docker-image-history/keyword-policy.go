@@ -0,0 +1,120 @@
+package docker_image_history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keywordPolicies is the file format used to override the keywords for particular namespaces
+type keywordPolicies struct {
+	Policies []keywordPolicy `json:"policies"`
+}
+
+// keywordPolicy applies its keywords to namespaces matching any of the names, or all the labels
+type keywordPolicy struct {
+	Namespaces      []string          `json:"namespaces"`
+	NamespaceLabels map[string]string `json:"namespaceLabels"`
+	Keywords        []string          `json:"keywords"`
+}
+
+// matches returns whether the policy applies to a namespace with the given name and labels
+func (p keywordPolicy) matches(namespace string, labels map[string]string) bool {
+	if sliceContains(p.Namespaces, namespace) {
+		return true
+	}
+	if len(p.NamespaceLabels) == 0 {
+		return false
+	}
+	for k, v := range p.NamespaceLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// loadKeywordPolicies parses the keyword policies file
+func loadKeywordPolicies(path string) (keywordPolicies, error) {
+	var policies keywordPolicies
+
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return policies, fmt.Errorf("reading keyword policies file '%s': %w", path, err)
+	}
+	if err = json.Unmarshal(jsonBytes, &policies); err != nil {
+		return policies, fmt.Errorf("parsing keyword policies file '%s': %w", path, err)
+	}
+
+	for i, p := range policies.Policies {
+		if len(p.Keywords) == 0 || (len(p.Namespaces) == 0 && len(p.NamespaceLabels) == 0) {
+			return policies, fmt.Errorf("keyword policy %d in '%s' must set keywords and at least one of namespaces or namespaceLabels", i, path)
+		}
+		if err = validateKeywordFields(p.Keywords); err != nil {
+			return policies, fmt.Errorf("keyword policy %d in '%s': %w", i, path, err)
+		}
+	}
+	return policies, nil
+}
+
+// resolveNamespaceKeywords works out the keywords for each namespace in the cluster from the keyword policies
+// The keywords of all the policies matching a namespace are combined. Namespaces without a matching policy use the default keywords
+func (c *Config) resolveNamespaceKeywords(ctx context.Context) error {
+	policies, err := loadKeywordPolicies(c.keywordPoliciesPath)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := c.k8sClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("querying for all k8s namespaces: %w", err)
+	}
+
+	c.namespaceKeywords = make(map[string][]string)
+	for _, ns := range namespaces.Items {
+		for _, p := range policies.Policies {
+			if p.matches(ns.Name, ns.Labels) {
+				c.namespaceKeywords[ns.Name] = appendUnique(c.namespaceKeywords[ns.Name], p.Keywords...)
+			}
+		}
+		if err = c.compileKeywordRegexps(c.namespaceKeywords[ns.Name]); err != nil {
+			return fmt.Errorf("keyword policy for namespace '%s': %w", ns.Name, err)
+		}
+		if keywords, ok := c.namespaceKeywords[ns.Name]; ok {
+			log.Printf("Namespace '%s' is using the keyword policy: %v", ns.Name, keywords)
+		}
+	}
+	return nil
+}
+
+// keywordsForImage returns the keywords to check an image's history for
+// An image running in namespaces with different policies is checked against the union of their keywords
+func (c *Config) keywordsForImage(imageRef string) []string {
+	if c.namespaceKeywords == nil {
+		return c.dockerImageKeyWords
+	}
+
+	var keywords []string
+	for _, pd := range c.dockerImages[imageRef] {
+		if nsKeywords, ok := c.namespaceKeywords[pd.namespace]; ok {
+			keywords = appendUnique(keywords, nsKeywords...)
+		} else {
+			keywords = appendUnique(keywords, c.dockerImageKeyWords...)
+		}
+	}
+	return keywords
+}
+
+// appendUnique appends the elements to the slice which it does not already contain
+func appendUnique(slice []string, elems ...string) []string {
+	for _, e := range elems {
+		if !sliceContains(slice, e) {
+			slice = append(slice, e)
+		}
+	}
+	return slice
+}
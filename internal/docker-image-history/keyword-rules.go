@@ -0,0 +1,108 @@
+package docker_image_history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keywordRules is the file format used to define compound keyword rules
+type keywordRules struct {
+	Rules []keywordRule `json:"rules"`
+}
+
+// keywordRule flags an image whose history contains all of the All terms, at least one of the Any terms (if set) and none of the
+// None terms. e.g. all: ["python", "pip install"], none: ["--require-hashes"]
+type keywordRule struct {
+	Name string   `json:"name"`
+	All  []string `json:"all"`
+	Any  []string `json:"any"`
+	None []string `json:"none"`
+}
+
+// matches returns whether the rule is triggered by the set of terms present in an image's history
+func (r keywordRule) matches(present map[string]bool) bool {
+	for _, term := range r.All {
+		if !present[term] {
+			return false
+		}
+	}
+	for _, term := range r.None {
+		if present[term] {
+			return false
+		}
+	}
+	if len(r.Any) == 0 {
+		return true
+	}
+	for _, term := range r.Any {
+		if present[term] {
+			return true
+		}
+	}
+	return false
+}
+
+// terms returns every term used by the rule
+func (r keywordRule) terms() []string {
+	terms := make([]string, 0, len(r.All)+len(r.Any)+len(r.None))
+	terms = append(terms, r.All...)
+	terms = append(terms, r.Any...)
+	return append(terms, r.None...)
+}
+
+// loadKeywordRules parses the keyword rules file
+func loadKeywordRules(path string) (keywordRules, error) {
+	var rules keywordRules
+
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("reading keyword rules file '%s': %w", path, err)
+	}
+	if err = json.Unmarshal(jsonBytes, &rules); err != nil {
+		return rules, fmt.Errorf("parsing keyword rules file '%s': %w", path, err)
+	}
+
+	for i, r := range rules.Rules {
+		if len(r.Name) == 0 || (len(r.All) == 0 && len(r.Any) == 0 && len(r.None) == 0) {
+			return rules, fmt.Errorf("keyword rule %d in '%s' must set a name and at least one of all, any or none", i, path)
+		}
+		for _, term := range r.terms() {
+			if len(term) == 0 || strings.HasPrefix(term, "!") {
+				return rules, fmt.Errorf("keyword rule '%s' in '%s' has the term '%s'. Terms cannot be empty or start with '!', use none instead", r.Name, path, term)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// ruleTerms returns the distinct terms used by all the keyword rules, which are searched for in each image's history
+func (c *Config) ruleTerms() []string {
+	var terms []string
+	for _, r := range c.keywordRules.Rules {
+		terms = appendUnique(terms, r.terms()...)
+	}
+	return terms
+}
+
+// matchKeywordRules returns the names of the keyword rules triggered by an image's history
+func (c *Config) matchKeywordRules(history []historyEntry) []string {
+	present := make(map[string]bool)
+	for _, h := range history {
+		if c.runOnly && instructionType(h.createdBy) != instructionRun {
+			continue
+		}
+		for _, m := range c.matchLayer(h, c.keywordRuleTerms) {
+			present[m.keyword] = true
+		}
+	}
+
+	var matched []string
+	for _, r := range c.keywordRules.Rules {
+		if r.matches(present) {
+			matched = append(matched, r.Name)
+		}
+	}
+	return matched
+}
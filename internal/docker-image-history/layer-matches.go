@@ -0,0 +1,90 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// layerMatchDetail is a history layer which matched a keyword: its index in the history (0 is the base layer), its full instruction
+// and when it was created, if known
+type layerMatchDetail struct {
+	Layer     int    `json:"layer"`
+	CreatedBy string `json:"createdBy"`
+	Created   string `json:"created,omitempty"`
+}
+
+// recordLayerMatch records the history layer which matched a keyword against the result. When redact is set, the instruction has the
+// token containing the keyword match masked, as it is redacted from the match context, along with its high entropy tokens. A match found
+// in decoded base64 has every base64 token masked, as its offsets are in the decoded text. m is nil for the matches of custom matchers
+func (c *Config) recordLayerMatch(result *offendingDockerImage, keyword string, h historyEntry, m *layerMatch) {
+	detail := layerMatchDetail{Layer: h.index, CreatedBy: h.createdBy}
+	if c.redact {
+		switch {
+		case m == nil:
+			detail.CreatedBy = redactHighEntropyTokens(h.createdBy)
+		case m.decoded:
+			detail.CreatedBy = redactBase64Tokens(h.createdBy)
+		default:
+			detail.CreatedBy, _ = redactMatchedToken(h.createdBy, m.start, m.end)
+		}
+	}
+	if !h.created.IsZero() {
+		detail.Created = h.created.UTC().Format(time.RFC3339)
+	}
+	if result.matchedLayers == nil {
+		result.matchedLayers = make(map[string][]layerMatchDetail)
+	}
+	result.matchedLayers[keyword] = appendUniqueLayerMatches(result.matchedLayers[keyword], detail)
+}
+
+// appendUniqueLayerMatches appends the layer matches which are not already present
+func appendUniqueLayerMatches(existing []layerMatchDetail, details ...layerMatchDetail) []layerMatchDetail {
+	for _, d := range details {
+		found := false
+		for _, e := range existing {
+			if e == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, d)
+		}
+	}
+	return existing
+}
+
+// cloneLayerMatches copies a map of keywords to layer matches. Returns nil for a nil map
+func cloneLayerMatches(m map[string][]layerMatchDetail) map[string][]layerMatchDetail {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string][]layerMatchDetail, len(m))
+	for k, v := range m {
+		clone[k] = append([]layerMatchDetail(nil), v...)
+	}
+	return clone
+}
+
+// formatLayerMatches returns the layer matches of an image for the results file, e.g. "keyword 'curl' matched at layer 7: /bin/sh -c curl ... | bash"
+func formatLayerMatches(matchedLayers map[string][]layerMatchDetail) string {
+	keywords := make([]string, 0, len(matchedLayers))
+	for keyword := range matchedLayers {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	var matches []string
+	for _, keyword := range keywords {
+		for _, d := range matchedLayers[keyword] {
+			match := fmt.Sprintf("keyword '%s' matched at layer %d: %s", keyword, d.Layer, d.CreatedBy)
+			if len(d.Created) > 0 {
+				match += fmt.Sprintf(" (created %s)", d.Created)
+			}
+			matches = append(matches, match)
+		}
+	}
+	return strings.Join(matches, "; ")
+}
@@ -0,0 +1,131 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+)
+
+// cleanupAttempt is an attempt to remove a pulled image from the local Docker instance, along with the error if it failed
+type cleanupAttempt struct {
+	image pulledImage
+	err   error
+}
+
+// leakedImage is a pulled image which was still present in the local Docker instance after the scan, along with the reason it was not removed
+// retryErr is set if the removal was retried at the end of the scan and failed again
+type leakedImage struct {
+	imageRef string
+	imageID  string
+	reason   string
+	retryErr error
+}
+
+// jsonLeakedImage is a pulled image which was not removed from the local Docker instance in the JSON results
+type jsonLeakedImage struct {
+	ImageRef   string `json:"imageRef"`
+	ImageID    string `json:"imageId"`
+	Reason     string `json:"reason"`
+	RetryError string `json:"retryError,omitempty"`
+}
+
+// recordCleanupAttempt records the outcome of removing a pulled image, so it can be verified after the scan. Safe for concurrent use
+func (c *Config) recordCleanupAttempt(image pulledImage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanupAttempts = append(c.cleanupAttempts, cleanupAttempt{image: image, err: err})
+}
+
+// verifyCleanup checks that every image removed during the scan has gone from the local Docker instance. Removal can leave an image
+// behind without an error, e.g. when another ref of it is still tagged or it is used by a container. Any image still present is reported
+// as leaked with the error from removing it, if there was one. If retryCleanup is set, their removal is retried first
+func (c *Config) verifyCleanup() {
+	// Several refs can resolve to the same local image. Keep the failed attempt if there is one, as that has the reason
+	attempts := make(map[string]cleanupAttempt)
+	for _, a := range c.cleanupAttempts {
+		if existing, found := attempts[a.image.imageID]; !found || (existing.err == nil && a.err != nil) {
+			attempts[a.image.imageID] = a
+		}
+	}
+
+	ctx := context.Background()
+	for _, a := range attempts {
+		if !c.localImagePresent(ctx, a.image.imageID) {
+			continue
+		}
+
+		leaked := leakedImage{imageRef: a.image.imageRef, imageID: a.image.imageID, reason: "still present after being removed"}
+		if a.err != nil {
+			leaked.reason = a.err.Error()
+		}
+		if c.retryCleanup {
+			if _, err := c.dockerClient.ImageRemove(ctx, a.image.imageID, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
+				leaked.retryErr = err
+			} else {
+				log.Printf("Removed leaked image '%s' (%s) on retry", a.image.imageRef, a.image.imageID)
+				c.removedImages++
+				c.reclaimedBytes += a.image.size
+				continue
+			}
+		}
+		log.Printf("WARNING: image '%s' (%s) was pulled during the scan but is still present locally: %s", leaked.imageRef, leaked.imageID, leaked.reason)
+		c.leakedImages = append(c.leakedImages, leaked)
+	}
+	sort.Slice(c.leakedImages, func(i, j int) bool { return c.leakedImages[i].imageRef < c.leakedImages[j].imageRef })
+
+	if len(c.leakedImages) > 0 {
+		log.Printf("WARNING: %d images pulled during the scan could not be removed", len(c.leakedImages))
+	} else if len(attempts) > 0 {
+		log.Printf("Verified all %d images pulled during the scan have been removed", len(attempts))
+	}
+}
+
+// jsonLeakedImages returns the images which could not be removed for the JSON results
+func (c *Config) jsonLeakedImages() []jsonLeakedImage {
+	images := make([]jsonLeakedImage, 0, len(c.leakedImages))
+	for _, l := range c.leakedImages {
+		image := jsonLeakedImage{ImageRef: l.imageRef, ImageID: l.imageID, Reason: l.reason}
+		if l.retryErr != nil {
+			image.RetryError = l.retryErr.Error()
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// outputLeakedImages writes to a file all the images pulled during the scan which are still present locally, and why
+func (c *Config) outputLeakedImages() error {
+	if len(c.leakedImages) == 0 {
+		return nil
+	}
+
+	leakedResultsPath := c.resultsPath("leaked-images", "txt")
+	f, err := c.openResultsFile(leakedResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", leakedResultsPath, err)
+		}
+	}(f)
+
+	for _, l := range c.leakedImages {
+		line := fmt.Sprintf("%s\t%s\t(reason: %s)", l.imageRef, l.imageID, l.reason)
+		if l.retryErr != nil {
+			line += fmt.Sprintf(" (retry error: %s)", l.retryErr)
+		}
+		_, err = f.WriteString(line + "\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", leakedResultsPath, err)
+		}
+	}
+	log.Printf("%d images could not be removed. Results written to: %s", len(c.leakedImages), leakedResultsPath)
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	dockerClient "github.com/docker/docker/client"
+)
+
+// HistoryClient is the subset of the Docker client used to read the history of a local image
+// It can be replaced with a fake in unit tests
+type HistoryClient interface {
+	ImageHistory(ctx context.Context, imageID string) ([]image.HistoryResponseItem, error)
+}
+
+// ImageMatchesKeywords checks the history of a single image in the local Docker instance for keywords, using the Docker
+// config from the environment (DOCKER_HOST etc.). K8s and AWS are not used, so it can be called from a Go test to enforce image policy
+// The image must already be present locally, e.g. it has just been built. See ImageMatchesKeywordsWithClient for the returned matches
+func ImageMatchesKeywords(ctx context.Context, imageRef string, keywords []string) (map[string]int, error) {
+	dockerCli, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("creating Docker client: %w", err)
+	}
+	defer func() {
+		if err := dockerCli.Close(); err != nil {
+			log.Printf("closing Docker client: %s", err)
+		}
+	}()
+
+	return ImageMatchesKeywordsWithClient(ctx, dockerCli, imageRef, keywords)
+}
+
+// ImageMatchesKeywordsWithClient checks the history of a single image for keywords using the client
+// Returns the number of history entries each keyword matched, case-insensitively. Keywords which did not match are not included
+// Negated keywords ('!keyword') are included with a count of 0 when they are absent from the entire history, as their absence is the finding
+func ImageMatchesKeywordsWithClient(ctx context.Context, client HistoryClient, imageRef string, keywords []string) (map[string]int, error) {
+	entries, err := localImageHistory(ctx, client, imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{dockerImageKeyWords: keywords, layerCache: make(map[string][]layerMatch), findings: io.Discard}
+	result, err := c.matchHistoryForKeyWords(imageRef, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[string]int, len(result.matchedKeywords)+len(result.absentKeywords))
+	for keyword, count := range result.matchedKeywords {
+		matches[keyword] = count
+	}
+	for _, keyword := range result.absentKeywords {
+		matches[keyword] = 0
+	}
+	return matches, nil
+}
+
+// localImageHistory reads the history of an image from the local Docker instance
+func localImageHistory(ctx context.Context, client HistoryClient, imageRef string) ([]historyEntry, error) {
+	history, err := client.ImageHistory(ctx, imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("querying image history for '%s': %w", imageRef, err)
+	}
+
+	// The history is listed newest first, so the base layer is the last entry
+	entries := make([]historyEntry, 0, len(history))
+	for i, h := range history {
+		entry := historyEntry{index: len(history) - 1 - i, createdBy: h.CreatedBy}
+		if h.Created > 0 {
+			entry.created = time.Unix(h.Created, 0)
+		}
+		// Layers which were not built locally are reported as '<missing>'
+		if strings.HasPrefix(h.ID, "sha256:") {
+			entry.layerDigest = h.ID
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
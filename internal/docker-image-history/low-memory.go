@@ -0,0 +1,85 @@
+package docker_image_history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// openOffendingStream opens the JSON lines file which offending images are written to as they are found in low memory mode
+func (c *Config) openOffendingStream() error {
+	c.offendingStreamPath = c.resultsPath("offending-images", "jsonl")
+
+	f, err := os.OpenFile(c.offendingStreamPath, resultsFileFlags(c.outputAppend), 0644)
+	if err != nil {
+		return fmt.Errorf("opening file '%s': %w", c.offendingStreamPath, err)
+	}
+	c.offendingStream = &recordFile{File: f}
+	log.Printf("Low memory mode: offending images will be written to '%s' as they are found", c.offendingStreamPath)
+
+	return nil
+}
+
+// streamResult writes an offending image to the stream straight away rather than keeping it in memory until the end of the scan
+// The pod details of the image are then released, unless a report written at the end of the scan needs them. Must be called whilst
+// holding c.mu
+func (c *Config) streamResult(result offendingDockerImage) {
+	defer func() {
+		if !c.reportsPodDetails(result.imageRef) {
+			c.dockerImages[result.imageRef] = nil
+		}
+	}()
+
+	if !result.matchFound {
+		return
+	}
+
+	image := c.jsonImage(result.imageRef)
+	image.MatchedKeywords = result.matchedKeywords
+	image.AbsentKeywords = result.absentKeywords
+	image.MatchedMetadata = result.matchedMetadata
+	image.MatchContexts = result.matchContexts
+	image.MatchedInstructions = result.matchedInstructions
+	image.MatchedLayers = result.matchedLayers
+	image.MatchedRules = result.matchedRules
+	image.DecodedKeywords = result.decodedKeywords
+
+	line, err := json.Marshal(image)
+	if err == nil {
+		err = c.offendingStream.writeRecord(line)
+	}
+	if err != nil {
+		log.Printf("problem writing offending image '%s' to '%s': %s", result.imageRef, c.offendingStreamPath, err)
+		return
+	}
+	c.streamedOffendingImages++
+}
+
+// reportsPodDetails returns whether an image is in one of the reports written at the end of the scan which list the pods running it:
+// the oversized, runs as root and missing from registry images. Must be called whilst holding c.mu
+func (c *Config) reportsPodDetails(imageRef string) bool {
+	for _, o := range c.oversizedImages {
+		if o.imageRef == imageRef {
+			return true
+		}
+	}
+	for _, r := range c.rootImages {
+		if r.imageRef == imageRef {
+			return true
+		}
+	}
+	for _, m := range c.missingImages {
+		if m.imageRef == imageRef {
+			return true
+		}
+	}
+	return false
+}
+
+// closeOffendingStream closes the offending images stream
+func (c *Config) closeOffendingStream() {
+	if err := c.offendingStream.Close(); err != nil {
+		log.Printf("problem closing file '%s': %s", c.offendingStreamPath, err)
+	}
+}
@@ -0,0 +1,44 @@
+package docker_image_history
+
+import "testing"
+
+func TestStreamResultKeepsPodDetailsForReports(t *testing.T) {
+	const (
+		clean     = "registry.example.com/clean:1.0"
+		offending = "registry.example.com/offending:1.0"
+		oversized = "registry.example.com/oversized:1.0"
+		root      = "registry.example.com/root:1.0"
+	)
+	c := newTestConfig(nil, "curl")
+	c.lowMemory = true
+	c.outputDir = t.TempDir()
+	c.timestampFormat = DefaultTimestampFormat
+	c.maxImageSizeBytes = 1024
+	for _, image := range []string{clean, offending, oversized, root} {
+		c.dockerImages[image] = []podDetails{{podName: "pod", namespace: "default"}}
+	}
+	if err := c.openOffendingStream(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.closeOffendingStream()
+
+	c.checkImageSize(oversized, 2048)
+	c.checkImageUser(root, "root")
+	c.recordResult(offendingDockerImage{imageRef: clean})
+	c.recordResult(offendingDockerImage{imageRef: offending, matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+	c.recordResult(offendingDockerImage{imageRef: oversized})
+	c.recordResult(offendingDockerImage{imageRef: root})
+
+	for _, image := range []string{clean, offending} {
+		if len(c.dockerImages[image]) > 0 {
+			t.Errorf("expected the pod details of '%s' to be released once it was scanned", image)
+		}
+	}
+	report := c.buildJSONReport()
+	if len(report.OversizedImages) != 1 || len(report.OversizedImages[0].Pods) != 1 {
+		t.Errorf("expected the oversized image to keep its pods, got %+v", report.OversizedImages)
+	}
+	if len(report.RunsAsRoot) != 1 || len(report.RunsAsRoot[0].Pods) != 1 {
+		t.Errorf("expected the image running as root to keep its pods, got %+v", report.RunsAsRoot)
+	}
+}
@@ -0,0 +1,87 @@
+package docker_image_history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// matchSettings are the settings which change the results of matching an image, recorded alongside results which are reused by
+// later runs (the baseline and checkpoint files). Results generated with different settings are stale, so they are not reused
+// The keyword rules and policies are recorded by the digest of their file contents
+type matchSettings struct {
+	Keywords        []string `json:"keywords"`
+	CaseSensitive   bool     `json:"caseSensitive,omitempty"`
+	RegexKeywords   bool     `json:"regexKeywords,omitempty"`
+	CountMode       string   `json:"countMode,omitempty"`
+	DecodeBase64    bool     `json:"decodeBase64,omitempty"`
+	RunOnly         bool     `json:"runOnly,omitempty"`
+	ContextChars    int      `json:"contextChars,omitempty"`
+	Redact          bool     `json:"redact,omitempty"`
+	KeywordRules    string   `json:"keywordRules,omitempty"`
+	KeywordPolicies string   `json:"keywordPolicies,omitempty"`
+}
+
+// currentMatchSettings returns the match settings of this run
+func (c *Config) currentMatchSettings() (matchSettings, error) {
+	s := matchSettings{
+		Keywords:      c.dockerImageKeyWords,
+		CaseSensitive: c.caseSensitive,
+		RegexKeywords: c.regexKeywords,
+		CountMode:     c.countMode,
+		DecodeBase64:  c.decodeBase64,
+		RunOnly:       c.runOnly,
+		ContextChars:  c.contextChars,
+		Redact:        c.redact,
+	}
+	// Files written before the count mode was recorded were always counted by layer
+	if s.CountMode == CountModeLayers {
+		s.CountMode = ""
+	}
+
+	var err error
+	if s.KeywordRules, err = fileDigest(c.keywordRulesPath); err != nil {
+		return matchSettings{}, fmt.Errorf("reading keyword rules: %w", err)
+	}
+	if s.KeywordPolicies, err = fileDigest(c.keywordPoliciesPath); err != nil {
+		return matchSettings{}, fmt.Errorf("reading keyword policies: %w", err)
+	}
+	return s, nil
+}
+
+// differences returns a description of each setting which differs from the other settings, e.g. 'caseSensitive (true, not false)'
+// Returns nil if they are the same
+func (s matchSettings) differences(other matchSettings) []string {
+	var diffs []string
+	compare := func(name string, value, otherValue interface{}) {
+		// Compared as formatted, so a missing list is the same as an empty one
+		if fmt.Sprint(value) != fmt.Sprint(otherValue) {
+			diffs = append(diffs, fmt.Sprintf("%s (%v, not %v)", name, value, otherValue))
+		}
+	}
+	compare("keywords", s.Keywords, other.Keywords)
+	compare("caseSensitive", s.CaseSensitive, other.CaseSensitive)
+	compare("regexKeywords", s.RegexKeywords, other.RegexKeywords)
+	compare("countMode", s.CountMode, other.CountMode)
+	compare("decodeBase64", s.DecodeBase64, other.DecodeBase64)
+	compare("runOnly", s.RunOnly, other.RunOnly)
+	compare("contextChars", s.ContextChars, other.ContextChars)
+	compare("redact", s.Redact, other.Redact)
+	compare("keywordRules", s.KeywordRules, other.KeywordRules)
+	compare("keywordPolicies", s.KeywordPolicies, other.KeywordPolicies)
+	return diffs
+}
+
+// fileDigest returns the 'sha256:<hex>' digest of a file's contents, or an empty string if no path is set
+func fileDigest(path string) (string, error) {
+	if len(path) == 0 {
+		return "", nil
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading '%s': %w", path, err)
+	}
+	sum := sha256.Sum256(contents)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,339 @@
+package docker_image_history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// matchHistoryForKeyWords checks the history entries of a single image with its matchers: the image's keywords, then any custom matchers
+// Keywords prefixed with '!' are negated, and flag the image if they are absent from the entire history
+// Returns an error if a custom matcher fails
+func (c *Config) matchHistoryForKeyWords(imageRef string, history []historyEntry) (offendingDockerImage, error) {
+	var result offendingDockerImage
+	result.imageRef = imageRef
+	result.matchedKeywords = make(map[string]int)
+	keywords := c.keywordsForImage(imageRef)
+	matchers := c.imageMatchers(keywords)
+
+	presentNegatedKeywords := make(map[string]bool)
+	plainKeywords := make(map[string]bool)
+	for _, h := range history {
+		instruction := instructionType(h.createdBy)
+		if c.runOnly && instruction != instructionRun {
+			continue
+		}
+		if len(instruction) == 0 {
+			instruction = "UNKNOWN"
+		}
+
+		matches, err := matchLayerWithMatchers(matchers, h)
+		if err != nil {
+			return result, err
+		}
+		for _, match := range matches {
+			if match.keyword == nil {
+				c.recordCustomMatch(&result, match, h, instruction)
+				continue
+			}
+			m := match.keyword
+			if m.negated {
+				presentNegatedKeywords[m.keyword] = true
+				continue
+			}
+			result.matchFound = true
+			result.imageRef = imageRef
+			text := h.createdBy
+			if m.decoded {
+				text = decodeBase64Tokens(h.createdBy)
+				result.decodedKeywords = appendUnique(result.decodedKeywords, m.keyword)
+			} else {
+				plainKeywords[m.keyword] = true
+			}
+			if c.countMode == CountModeOccurrences {
+				result.matchedKeywords[m.keyword] += m.occurrences
+			} else {
+				result.matchedKeywords[m.keyword]++
+			}
+			if result.matchedInstructions == nil {
+				result.matchedInstructions = make(map[string][]string)
+			}
+			result.matchedInstructions[m.keyword] = appendUnique(result.matchedInstructions[m.keyword], instruction)
+			c.recordLayerMatch(&result, m.keyword, h, m)
+			if c.contextChars > 0 {
+				if result.matchContexts == nil {
+					result.matchContexts = make(map[string][]string)
+				}
+				if c.redact {
+					result.matchContexts[m.keyword] = append(result.matchContexts[m.keyword], redactedMatchContext(text, m.start, m.end, c.contextChars))
+				} else {
+					result.matchContexts[m.keyword] = append(result.matchContexts[m.keyword], matchContext(text, m.start, m.end, c.contextChars))
+				}
+			}
+			if m.decoded {
+				_, _ = fmt.Fprintf(c.findings, "FOUND (base64 decoded): %+v\n", result)
+			} else if c.countMode == CountModeOccurrences {
+				_, _ = fmt.Fprintf(c.findings, "FOUND (occurrences): %+v\n", result)
+			} else {
+				_, _ = fmt.Fprintf(c.findings, "FOUND: %+v\n", result)
+			}
+		}
+	}
+
+	// Keywords are only reported as decoded if they were not also found without decoding
+	var decodedOnly []string
+	for _, keyword := range result.decodedKeywords {
+		if !plainKeywords[keyword] {
+			decodedOnly = append(decodedOnly, keyword)
+		}
+	}
+	result.decodedKeywords = decodedOnly
+
+	for _, keyword := range keywords {
+		if _, negated := parseNegatedKeyword(keyword); negated && !presentNegatedKeywords[keyword] {
+			result.matchFound = true
+			result.imageRef = imageRef
+			result.absentKeywords = append(result.absentKeywords, keyword)
+			_, _ = fmt.Fprintf(c.findings, "FOUND (absent keyword %s): %+v\n", keyword, result)
+		}
+	}
+
+	if len(c.keywordRules.Rules) > 0 {
+		if rules := c.matchKeywordRules(history); len(rules) > 0 {
+			result.matchFound = true
+			result.imageRef = imageRef
+			result.matchedRules = rules
+			_, _ = fmt.Fprintf(c.findings, "FOUND (rules %v): %+v\n", rules, result)
+		}
+	}
+	return result, nil
+}
+
+// recordCustomMatch records a match by a custom matcher, which is counted once per layer and reported with the context it returned
+func (c *Config) recordCustomMatch(result *offendingDockerImage, m Match, h historyEntry, instruction string) {
+	result.matchFound = true
+	result.matchedKeywords[m.Keyword]++
+	if result.matchedInstructions == nil {
+		result.matchedInstructions = make(map[string][]string)
+	}
+	result.matchedInstructions[m.Keyword] = appendUnique(result.matchedInstructions[m.Keyword], instruction)
+	c.recordLayerMatch(result, m.Keyword, h, nil)
+	if len(m.Context) > 0 {
+		if result.matchContexts == nil {
+			result.matchContexts = make(map[string][]string)
+		}
+		result.matchContexts[m.Keyword] = append(result.matchContexts[m.Keyword], m.Context)
+	}
+	_, _ = fmt.Fprintf(c.findings, "FOUND (custom matcher): %+v\n", *result)
+}
+
+// matchLayer returns the keywords found in a single history layer
+// Unless the cache is disabled (low memory mode), results are cached per layer and keyword set, so a base layer shared by many images is only matched once per run
+func (c *Config) matchLayer(h historyEntry, keywords []string) []layerMatch {
+	if c.layerCache == nil {
+		return c.matchKeywords(h, keywords)
+	}
+	cacheKey := h.layerKey() + "|" + strings.Join(keywords, "\x00")
+
+	c.layerCacheMu.Lock()
+	matches, found := c.layerCache[cacheKey]
+	if found {
+		c.layerCacheHits++
+	}
+	c.layerCacheMu.Unlock()
+	if found {
+		return matches
+	}
+
+	matches = c.matchKeywords(h, keywords)
+
+	c.layerCacheMu.Lock()
+	c.layerCache[cacheKey] = matches
+	c.layerCacheMisses++
+	c.layerCacheMu.Unlock()
+
+	return matches
+}
+
+// matchKeywords returns the keywords found in a single history layer, without caching
+// If decodeBase64 is set, keywords which are not found in the instruction are also searched for in its base64 decoded tokens
+// Keywords targeted at a field only match the layers of that field, e.g. 'run:curl' only matches RUN instructions
+func (c *Config) matchKeywords(h historyEntry, keywords []string) []layerMatch {
+	var decoded string
+	if c.decodeBase64 {
+		decoded = decodeBase64Tokens(h.createdBy)
+	}
+	instruction := instructionType(h.createdBy)
+
+	matches := make([]layerMatch, 0)
+	for _, keyword := range keywords {
+		term, negated := parseNegatedKeyword(keyword)
+		term, applies := historyFieldMatches(term, instruction)
+		if !applies {
+			continue
+		}
+		start, end, found := c.indexKeyword(h.createdBy, term)
+		if found {
+			matches = append(matches, layerMatch{keyword: keyword, negated: negated, start: start, end: end, occurrences: c.countKeyword(h.createdBy, term)})
+			continue
+		}
+		if len(decoded) == 0 {
+			continue
+		}
+		start, end, found = c.indexKeyword(decoded, term)
+		if found {
+			matches = append(matches, layerMatch{keyword: keyword, negated: negated, start: start, end: end, occurrences: c.countKeyword(decoded, term), decoded: true})
+		}
+	}
+	return matches
+}
+
+// layerKey identifies a history layer for caching. Identical layer contents can be produced by different instructions (e.g. the same
+// COPY in two Dockerfiles), so the instruction is hashed alongside the layer digest as the match results depend on it
+func (h historyEntry) layerKey() string {
+	sum := sha256.Sum256([]byte(h.createdBy))
+	return h.layerDigest + "/" + hex.EncodeToString(sum[:])
+}
+
+// logLayerCacheStats logs how many layer matches were served from the cache
+func (c *Config) logLayerCacheStats() {
+	if c.layerCache == nil {
+		return
+	}
+	c.layerCacheMu.Lock()
+	defer c.layerCacheMu.Unlock()
+	log.Printf("Layer match cache: %d unique layers matched, %d shared layers reused from the cache", c.layerCacheMisses, c.layerCacheHits)
+}
+
+// containsKeyword returns whether the text contains the keyword. Matching is case-insensitive unless caseSensitive is set
+func (c *Config) containsKeyword(text, keyword string) bool {
+	_, _, found := c.indexKeyword(text, keyword)
+	return found
+}
+
+// indexKeyword returns the start and end offsets of the first occurrence of the keyword in the text, and whether it was found
+// Matching is case-insensitive unless caseSensitive is set. With regexKeywords the keyword is matched as a regular expression
+func (c *Config) indexKeyword(text, keyword string) (int, int, bool) {
+	if re, ok := c.keywordRegexp(keyword); ok {
+		loc := re.FindStringIndex(text)
+		if loc == nil {
+			return 0, 0, false
+		}
+		return loc[0], loc[1], true
+	}
+	if c.caseSensitive {
+		i := strings.Index(text, keyword)
+		if i < 0 {
+			return 0, 0, false
+		}
+		return i, i + len(keyword), true
+	}
+	return indexFold(text, keyword)
+}
+
+// indexFold returns the start and end offsets in the text of the first case-insensitive occurrence of the keyword, and whether it was found
+// The offsets are in the original text, as lower casing can change the byte length of non-ASCII characters (e.g. 'Ⱥ' to 'ⱥ')
+func indexFold(text, keyword string) (int, int, bool) {
+	if isASCII(text) && isASCII(keyword) {
+		i := strings.Index(strings.ToLower(text), strings.ToLower(keyword))
+		if i < 0 {
+			return 0, 0, false
+		}
+		return i, i + len(keyword), true
+	}
+
+	for start := 0; start <= len(text); {
+		if n, ok := hasPrefixFold(text[start:], keyword); ok {
+			return start, start + n, true
+		}
+		if start == len(text) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		start += size
+	}
+	return 0, 0, false
+}
+
+// hasPrefixFold returns whether the text starts with the prefix under Unicode case folding, and the length in bytes of the matching text
+func hasPrefixFold(text, prefix string) (int, bool) {
+	n := 0
+	for _, p := range prefix {
+		if n >= len(text) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if r != p && !strings.EqualFold(string(r), string(p)) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+// isASCII returns whether the text only contains ASCII characters
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// countKeyword returns the number of non-overlapping occurrences of the keyword in the text
+// Matching is case-insensitive unless caseSensitive is set. With regexKeywords the keyword is matched as a regular expression
+func (c *Config) countKeyword(text, keyword string) int {
+	if re, ok := c.keywordRegexp(keyword); ok {
+		return len(re.FindAllStringIndex(text, -1))
+	}
+	if c.caseSensitive {
+		return strings.Count(text, keyword)
+	}
+	if isASCII(text) && isASCII(keyword) {
+		return strings.Count(strings.ToLower(text), strings.ToLower(keyword))
+	}
+	if len(keyword) == 0 {
+		return utf8.RuneCountInString(text) + 1
+	}
+	count := 0
+	for {
+		_, end, found := indexFold(text, keyword)
+		if !found {
+			return count
+		}
+		count++
+		text = text[end:]
+	}
+}
+
+// matchContext returns the match between start and end, along with up to n characters of the surrounding text either side
+// Ellipses mark where the text has been truncated. The context is widened to whole characters, so a multibyte character is never split
+func matchContext(text string, start, end, n int) string {
+	from, to := start-n, end+n
+	prefix, suffix := "...", "..."
+	if from <= 0 {
+		from, prefix = 0, ""
+	}
+	if to >= len(text) {
+		to, suffix = len(text), ""
+	}
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+	return prefix + text[from:to] + suffix
+}
+
+// parseNegatedKeyword strips the '!' prefix from a negated keyword. Returns the term to search for and whether it was negated
+func parseNegatedKeyword(keyword string) (string, bool) {
+	if strings.HasPrefix(keyword, "!") {
+		return strings.TrimPrefix(keyword, "!"), true
+	}
+	return keyword, false
+}
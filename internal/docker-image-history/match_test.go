@@ -0,0 +1,139 @@
+package docker_image_history
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMatchHistoryForKeyWordsCaseSensitive(t *testing.T) {
+	history := []historyEntry{
+		{createdBy: "/bin/sh -c #(nop)  ENV AWS_SECRET=redacted"},
+		{createdBy: "/bin/sh -c echo aws_secret_access_key >> /root/.aws/credentials"},
+	}
+	tests := []struct {
+		name          string
+		caseSensitive bool
+		keyword       string
+		wantCount     int
+	}{
+		{name: "case-insensitive matches every casing", keyword: "AWS_SECRET", wantCount: 2},
+		{name: "case-sensitive upper case keyword", caseSensitive: true, keyword: "AWS_SECRET", wantCount: 1},
+		{name: "case-sensitive lower case keyword", caseSensitive: true, keyword: "aws_secret", wantCount: 1},
+		{name: "case-sensitive keyword in neither casing", caseSensitive: true, keyword: "Aws_Secret", wantCount: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(nil, tt.keyword)
+			c.caseSensitive = tt.caseSensitive
+
+			result, err := c.matchHistoryForKeyWords("app:1.0", history)
+			if err != nil {
+				t.Fatalf("matching history: %s", err)
+			}
+			if result.matchFound != (tt.wantCount > 0) {
+				t.Errorf("expected matchFound to be %t, got %t", tt.wantCount > 0, result.matchFound)
+			}
+			if got := result.matchedKeywords[tt.keyword]; got != tt.wantCount {
+				t.Errorf("expected '%s' to match %d layers, got %d", tt.keyword, tt.wantCount, got)
+			}
+		})
+	}
+}
+
+// versionMatcher is a custom matcher which reports layers installing Java 8
+type versionMatcher struct{}
+
+func (versionMatcher) Match(layer HistoryEntry) ([]Match, error) {
+	if strings.Contains(layer.CreatedBy, "openjdk-8") {
+		return []Match{{Keyword: "java<11", Context: "openjdk-8"}}, nil
+	}
+	return nil, nil
+}
+
+// failingMatcher is a custom matcher which fails on every layer
+type failingMatcher struct{}
+
+func (failingMatcher) Match(HistoryEntry) ([]Match, error) {
+	return nil, errors.New("matcher failed")
+}
+
+func TestMatchHistoryForKeyWordsWithMatchers(t *testing.T) {
+	history := []historyEntry{
+		{createdBy: "/bin/sh -c apt-get install -y curl openjdk-8-jre && curl -O https://example.com/a && curl -O https://example.com/b", layerDigest: "sha256:a"},
+		{createdBy: "/bin/sh -c #(nop)  CMD [\"java\", \"-jar\", \"app.jar\"]", layerDigest: "sha256:b"},
+	}
+	c := newTestConfig(nil, "curl", "!useradd")
+	c.countMode = CountModeOccurrences
+	c.contextChars = 4
+	c.layerCache = make(map[string][]layerMatch)
+	c.matchers = []Matcher{versionMatcher{}, NewKeywordMatcher([]string{"jar"}, false)}
+
+	for i := 0; i < 2; i++ {
+		result, err := c.matchHistoryForKeyWords("app:1.0", history)
+		if err != nil {
+			t.Fatalf("matching history: %s", err)
+		}
+		want := map[string]int{"curl": 3, "java<11": 1, "jar": 1}
+		if fmt.Sprint(result.matchedKeywords) != fmt.Sprint(want) {
+			t.Errorf("expected matches %v, got %v", want, result.matchedKeywords)
+		}
+		if fmt.Sprint(result.absentKeywords) != "[!useradd]" {
+			t.Errorf("expected '!useradd' to be reported absent, got %v", result.absentKeywords)
+		}
+		if got := result.matchContexts["curl"]; len(got) != 1 || got[0] != "... -y curl ope..." {
+			t.Errorf("expected the keyword context '... -y curl ope...', got %q", got)
+		}
+		if got := result.matchContexts["java<11"]; len(got) != 1 || got[0] != "openjdk-8" {
+			t.Errorf("expected the custom matcher context 'openjdk-8', got %q", got)
+		}
+	}
+	if c.layerCacheHits != 2 {
+		t.Errorf("expected the second scan to reuse the keyword matches of both layers from the cache, got %d hits", c.layerCacheHits)
+	}
+}
+
+func TestMatchHistoryForKeyWordsMatcherError(t *testing.T) {
+	c := newTestConfig(nil, "curl")
+	c.matchers = []Matcher{failingMatcher{}}
+
+	_, err := c.matchHistoryForKeyWords("app:1.0", []historyEntry{{createdBy: "/bin/sh -c curl -O https://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "matcher failed") {
+		t.Fatalf("expected the custom matcher's error, got %v", err)
+	}
+}
+
+func TestMatchHistoryForKeyWordsNonASCII(t *testing.T) {
+	history := []historyEntry{{createdBy: "/bin/sh -c echo ȺȺȺ && CURL -O https://example.com/ȺȺ && echo ⱥⱥ"}}
+	tests := []struct {
+		name          string
+		keyword       string
+		caseSensitive bool
+		wantCount     int
+		wantContext   string
+	}{
+		{name: "ASCII keyword after characters which change length when lower cased", keyword: "curl", wantCount: 1, wantContext: "...Ⱥ && CURL -O h..."},
+		{name: "non-ASCII keyword matches every casing", keyword: "ⱥⱥ", wantCount: 3, wantContext: "...echo ȺȺȺ &&..."},
+		{name: "case-sensitive non-ASCII keyword", keyword: "ⱥⱥ", caseSensitive: true, wantCount: 1, wantContext: "...echo ⱥⱥ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(nil, tt.keyword)
+			c.caseSensitive = tt.caseSensitive
+			c.countMode = CountModeOccurrences
+			c.contextChars = 5
+
+			result, err := c.matchHistoryForKeyWords("app:1.0", history)
+			if err != nil {
+				t.Fatalf("matching history: %s", err)
+			}
+			if got := result.matchedKeywords[tt.keyword]; got != tt.wantCount {
+				t.Errorf("expected '%s' to occur %d times, got %d", tt.keyword, tt.wantCount, got)
+			}
+			if got := result.matchContexts[tt.keyword]; len(got) != 1 || got[0] != tt.wantContext {
+				t.Errorf("expected the context '%s', got %q", tt.wantContext, got)
+			}
+		})
+	}
+}
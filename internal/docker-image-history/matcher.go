@@ -0,0 +1,103 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// HistoryEntry is a single layer of an image's history, as passed to a Matcher
+// LayerDigest is only set when the source reports the digest of the layer
+type HistoryEntry struct {
+	CreatedBy   string
+	LayerDigest string
+}
+
+// Match is a finding in a single history layer. Keyword is the name it is reported under in the results, alongside the keyword matches
+// Context is optional, and is reported as the match context when set
+type Match struct {
+	Keyword string
+	Context string
+
+	// keyword is the full detail of a match by the scan's own keyword matcher, which is reported with its negation, decoding,
+	// occurrences and offsets. It is nil for the matches of custom matchers
+	keyword *layerMatch
+}
+
+// Matcher finds matches in each history layer of an image, extending the matching beyond keywords (e.g. version comparisons)
+// It is called for each layer of the image being checked, and must be safe for concurrent use. A returned error fails the check
+type Matcher interface {
+	Match(layer HistoryEntry) ([]Match, error)
+}
+
+// keywordMatcher is the built-in keyword matching as a Matcher
+type keywordMatcher struct {
+	c        *Config
+	keywords []string
+	// scan is set for the keyword matcher of a scan, which uses the layer cache and returns the full detail of each match, including
+	// the negated keywords present in the layer so their absence can be judged across the whole history
+	scan bool
+}
+
+// NewKeywordMatcher returns a Matcher which matches keywords as substrings of each history layer, case-insensitively unless caseSensitive
+// is set. Negated keywords ('!keyword') are skipped, as their absence can only be judged across the whole history
+func NewKeywordMatcher(keywords []string, caseSensitive bool) Matcher {
+	return &keywordMatcher{c: &Config{caseSensitive: caseSensitive, findings: io.Discard}, keywords: keywords}
+}
+
+// Match returns the keywords found in the layer
+func (m *keywordMatcher) Match(layer HistoryEntry) ([]Match, error) {
+	h := historyEntry{createdBy: layer.CreatedBy, layerDigest: layer.LayerDigest}
+	if m.scan {
+		layerMatches := m.c.matchLayer(h, m.keywords)
+		matches := make([]Match, 0, len(layerMatches))
+		for i := range layerMatches {
+			matches = append(matches, Match{Keyword: layerMatches[i].keyword, keyword: &layerMatches[i]})
+		}
+		return matches, nil
+	}
+
+	var matches []Match
+	for _, lm := range m.c.matchKeywords(h, m.keywords) {
+		if !lm.negated {
+			matches = append(matches, Match{Keyword: lm.keyword})
+		}
+	}
+	return matches, nil
+}
+
+// imageMatchers returns the matchers an image is scanned with: the keyword matcher for the image's keywords, followed by the custom matchers
+func (c *Config) imageMatchers(keywords []string) []Matcher {
+	matchers := make([]Matcher, 0, len(c.matchers)+1)
+	matchers = append(matchers, &keywordMatcher{c: c, keywords: keywords, scan: true})
+	return append(matchers, c.matchers...)
+}
+
+// matchLayerWithMatchers runs the matchers against a single history layer
+func matchLayerWithMatchers(matchers []Matcher, h historyEntry) ([]Match, error) {
+	var matches []Match
+	for _, m := range matchers {
+		layerMatches, err := m.Match(HistoryEntry{CreatedBy: h.createdBy, LayerDigest: h.layerDigest})
+		if err != nil {
+			return nil, fmt.Errorf("running custom matcher %T: %w", m, err)
+		}
+		matches = append(matches, layerMatches...)
+	}
+	return matches, nil
+}
+
+// ImageMatchesWithClient checks the history of a single image with the matchers, using the client
+// Returns the number of history entries each match keyword was found in
+func ImageMatchesWithClient(ctx context.Context, client HistoryClient, imageRef string, matchers []Matcher) (map[string]int, error) {
+	entries, err := localImageHistory(ctx, client, imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{matchers: matchers, findings: io.Discard}
+	result, err := c.matchHistoryForKeyWords(imageRef, entries)
+	if err != nil {
+		return nil, err
+	}
+	return result.matchedKeywords, nil
+}
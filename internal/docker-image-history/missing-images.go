@@ -0,0 +1,102 @@
+package docker_image_history
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// imageNotFoundError is returned when an image's tag or digest no longer exists in its registry
+// Pods can keep running such images from the copy cached on their node, but the deployment can no longer be reproduced
+type imageNotFoundError struct {
+	imageRef string
+	err      error
+}
+
+func (e *imageNotFoundError) Error() string {
+	return fmt.Sprintf("image '%s' not found in registry: %s", e.imageRef, e.err)
+}
+
+func (e *imageNotFoundError) Unwrap() error {
+	return e.err
+}
+
+// missingImage stores an image running in the cluster which could not be found in its registry
+type missingImage struct {
+	imageRef string
+	err      error
+}
+
+// isImageNotFound returns whether a pull or registry error means the image does not exist in the registry
+// Authentication failures are not classified as missing, even where the registry hides the repository behind them
+func isImageNotFound(err error) bool {
+	if errdefs.IsNotFound(err) {
+		return true
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		if transportErr.StatusCode == http.StatusNotFound {
+			return true
+		}
+		for _, diagnostic := range transportErr.Errors {
+			if diagnostic.Code == transport.ManifestUnknownErrorCode || diagnostic.Code == transport.NameUnknownErrorCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Errors reported part way through a Docker pull are only available as a message
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "manifest unknown") || strings.Contains(message, "name unknown")
+}
+
+// recordMissingImage stores an image which could not be found in its registry. Safe for concurrent use
+func (c *Config) recordMissingImage(imageRef string, err error) {
+	log.Printf("image '%s' is running in the cluster but no longer exists in its registry: %s", imageRef, err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.missingImages = append(c.missingImages, missingImage{imageRef: imageRef, err: err})
+	c.progress.processedImages++
+}
+
+// outputMissingImages writes to a file all the images which are running in the cluster but can no longer be pulled from their registry
+func (c *Config) outputMissingImages() error {
+	if len(c.missingImages) == 0 {
+		return nil
+	}
+
+	missingImagesResultsPath := c.resultsPath("missing-from-registry", "txt")
+	f, err := c.openResultsFile(missingImagesResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", missingImagesResultsPath, err)
+		}
+	}(f)
+
+	for _, m := range c.missingImages {
+		_, err = f.WriteString(fmt.Sprintf("%s\t(error: %s) ", m.imageRef, m.err))
+		for _, match := range c.formatPods(c.dockerImages[m.imageRef]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", missingImagesResultsPath, err)
+		}
+	}
+	log.Printf("%d running images are missing from their registry and cannot be redeployed. Details written to: %s", len(c.missingImages), missingImagesResultsPath)
+
+	return nil
+}
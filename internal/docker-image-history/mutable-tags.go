@@ -0,0 +1,76 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Reasons an image ref uses a mutable tag
+const (
+	mutableTagLatest   = "latest"
+	mutableTagUntagged = "untagged"
+)
+
+// mutableTag returns whether an image ref is pinned to the 'latest' tag or has no tag (which implies 'latest'), and which of them it is
+// Refs with a digest are immutable, whatever their tag. Refs which cannot be parsed are not reported
+func mutableTag(imageRef string) (string, bool) {
+	ref, err := name.ParseReference(imageRef, name.WithDefaultTag(""))
+	if err != nil {
+		return "", false
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return "", false
+	}
+
+	switch tag.TagStr() {
+	case "":
+		return mutableTagUntagged, true
+	case "latest":
+		return mutableTagLatest, true
+	}
+	return "", false
+}
+
+// outputMutableTagImages writes to a file all the container images in the cluster which use the 'latest' tag or have no tag
+func (c *Config) outputMutableTagImages() error {
+	if len(c.mutableTagImages) == 0 {
+		return nil
+	}
+
+	mutableTagResultsPath := c.resultsPath("mutable-tags", "txt")
+	f, err := c.openResultsFile(mutableTagResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", mutableTagResultsPath, err)
+		}
+	}(f)
+
+	images := make([]string, 0, len(c.mutableTagImages))
+	for image := range c.mutableTagImages {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	for _, image := range images {
+		_, err = f.WriteString(fmt.Sprintf("%s\t(tag: %s) ", image, c.mutableTagImages[image]))
+		for _, match := range c.formatPods(c.dockerImages[image]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", mutableTagResultsPath, err)
+		}
+	}
+	log.Printf("%d images use the 'latest' tag or no tag. Results written to: %s", len(c.mutableTagImages), mutableTagResultsPath)
+
+	return nil
+}
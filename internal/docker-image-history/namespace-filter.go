@@ -0,0 +1,65 @@
+package docker_image_history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateNamespaceFilters checks at most one way of selecting namespaces is set, as including and excluding namespaces at the
+// same time, or alongside a single namespace, is ambiguous
+func validateNamespaceFilters(opts Options) error {
+	if len(opts.IncludeNamespaces) > 0 && len(opts.ExcludeNamespaces) > 0 {
+		return errors.New("IncludeNamespaces and ExcludeNamespaces cannot both be set")
+	}
+	if len(opts.Namespace) > 0 && (len(opts.IncludeNamespaces) > 0 || len(opts.ExcludeNamespaces) > 0) {
+		return errors.New("Namespace cannot be used with IncludeNamespaces or ExcludeNamespaces")
+	}
+	return nil
+}
+
+// listNamespaces returns the namespaces to list resources in: each of the included namespaces, otherwise the namespace, which is
+// empty to list across all namespaces
+func (c *Config) listNamespaces() []string {
+	if len(c.includeNamespaces) > 0 {
+		return c.includeNamespaces
+	}
+	return []string{c.namespace}
+}
+
+// namespaceExcluded returns whether the namespace is one of the excluded namespaces
+func (c *Config) namespaceExcluded(namespace string) bool {
+	return sliceContains(c.excludeNamespaces, namespace)
+}
+
+// listPods lists the pods to scan: those in the namespace, in each of the included namespaces, or across all namespaces less the
+// excluded ones. Included namespaces are listed one at a time, so only namespaced RBAC permissions are required for each of them
+func (c *Config) listPods(ctx context.Context) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	for _, namespace := range c.listNamespaces() {
+		list, err := c.k8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			if len(namespace) > 0 {
+				return nil, fmt.Errorf("querying for k8s pods in namespace '%s': %w", namespace, err)
+			}
+			return nil, fmt.Errorf("querying for all k8s pods: %w", err)
+		}
+		pods = append(pods, list.Items...)
+	}
+	if len(c.excludeNamespaces) == 0 {
+		return pods, nil
+	}
+
+	included := pods[:0]
+	for _, pod := range pods {
+		if !c.namespaceExcluded(pod.Namespace) {
+			included = append(included, pod)
+		}
+	}
+	log.Printf("Skipped %d pods in the excluded namespaces: %v", len(pods)-len(included), c.excludeNamespaces)
+	return included, nil
+}
@@ -0,0 +1,85 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// namespaceStats summarises the offending images running in a single namespace
+type namespaceStats struct {
+	Namespace       string   `json:"namespace"`
+	OffendingImages int      `json:"offendingImages"`
+	Containers      int      `json:"containers"`
+	Keywords        []string `json:"keywords"`
+}
+
+// buildNamespaceStats aggregates the offending images per namespace, so the namespaces with the most offending images can be remediated first
+// An image running in several namespaces is counted once in each. Namespaces are sorted by their number of offending images, most first
+func (c *Config) buildNamespaceStats() []namespaceStats {
+	byNamespace := make(map[string]*namespaceStats)
+	for _, i := range c.offendingDockerImages {
+		counted := make(map[string]bool)
+		for _, pd := range c.dockerImages[i.imageRef] {
+			stats, ok := byNamespace[pd.namespace]
+			if !ok {
+				stats = &namespaceStats{Namespace: pd.namespace, Keywords: make([]string, 0)}
+				byNamespace[pd.namespace] = stats
+			}
+			stats.Containers++
+			if counted[pd.namespace] {
+				continue
+			}
+			counted[pd.namespace] = true
+			stats.OffendingImages++
+			for keyword := range i.matchedKeywords {
+				stats.Keywords = appendUnique(stats.Keywords, keyword)
+			}
+			stats.Keywords = appendUnique(stats.Keywords, i.absentKeywords...)
+		}
+	}
+
+	stats := make([]namespaceStats, 0, len(byNamespace))
+	for _, s := range byNamespace {
+		sort.Strings(s.Keywords)
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].OffendingImages != stats[j].OffendingImages {
+			return stats[i].OffendingImages > stats[j].OffendingImages
+		}
+		return stats[i].Namespace < stats[j].Namespace
+	})
+	return stats
+}
+
+// outputNamespaceStats writes to a file the number of offending images and the distinct keywords they matched in each namespace
+func (c *Config) outputNamespaceStats() error {
+	stats := c.buildNamespaceStats()
+	if len(stats) == 0 {
+		return nil
+	}
+
+	namespaceStatsResultsPath := c.resultsPath("namespace-stats", "txt")
+	f, err := c.openResultsFile(namespaceStatsResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", namespaceStatsResultsPath, err)
+		}
+	}(f)
+
+	for _, s := range stats {
+		_, err = f.WriteString(fmt.Sprintf("%s\t(offending-images: %d, containers: %d, keywords: %v)\n", s.Namespace, s.OffendingImages, s.Containers, s.Keywords))
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", namespaceStatsResultsPath, err)
+		}
+	}
+	log.Printf("Offending images were found in %d namespaces. Per namespace statistics written to: %s", len(stats), namespaceStatsResultsPath)
+
+	return nil
+}
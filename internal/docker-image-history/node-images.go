@@ -0,0 +1,134 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/go-units"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeImage is an image cached on one or more nodes, as reported by the kubelet in node.status.images
+type nodeImage struct {
+	sizeBytes int64
+	nodes     []string
+}
+
+// queryNodeImages lists the images cached on every node, keyed by each of their normalised names (tags and digests)
+func (c *Config) queryNodeImages(ctx context.Context) error {
+	nodes, err := c.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("querying for all k8s nodes: %w", err)
+	}
+
+	c.nodeImages = make(map[string]*nodeImage)
+	for _, node := range nodes.Items {
+		for _, img := range node.Status.Images {
+			// All the names of an image share a single entry, so the image is only counted once across its tags and digests
+			var entry *nodeImage
+			for _, n := range img.Names {
+				if e, ok := c.nodeImages[normaliseImageRef(n)]; ok {
+					entry = e
+					break
+				}
+			}
+			if entry == nil {
+				entry = &nodeImage{sizeBytes: img.SizeBytes}
+			}
+			entry.nodes = appendUnique(entry.nodes, node.Name)
+			for _, n := range img.Names {
+				c.nodeImages[normaliseImageRef(n)] = entry
+			}
+		}
+	}
+
+	return nil
+}
+
+// nodeImageFor returns the node cache entry of a running image. Nodes may only report an image by digest, so the digests running
+// in its pods are also checked
+func (c *Config) nodeImageFor(imageRef string) (*nodeImage, bool) {
+	if e, ok := c.nodeImages[normaliseImageRef(imageRef)]; ok {
+		return e, true
+	}
+
+	ref, err := parseImageRef(imageRef, false)
+	if err != nil {
+		return nil, false
+	}
+	for _, pd := range c.dockerImages[imageRef] {
+		if len(pd.imageDigest) == 0 {
+			continue
+		}
+		if e, ok := c.nodeImages[ref.Context().Name()+"@"+pd.imageDigest]; ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// partitionNodeCachedImages splits the images into those cached on at least one node and the rest, keeping their order
+func (c *Config) partitionNodeCachedImages(images []string) ([]string, []string) {
+	var cached, uncached []string
+	for _, image := range images {
+		if _, ok := c.nodeImageFor(image); ok {
+			cached = append(cached, image)
+		} else {
+			uncached = append(uncached, image)
+		}
+	}
+	return cached, uncached
+}
+
+// buildNodeSummary totals the node images used by the running pods, and those cached but not used by any of them
+func (c *Config) buildNodeSummary() *nodeSummary {
+	summary := &nodeSummary{}
+	used := make(map[*nodeImage]bool)
+	for image := range c.dockerImages {
+		e, ok := c.nodeImageFor(image)
+		if !ok {
+			summary.UncachedImages++
+			continue
+		}
+		summary.CachedImages++
+		if !used[e] {
+			summary.CachedBytes += e.sizeBytes
+		}
+		used[e] = true
+	}
+
+	counted := make(map[*nodeImage]bool)
+	for _, e := range c.nodeImages {
+		if used[e] || counted[e] {
+			continue
+		}
+		counted[e] = true
+		summary.UnusedCachedImages++
+		summary.UnusedCachedBytes += e.sizeBytes
+	}
+	return summary
+}
+
+// logNodeImages logs how many of the running images are cached on the nodes, and their total size
+func (c *Config) logNodeImages() {
+	cached := 0
+	var size int64
+	for image := range c.dockerImages {
+		if e, ok := c.nodeImageFor(image); ok {
+			cached++
+			size += e.sizeBytes
+		}
+	}
+	log.Printf("%d of %d running images are cached on the nodes, totalling %s", cached, len(c.dockerImages), units.HumanSize(float64(size)))
+}
+
+// normaliseImageRef returns the fully qualified form of an image ref (e.g. 'nginx:1.23' is 'index.docker.io/library/nginx:1.23')
+// so refs from pod specs and node statuses can be compared. Refs which cannot be parsed are returned unchanged
+func normaliseImageRef(imageRef string) string {
+	ref, err := parseImageRef(imageRef, false)
+	if err != nil {
+		return imageRef
+	}
+	return ref.Name()
+}
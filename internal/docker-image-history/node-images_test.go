@@ -0,0 +1,27 @@
+package docker_image_history
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartitionNodeCachedImages(t *testing.T) {
+	c := newTestConfig(nil)
+	cached := &nodeImage{sizeBytes: 1024, nodes: []string{"node-a"}}
+	c.nodeImages = map[string]*nodeImage{
+		normaliseImageRef("app:1.0"): cached,
+		normaliseImageRef("worker@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"): cached,
+	}
+	c.dockerImages["worker:2.0"] = []podDetails{
+		{podName: "worker-0", imageDigest: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+	}
+
+	gotCached, gotUncached := c.partitionNodeCachedImages([]string{"api:3.0", "app:1.0", "db:4.0", "worker:2.0"})
+
+	if want := []string{"app:1.0", "worker:2.0"}; !reflect.DeepEqual(gotCached, want) {
+		t.Errorf("expected cached images %v, got %v", want, gotCached)
+	}
+	if want := []string{"api:3.0", "db:4.0"}; !reflect.DeepEqual(gotUncached, want) {
+		t.Errorf("expected uncached images %v, got %v", want, gotUncached)
+	}
+}
@@ -0,0 +1,39 @@
+package docker_image_history
+
+import "sort"
+
+// assignImageIndices gives each image a stable index from its position in the sorted image refs before the scan starts
+// Images are scanned in this order, and results are sorted by it before being output, so concurrent scans produce the same output
+func (c *Config) assignImageIndices() {
+	refs := c.imageRefs()
+	c.imageIndex = make(map[string]int, len(refs))
+	for i, image := range refs {
+		c.imageIndex[image] = i
+	}
+}
+
+// sortResults sorts the results recorded by the scan workers by image index, so their order does not depend on which image finished first
+func (c *Config) sortResults() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sort.SliceStable(c.offendingDockerImages, func(i, j int) bool {
+		return c.imageIndex[c.offendingDockerImages[i].imageRef] < c.imageIndex[c.offendingDockerImages[j].imageRef]
+	})
+	for i, result := range c.offendingDockerImages {
+		c.offendingImageIndex[result.imageRef] = i
+	}
+
+	sort.SliceStable(c.scanErrors, func(i, j int) bool {
+		return c.imageIndex[c.scanErrors[i].imageRef] < c.imageIndex[c.scanErrors[j].imageRef]
+	})
+	sort.SliceStable(c.missingImages, func(i, j int) bool {
+		return c.imageIndex[c.missingImages[i].imageRef] < c.imageIndex[c.missingImages[j].imageRef]
+	})
+	sort.SliceStable(c.rootImages, func(i, j int) bool {
+		return c.imageIndex[c.rootImages[i].imageRef] < c.imageIndex[c.rootImages[j].imageRef]
+	})
+	sort.SliceStable(c.oversizedImages, func(i, j int) bool {
+		return c.imageIndex[c.oversizedImages[i].imageRef] < c.imageIndex[c.oversizedImages[j].imageRef]
+	})
+}
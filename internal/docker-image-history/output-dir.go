@@ -0,0 +1,21 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"os"
+)
+
+// ensureOutputDir creates the directory the results files are written to, if it does not already exist, and checks it is writable
+// The current working directory is used if dir is empty
+func ensureOutputDir(dir string) error {
+	if len(dir) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating output directory '%s': %w", dir, err)
+	}
+	if err := preflightOutputDir(dir); err != nil {
+		return fmt.Errorf("output directory '%s' is not writable: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package docker_image_history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// jsonSchemaVersion is the version of the JSON results document. Bump it whenever a field is removed or changes meaning
+// so that downstream parsers can detect breaking changes. Adding new fields does not require a bump
+const jsonSchemaVersion = 1
+
+// jsonReport is the top level JSON results document
+type jsonReport struct {
+	SchemaVersion       int                      `json:"schemaVersion"`
+	ClusterContext      string                   `json:"clusterContext"`
+	GeneratedAt         time.Time                `json:"generatedAt"`
+	Keywords            []string                 `json:"keywords"`
+	OffendingImages     []jsonImage              `json:"offendingImages"`
+	NonECRImages        []jsonImage              `json:"nonECRImages"`
+	ScanErrors          []jsonScanError          `json:"scanErrors"`
+	MissingImages       []jsonMissingImage       `json:"missingFromRegistry"`
+	SkippedImages       []jsonSkippedImage       `json:"skippedImages"`
+	OversizedImages     []jsonOversizedImage     `json:"oversizedImages"`
+	MutableTagImages    []jsonMutableTagImage    `json:"mutableTagImages"`
+	NamespaceStats      []namespaceStats         `json:"namespaceStats"`
+	InconsistentDigests []jsonInconsistentDigest `json:"inconsistentDigests"`
+	RunsAsRoot          []jsonRootImage          `json:"runsAsRoot"`
+	UnparseableImages   []jsonUnparseableImage   `json:"unparseableImageRefs"`
+	KeywordGroups       []jsonKeywordGroup       `json:"offendingImagesByKeyword,omitempty"`
+	LeakedImages        []jsonLeakedImage        `json:"leakedImages"`
+	Timings             jsonTimings              `json:"timings"`
+	Run                 runConfig                `json:"run"`
+}
+
+// jsonSkippedImage is an image which was deliberately not scanned, along with the reason and the pods running it
+type jsonSkippedImage struct {
+	ImageRef string    `json:"imageRef"`
+	Reason   string    `json:"reason"`
+	Pods     []jsonPod `json:"pods"`
+}
+
+// jsonOversizedImage is an image larger than the maximum image size, along with the pods running it
+type jsonOversizedImage struct {
+	ImageRef  string    `json:"imageRef"`
+	SizeBytes int64     `json:"sizeBytes"`
+	Pods      []jsonPod `json:"pods"`
+}
+
+// jsonRootImage is an image which runs as root, along with its config user (empty if not set) and the pods running it
+type jsonRootImage struct {
+	ImageRef string    `json:"imageRef"`
+	User     string    `json:"user"`
+	Pods     []jsonPod `json:"pods"`
+}
+
+// jsonMutableTagImage is an image which uses the 'latest' tag or has no tag, along with the pods running it
+type jsonMutableTagImage struct {
+	ImageRef string    `json:"imageRef"`
+	Tag      string    `json:"tag"`
+	Pods     []jsonPod `json:"pods"`
+}
+
+// jsonInconsistentDigest is an image ref which is running with more than one digest, along with the pods running each digest
+type jsonInconsistentDigest struct {
+	ImageRef string            `json:"imageRef"`
+	Digests  []jsonImageDigest `json:"digests"`
+}
+
+// jsonImageDigest is one of the digests an inconsistent image ref is running with
+type jsonImageDigest struct {
+	Digest string    `json:"digest"`
+	Pods   []jsonPod `json:"pods"`
+}
+
+// jsonMissingImage is an image which is running in the cluster but no longer exists in its registry, along with the pods running it
+type jsonMissingImage struct {
+	ImageRef string    `json:"imageRef"`
+	Error    string    `json:"error"`
+	Pods     []jsonPod `json:"pods"`
+}
+
+// jsonScanError is an image which could not be scanned in the JSON results
+type jsonScanError struct {
+	ImageRef string `json:"imageRef"`
+	Stage    string `json:"stage"`
+	Error    string `json:"error"`
+}
+
+// jsonImage is a single image in the JSON results along with the pods running it
+type jsonImage struct {
+	ImageRef            string                        `json:"imageRef"`
+	MatchedKeywords     map[string]int                `json:"matchedKeywords,omitempty"`
+	AbsentKeywords      []string                      `json:"absentKeywords,omitempty"`
+	MatchedMetadata     map[string][]string           `json:"matchedMetadata,omitempty"`
+	MatchContexts       map[string][]string           `json:"matchContexts,omitempty"`
+	MatchedInstructions map[string][]string           `json:"matchedInstructions,omitempty"`
+	MatchedLayers       map[string][]layerMatchDetail `json:"matchedLayers,omitempty"`
+	MatchedRules        []string                      `json:"matchedRules,omitempty"`
+	DecodedKeywords     []string                      `json:"decodedKeywords,omitempty"`
+	Pods                []jsonPod                     `json:"pods"`
+}
+
+// jsonPod provides the K8s context for an image in the JSON results. Only the fields selected by podDetailFields are set
+// Historical is set for pods which have terminated, or no longer exist and were found in the pod events
+type jsonPod struct {
+	PodName       string `json:"podName,omitempty"`
+	ContainerName string `json:"containerName,omitempty"`
+	ContainerType string `json:"containerType,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	NodeName      string `json:"nodeName,omitempty"`
+	PodUID        string `json:"podUID,omitempty"`
+	Historical    bool   `json:"historical,omitempty"`
+}
+
+// buildJSONReport builds the JSON results document. Images are sorted by ref so the output is deterministic
+func (c *Config) buildJSONReport() jsonReport {
+	report := jsonReport{
+		SchemaVersion:    jsonSchemaVersion,
+		ClusterContext:   c.clusterK8sContextName,
+		GeneratedAt:      time.Now().UTC(),
+		Keywords:         c.dockerImageKeyWords,
+		OffendingImages:  make([]jsonImage, 0),
+		NonECRImages:     make([]jsonImage, 0),
+		ScanErrors:       make([]jsonScanError, 0),
+		MissingImages:    make([]jsonMissingImage, 0),
+		SkippedImages:    make([]jsonSkippedImage, 0),
+		OversizedImages:  make([]jsonOversizedImage, 0),
+		MutableTagImages: make([]jsonMutableTagImage, 0),
+		Run:              c.runConfig(),
+	}
+
+	for image, reason := range c.skippedImages {
+		report.SkippedImages = append(report.SkippedImages, jsonSkippedImage{ImageRef: image, Reason: reason, Pods: c.jsonImage(image).Pods})
+	}
+	sort.Slice(report.SkippedImages, func(i, j int) bool { return report.SkippedImages[i].ImageRef < report.SkippedImages[j].ImageRef })
+
+	for _, o := range c.oversizedImages {
+		report.OversizedImages = append(report.OversizedImages, jsonOversizedImage{ImageRef: o.imageRef, SizeBytes: o.size, Pods: c.jsonImage(o.imageRef).Pods})
+	}
+	sort.Slice(report.OversizedImages, func(i, j int) bool { return report.OversizedImages[i].ImageRef < report.OversizedImages[j].ImageRef })
+
+	for image, reason := range c.mutableTagImages {
+		report.MutableTagImages = append(report.MutableTagImages, jsonMutableTagImage{ImageRef: image, Tag: reason, Pods: c.jsonImage(image).Pods})
+	}
+	sort.Slice(report.MutableTagImages, func(i, j int) bool { return report.MutableTagImages[i].ImageRef < report.MutableTagImages[j].ImageRef })
+
+	for _, m := range c.missingImages {
+		report.MissingImages = append(report.MissingImages, jsonMissingImage{ImageRef: m.imageRef, Error: m.err.Error(), Pods: c.jsonImage(m.imageRef).Pods})
+	}
+
+	for _, e := range c.scanErrors {
+		report.ScanErrors = append(report.ScanErrors, jsonScanError{ImageRef: e.imageRef, Stage: e.stage, Error: e.err.Error()})
+	}
+
+	for _, i := range c.offendingDockerImages {
+		image := c.jsonImage(i.imageRef)
+		image.MatchedKeywords = i.matchedKeywords
+		image.AbsentKeywords = i.absentKeywords
+		image.MatchedMetadata = i.matchedMetadata
+		image.MatchContexts = i.matchContexts
+		image.MatchedInstructions = i.matchedInstructions
+		image.MatchedLayers = i.matchedLayers
+		image.MatchedRules = i.matchedRules
+		image.DecodedKeywords = i.decodedKeywords
+		report.OffendingImages = append(report.OffendingImages, image)
+	}
+
+	for image := range c.dockerImages {
+		if !isECRImage(image) {
+			report.NonECRImages = append(report.NonECRImages, c.jsonImage(image))
+		}
+	}
+
+	sort.Slice(report.OffendingImages, func(i, j int) bool { return report.OffendingImages[i].ImageRef < report.OffendingImages[j].ImageRef })
+	if c.groupBy == GroupByKeyword {
+		report.KeywordGroups = c.jsonKeywordGroups()
+	}
+	report.NamespaceStats = c.buildNamespaceStats()
+	report.UnparseableImages = c.jsonUnparseableImages()
+	report.LeakedImages = c.jsonLeakedImages()
+
+	report.RunsAsRoot = make([]jsonRootImage, 0, len(c.rootImages))
+	for _, r := range c.rootImages {
+		report.RunsAsRoot = append(report.RunsAsRoot, jsonRootImage{ImageRef: r.imageRef, User: r.user, Pods: c.jsonImage(r.imageRef).Pods})
+	}
+
+	report.InconsistentDigests = make([]jsonInconsistentDigest, 0, len(c.inconsistentDigests))
+	for _, d := range c.inconsistentDigests {
+		inconsistent := jsonInconsistentDigest{ImageRef: d.imageRef}
+		for _, digest := range d.sortedDigests() {
+			imageDigest := jsonImageDigest{Digest: digest, Pods: make([]jsonPod, 0, len(d.digests[digest]))}
+			for _, pd := range d.digests[digest] {
+				imageDigest.Pods = append(imageDigest.Pods, c.jsonPod(pd))
+			}
+			inconsistent.Digests = append(inconsistent.Digests, imageDigest)
+		}
+		report.InconsistentDigests = append(report.InconsistentDigests, inconsistent)
+	}
+
+	sort.Slice(report.NonECRImages, func(i, j int) bool { return report.NonECRImages[i].ImageRef < report.NonECRImages[j].ImageRef })
+
+	c.mu.Lock()
+	report.Timings = c.jsonTimings()
+	c.mu.Unlock()
+
+	return report
+}
+
+// jsonImage returns the image with the pods which are running it
+func (c *Config) jsonImage(imageRef string) jsonImage {
+	image := jsonImage{ImageRef: imageRef, Pods: make([]jsonPod, 0)}
+	for _, pd := range c.dockerImages[imageRef] {
+		image.Pods = append(image.Pods, c.jsonPod(pd))
+	}
+	return image
+}
+
+// outputJSON writes the offending and non ECR images to a single JSON file
+func (c *Config) outputJSON() error {
+	jsonResultsPath := c.resultsPath("results", "json")
+
+	jsonBytes, err := json.MarshalIndent(c.buildJSONReport(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling results into JSON: %w", err)
+	}
+
+	if err = os.WriteFile(jsonResultsPath, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("writing results to '%s': %w", jsonResultsPath, err)
+	}
+	log.Printf("JSON results written to: %s", jsonResultsPath)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pod detail fields which can be included in the results
+const (
+	PodFieldPodName       = "podName"
+	PodFieldContainerName = "containerName"
+	PodFieldContainerType = "containerType"
+	PodFieldNamespace     = "namespace"
+	PodFieldNodeName      = "nodeName"
+	PodFieldPodUID        = "podUID"
+)
+
+// AllPodDetailFields are the pod detail fields which can be included in the results, in the order they are output
+var AllPodDetailFields = []string{PodFieldPodName, PodFieldContainerName, PodFieldContainerType, PodFieldNamespace, PodFieldNodeName, PodFieldPodUID}
+
+// DefaultPodDetailFields are the pod detail fields included in the results unless others are selected
+var DefaultPodDetailFields = []string{PodFieldPodName, PodFieldContainerName, PodFieldContainerType, PodFieldNamespace}
+
+// ValidatePodDetailFields validates whether all the fields are valid pod detail fields
+func ValidatePodDetailFields(fields []string) bool {
+	for _, f := range fields {
+		if !sliceContains(AllPodDetailFields, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// includesPodField returns whether a pod detail field has been selected for the results
+func (c *Config) includesPodField(field string) bool {
+	if len(c.podDetailFields) == 0 {
+		return sliceContains(DefaultPodDetailFields, field)
+	}
+	return sliceContains(c.podDetailFields, field)
+}
+
+// formatPod returns the selected fields of a pod for the text results, e.g. 'podName: web-1, containerName: nginx, namespace: web'
+func (c *Config) formatPod(pd podDetails) string {
+	values := map[string]string{
+		PodFieldPodName:       pd.podName,
+		PodFieldContainerName: pd.containerName,
+		PodFieldContainerType: pd.containerType,
+		PodFieldNamespace:     pd.namespace,
+		PodFieldNodeName:      pd.nodeName,
+		PodFieldPodUID:        pd.podUID,
+	}
+
+	fields := make([]string, 0, len(AllPodDetailFields))
+	for _, field := range AllPodDetailFields {
+		if c.includesPodField(field) {
+			fields = append(fields, fmt.Sprintf("%s: %s", field, values[field]))
+		}
+	}
+	// Pods which have terminated or no longer exist are always marked, whichever fields are selected
+	if pd.historical {
+		fields = append(fields, "historical: true")
+	}
+	return strings.Join(fields, ", ")
+}
+
+// jsonPod returns the selected fields of a pod for the JSON results
+func (c *Config) jsonPod(pd podDetails) jsonPod {
+	var p jsonPod
+	if c.includesPodField(PodFieldPodName) {
+		p.PodName = pd.podName
+	}
+	if c.includesPodField(PodFieldContainerName) {
+		p.ContainerName = pd.containerName
+	}
+	if c.includesPodField(PodFieldContainerType) {
+		p.ContainerType = pd.containerType
+	}
+	if c.includesPodField(PodFieldNamespace) {
+		p.Namespace = pd.namespace
+	}
+	if c.includesPodField(PodFieldNodeName) {
+		p.NodeName = pd.nodeName
+	}
+	if c.includesPodField(PodFieldPodUID) {
+		p.PodUID = pd.podUID
+	}
+	p.Historical = pd.historical
+	return p
+}
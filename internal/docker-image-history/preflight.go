@@ -0,0 +1,96 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// preflightCheck is the outcome of a single preflight check
+type preflightCheck struct {
+	name string
+	err  error
+}
+
+// Preflight verifies the Docker daemon, K8s context, ECR credentials and output directory are usable without scanning anything
+// Writes a pass/fail table to w and returns whether all the checks passed
+func Preflight(ctx context.Context, opts Options, w io.Writer) bool {
+	var checks []preflightCheck
+
+	checks = append(checks, preflightCheck{name: "Docker daemon reachable", err: preflightDocker(ctx, opts)})
+	k8sSource := fmt.Sprintf("K8s context '%s'", opts.ClusterK8sContextName)
+	if len(opts.ClusterK8sContextName) == 0 {
+		k8sSource = "In-cluster K8s config"
+	}
+	checks = append(checks, preflightCheck{name: k8sSource + " can list pods", err: preflightK8s(ctx, opts.ClusterK8sContextName, opts.InCluster, opts.Namespace)})
+	for _, region := range opts.ECRRegions {
+		_, err := fetchECRCredentials(ctx, opts.ImagesAccountAWSProfileName, region, opts.DebugAuth)
+		checks = append(checks, preflightCheck{name: fmt.Sprintf("ECR auth token for region '%s'", region), err: err})
+	}
+	outputDir := opts.OutputDir
+	if len(outputDir) == 0 {
+		outputDir = "."
+	}
+	checks = append(checks, preflightCheck{name: "Output directory writable", err: preflightOutputDir(outputDir)})
+
+	passed := true
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, check := range checks {
+		if check.err != nil {
+			passed = false
+			_, _ = fmt.Fprintf(tw, "%s\tFAIL\t%s\n", check.name, check.err)
+		} else {
+			_, _ = fmt.Fprintf(tw, "%s\tPASS\t\n", check.name)
+		}
+	}
+	_ = tw.Flush()
+
+	return passed
+}
+
+// preflightDocker checks the Docker daemon responds to a ping
+func preflightDocker(ctx context.Context, opts Options) error {
+	dockerCli, err := newDockerClient(opts)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dockerCli.Close() }()
+
+	if _, err = dockerCli.Ping(ctx); err != nil {
+		return fmt.Errorf("pinging Docker daemon: %w", err)
+	}
+	return nil
+}
+
+// preflightK8s checks the K8s context, or the in-cluster config, is valid and has permissions to list pods in the namespace, or across
+// all namespaces if it is empty
+func preflightK8s(ctx context.Context, contextName string, inCluster bool, namespace string) error {
+	inCluster, err := resolveInCluster(contextName, inCluster)
+	if err != nil {
+		return err
+	}
+	k8sClient, err := newK8sClient(contextName, inCluster)
+	if err != nil {
+		return err
+	}
+
+	if _, err = k8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+		return fmt.Errorf("listing k8s pods: %w", err)
+	}
+	return nil
+}
+
+// preflightOutputDir checks a results file can be created in the output directory
+func preflightOutputDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".preflight-")
+	if err != nil {
+		return fmt.Errorf("creating file in '%s': %w", dir, err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
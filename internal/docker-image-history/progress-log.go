@@ -0,0 +1,74 @@
+package docker_image_history
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// When stderr is not a terminal (e.g. CI logs) a compact progress line is logged every progressLogInterval, and each time the scan
+// crosses another progressLogStepPercent of the images, instead of a line per image
+const (
+	progressLogInterval    = 30 * time.Second
+	progressLogStepPercent = 5
+)
+
+// stderrIsTerminal returns whether progress is being logged to an interactive terminal
+func stderrIsTerminal() bool {
+	return term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// startProgressLog logs a compact progress line every progressLogInterval until the returned function is called
+// The returned function is safe to call more than once
+func (c *Config) startProgressLog() func() {
+	done := make(chan struct{})
+	ticker := time.NewTicker(progressLogInterval)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.mu.Lock()
+				c.logProgressLine()
+				c.mu.Unlock()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// logProgressStep logs a compact progress line if the scan has crossed another progressLogStepPercent of the images since the last one
+// Must be called whilst holding c.mu
+func (c *Config) logProgressStep() {
+	if c.progress.totalImages == 0 {
+		return
+	}
+	step := c.progress.startedImages * 100 / c.progress.totalImages / progressLogStepPercent
+	if step > c.progress.loggedStep {
+		c.progress.loggedStep = step
+		c.logProgressLine()
+	}
+}
+
+// logProgressLine logs the number of images started and completed, along with the offending images and errors so far
+// Must be called whilst holding c.mu
+func (c *Config) logProgressLine() {
+	percent := 100
+	if c.progress.totalImages > 0 {
+		percent = c.progress.processedImages * 100 / c.progress.totalImages
+	}
+	log.Printf("Progress: %d / %d images completed (%d%%), %d started, %d offending, %d scan errors",
+		c.progress.processedImages, c.progress.totalImages, percent, c.progress.startedImages,
+		len(c.offendingDockerImages)+c.streamedOffendingImages, len(c.scanErrors))
+}
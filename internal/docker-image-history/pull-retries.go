@@ -0,0 +1,60 @@
+package docker_image_history
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// pullRetryDelay is the delay before the first retry of a pull which failed with a transient error. It doubles after each retry
+const pullRetryDelay = 2 * time.Second
+
+// permanentPullErrors are the messages of pull failures which retrying cannot fix, e.g. auth failures
+var permanentPullErrors = []string{"manifest unknown", "unauthorized", "access denied", "denied:", "no basic auth credentials",
+	"authentication required", "invalid reference format"}
+
+// isPermanentPullError returns whether a pull failed in a way which retrying cannot fix: the image does not exist, the registry
+// rejected the credentials, or the scan was cancelled. Any other failure (e.g. a timeout or a 5xx from the registry) is retried
+func isPermanentPullError(err error) bool {
+	var notFound *imageNotFoundError
+	var regionErr *unconfiguredECRRegionError
+	if errors.As(err, &notFound) || errors.As(err, &regionErr) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	if errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) || errdefs.IsInvalidParameter(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range permanentPullErrors {
+		if strings.Contains(msg, permanent) {
+			return true
+		}
+	}
+	return false
+}
+
+// withJitter adds up to half the delay again at random, so that workers whose pulls failed at the same time do not all retry at once
+func withJitter(delay time.Duration) time.Duration {
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// logPullFailures logs how many images could not be pulled, even after retrying. They are written to the scan errors report
+func (c *Config) logPullFailures() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failed := 0
+	for _, e := range c.scanErrors {
+		if e.stage == scanStagePull {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("WARNING: %d images could not be pulled after up to %d retries, they are listed in the scan errors", failed, c.pullRetries)
+	}
+}
@@ -2,27 +2,56 @@ package docker_image_history
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/docker/docker/api/types"
 	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
+// Supported formats for the results files
+const (
+	OutputFormatText = "text"
+	OutputFormatJSON = "json"
+)
+
+// Supported metrics for the keyword match counts. Either the number of history layers the keyword is in, or its total number of occurrences
+const (
+	CountModeLayers      = "layers"
+	CountModeOccurrences = "occurrences"
+)
+
+// defaultImageSizeEstimate is the disk usage assumed for an image whose size cannot be read from its registry
+const defaultImageSizeEstimate int64 = 1 << 30
+
+// diskUsageMultiplier converts the compressed layer sizes in a manifest into an estimate of the extracted size on disk
+const diskUsageMultiplier = 2
+
+// DefaultTimestampFormat is the layout used for timestamps in the results filenames. It is colon-free so it is valid on all filesystems and sorts chronologically
+const DefaultTimestampFormat = "2006-01-02T15-04-05"
+
+// IsFixedTimestampFormat returns whether a timestamp layout formats every time the same (e.g. 'latest'), so the results paths do not
+// change between runs
+func IsFixedTimestampFormat(layout string) bool {
+	return time.Unix(0, 0).UTC().Format(layout) == time.Unix(1e9+123456789, 123456789).UTC().Format(layout)
+}
+
 var AllAWSRegions = []string{"af-south-1", "ap-south-1", "eu-north-1", "eu-west-3", "eu-west-2", "eu-west-1", "ap-northeast-3", "ap-northeast-2",
 	"ap-northeast-1", "ca-central-1", "sa-east-1", "ap-southeast-1", "ap-southeast-2", "eu-central-1", "us-east-1", "us-east-2", "us-west-1",
 	"us-west-2",
@@ -33,48 +62,304 @@ var AllAWSRegions = []string{"af-south-1", "ap-south-1", "eu-north-1", "eu-west-
 // 1) Images which have a history containing at least 1 keyword
 // 2) Images which are not stored in an AWS ECR registry
 func (c *Config) ProcessAllImagesHistoryForKeywords() error {
+	return c.ProcessAllImagesHistoryForKeywordsContext(context.Background())
+}
+
+// ProcessAllImagesHistoryForKeywordsContext is ProcessAllImagesHistoryForKeywords, stopping early when the context is cancelled
+// Once cancelled no new images are pulled, the images being processed are still cleaned up and the results found so far are written
+// before the cancellation is returned as an error
+func (c *Config) ProcessAllImagesHistoryForKeywordsContext(ctx context.Context) error {
+	if err := c.processAllImages(ctx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("scan cancelled, the results found so far have been written: %w", err)
+	}
+	return nil
+}
+
+// processAllImages discovers, scans and writes the results for all the images
+func (c *Config) processAllImages(ctx context.Context) error {
 
-	defer func(dockerClient *dockerClient.Client) {
+	defer func(dockerClient imageClient) {
 		err := dockerClient.Close()
 		if err != nil {
 			log.Printf("closing Docker client: %s", err)
 		}
 	}(c.dockerClient)
 
-	if err := c.queryAllContainerImageRefsInCluster(); err != nil {
+	discoveryStart := time.Now()
+	if len(c.workloadName) > 0 {
+		if err := c.queryWorkloadImages(ctx); err != nil {
+			return err
+		}
+	} else if err := c.queryAllContainerImageRefsInCluster(ctx); err != nil {
 		return err
 	}
 
-	totalUniqueImages := len(c.dockerImages)
-	count := 1
-	for image := range c.dockerImages {
-		fmt.Printf("Pulling image (%d / %d): %s\n", count, totalUniqueImages, image)
-		err := c.pullImage(image)
-		if err != nil {
+	if c.workloadTemplates {
+		if err := c.queryWorkloadTemplateImages(ctx); err != nil {
 			return err
 		}
+	}
 
-		count++
+	if c.podEvents {
+		if err := c.queryEventImages(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(c.crdImageSourcesPath) > 0 {
+		if err := c.queryCRDImages(ctx); err != nil {
+			return err
+		}
+	}
+
+	if c.nodeImageStats || c.nodeInventory || c.scanNodeCachedImages {
+		if err := c.queryNodeImages(ctx); err != nil {
+			return err
+		}
+		c.logNodeImages()
+	}
+	c.timePhase(phaseDiscovery, discoveryStart)
 
-		result, err := c.checkImageHistoryForKeyWords(image)
+	c.findUnparseableImages()
+	if c.failOnUnparseableRefs && len(c.unparseableImages) > 0 {
+		if err := c.outputUnparseableImages(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%d image refs in the cluster cannot be parsed", len(c.unparseableImages))
+	}
+
+	// The node inventory is built from the K8s API alone, without pulling or reading any images
+	if c.nodeInventory {
+		return c.outputInventory()
+	}
+
+	if c.inventoryOutput {
+		if err := c.outputInventory(); err != nil {
+			return err
+		}
+	}
+
+	// A dry run only lists the images, without pulling, reading or removing any of them
+	if c.dryRun {
+		return c.outputDryRun()
+	}
+
+	if len(c.keywordPoliciesPath) > 0 {
+		if err := c.resolveNamespaceKeywords(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(c.keywordRulesPath) > 0 {
+		rules, err := loadKeywordRules(c.keywordRulesPath)
 		if err != nil {
 			return err
 		}
-		if result.matchFound {
-			c.offendingDockerImages = append(c.offendingDockerImages, result)
+		c.keywordRules = rules
+		c.keywordRuleTerms = c.ruleTerms()
+		if err = c.compileKeywordRegexps(c.keywordRuleTerms); err != nil {
+			return err
+		}
+		log.Printf("Loaded %d keyword rules from: %s", len(rules.Rules), c.keywordRulesPath)
+	}
+
+	if len(c.expectedImagesPath) > 0 {
+		if err := c.outputDigestDrift(); err != nil {
+			return err
+		}
+	}
+
+	c.findInconsistentDigests()
+
+	if c.lowMemory {
+		if err := c.outputNonECRImages(); err != nil {
+			return err
+		}
+		if err := c.outputMutableTagImages(); err != nil {
+			return err
+		}
+		if err := c.outputInconsistentDigests(); err != nil {
+			return err
+		}
+		if err := c.outputUnparseableImages(); err != nil {
+			return err
+		}
+		if err := c.openOffendingStream(); err != nil {
+			return err
+		}
+		defer c.closeOffendingStream()
+	}
+
+	if len(c.resumeFrom) > 0 {
+		if err := c.openCheckpoint(); err != nil {
+			return err
+		}
+		defer c.closeCheckpoint()
+	}
+
+	c.assignImageIndices()
+	c.sampleImages()
+
+	if len(c.baselinePath) > 0 {
+		if err := c.applyBaseline(ctx); err != nil {
+			return err
+		}
+	}
+
+	c.skipTrustedImages()
+
+	c.mu.Lock()
+	c.progress.totalImages = len(c.dockerImages) - len(c.unsampledImages)
+	c.mu.Unlock()
+
+	if len(c.serveAddress) > 0 {
+		c.startStatusServer()
+	}
+
+	defer c.printErrorSummary()
+
+	stopProgressLog := func() {}
+	if c.compactProgress {
+		log.Printf("Not logging to a terminal, so progress will be logged every %s and every %d%% of the images", progressLogInterval, progressLogStepPercent)
+		stopProgressLog = c.startProgressLog()
+		defer stopProgressLog()
+	}
+
+	if c.remote {
+		if err := c.scanImagesRemotely(ctx, c.imageRefs()); err != nil {
+			return err
+		}
+	} else {
+		images := c.imageRefs()
+		// The images already cached on the nodes are read from their registries, so only the others are pulled
+		if c.scanNodeCachedImages {
+			var cached []string
+			cached, images = c.partitionNodeCachedImages(images)
+			log.Printf("Reading the history of the %d images cached on the nodes from the registries, and pulling the other %d", len(cached), len(images))
+			if err := c.scanImagesRemotely(ctx, cached); err != nil {
+				return err
+			}
+		}
+		if err := c.scanImagesLocally(ctx, images); err != nil {
+			return err
+		}
+	}
+	stopProgressLog()
+	if ctx.Err() != nil {
+		log.Printf("WARNING: the scan was cancelled, writing the results of the %d images scanned so far", c.progress.processedImages)
+	}
+
+	c.mu.Lock()
+	c.progress.currentImage = ""
+	c.progress.finished = true
+	c.mu.Unlock()
+	c.sortResults()
+
+	outputStart := time.Now()
+	defer func() {
+		c.timePhase(phaseOutput, outputStart)
+		c.logTimings()
+	}()
+
+	if len(c.skippedImages) > 0 {
+		log.Printf("Skipped scanning %d images", len(c.skippedImages))
+	}
+	c.logUnconfiguredECRRegions()
+	c.logLayerCacheStats()
+
+	if len(c.baselinePath) > 0 {
+		if err := c.writeBaseline(); err != nil {
+			return err
 		}
+	}
 
-		if err = c.cleanupImage(image); err != nil {
+	// Only the summary is printed, no results files are written
+	if c.summaryOnly {
+		return c.printSummary()
+	}
+
+	if c.syslog {
+		if err := c.outputSyslog(); err != nil {
 			return err
 		}
+		if c.syslogOnly {
+			return nil
+		}
+	}
+
+	if c.outputFormat == OutputFormatJSON {
+		return c.outputJSON()
+	}
+	if c.lowMemory {
+		log.Printf("%d offending images were streamed to: %s", c.streamedOffendingImages, c.offendingStreamPath)
+	}
+
+	err := c.outputScanErrors()
+	if err != nil {
+		return err
+	}
+
+	err = c.outputSkippedImages()
+	if err != nil {
+		return err
 	}
 
-	err := c.outputOffendingImages()
+	err = c.outputMissingImages()
 	if err != nil {
 		return err
 	}
 
-	err = c.outputNonECRImages()
+	err = c.outputOversizedImages()
+	if err != nil {
+		return err
+	}
+
+	err = c.outputRootImages()
+	if err != nil {
+		return err
+	}
+
+	// These are written before the scan in low memory mode
+	if !c.lowMemory {
+		if c.groupBy == GroupByKeyword {
+			err = c.outputOffendingImagesByKeyword()
+		} else {
+			err = c.outputOffendingImages()
+		}
+		if err != nil {
+			return err
+		}
+
+		err = c.outputNamespaceStats()
+		if err != nil {
+			return err
+		}
+
+		err = c.outputNonECRImages()
+		if err != nil {
+			return err
+		}
+
+		err = c.outputMutableTagImages()
+		if err != nil {
+			return err
+		}
+
+		err = c.outputInconsistentDigests()
+		if err != nil {
+			return err
+		}
+
+		err = c.outputUnparseableImages()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.outputLeakedImages()
 	if err != nil {
 		return err
 	}
@@ -82,64 +367,549 @@ func (c *Config) ProcessAllImagesHistoryForKeywords() error {
 	return nil
 }
 
-// NewConfig returns a new Config with initialised Docker & K8s clients
-func NewConfig(keywords []string, clusterAccountProfile, imagesAccountProfile string, ecrRegions []string) (*Config, error) {
-	cfg := &Config{}
+// scanImagesLocally pulls each image using the local Docker instance, checks its history for keywords and then removes it again
+// Up to concurrency images are processed at once, picked from the machine if it is not set. If a disk budget is set, new pulls wait
+// until the estimated size of the images currently stored locally leaves room for them
+func (c *Config) scanImagesLocally(ctx context.Context, images []string) error {
+	c.warnDaemonInsecureRegistries(ctx)
 
-	cfg.imagesAccountAWSProfileName = imagesAccountProfile
-	cfg.clusterK8sContextName = clusterAccountProfile
-	cfg.dockerImageKeyWords = keywords
-	cfg.dockerImages = make(map[string][]podDetails)
-	cfg.offendingDockerImages = make([]offendingDockerImage, 0)
-	cfg.ecrCredentials = make(map[string]string)
-	cfg.ecrRegions = ecrRegions
+	if c.concurrency < 1 {
+		c.concurrency = c.autoConcurrency()
+	}
 
-	// Get Docker login credentials via ECR API for each AWS region images are present in
-	for _, region := range cfg.ecrRegions {
-		awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithSharedConfigProfile(imagesAccountProfile), config.WithRegion(region))
+	var diskBudget *semaphore.Weighted
+	if c.maxDiskBytes > 0 {
+		log.Printf("Limiting the estimated local disk usage of pulled images to %s", units.HumanSize(float64(c.maxDiskBytes)))
+		diskBudget = semaphore.NewWeighted(c.maxDiskBytes)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+	for _, image := range images {
+		image := image
+		if c.skipScan(image) {
+			continue
+		}
+		g.Go(func() error {
+			return c.scanImageLocally(ctx, image, diskBudget)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	c.logPullFailures()
+
+	// Verify the cleanup even if some images could not be removed, so every leaked image is reported
+	var cleanupErr error
+	if c.deferCleanup {
+		cleanupErr = c.cleanupPulledImages()
+	}
+	c.verifyCleanup()
+	if cleanupErr != nil {
+		return cleanupErr
+	}
+	log.Printf("Removed %d images, reclaiming %s", c.removedImages, units.HumanSize(float64(c.reclaimedBytes)))
+	c.logKeptImages()
+
+	return nil
+}
+
+// scanImageLocally pulls a single image, checks its history for keywords and then removes it again
+// The image's estimated disk usage is reserved from the disk budget (if set) until it has been removed
+func (c *Config) scanImageLocally(ctx context.Context, image string, diskBudget *semaphore.Weighted) error {
+	// Once the scan is cancelled no new images are pulled
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// An image already in the local Docker instance is inspected as is, and left in place afterwards
+	present := c.skipPull(ctx, image)
+	if diskBudget != nil && !present {
+		size := c.estimateImageDiskUsage(ctx, image)
+		if err := diskBudget.Acquire(ctx, size); err != nil {
+			return fmt.Errorf("waiting for disk budget to pull '%s': %w", image, err)
+		}
+		defer diskBudget.Release(size)
+	}
+
+	// An image with a mutable tag is pulled again even if it is present, but as it was already present it is still left in place
+	presentBeforePull := present
+	count := c.startProgress(image)
+	if present {
+		if !c.compactProgress {
+			log.Printf("Using image already present locally (%d / %d): %s", count, len(c.dockerImages), image)
+		}
+	} else {
+		if !c.compactProgress {
+			log.Printf("Pulling image (%d / %d): %s", count, len(c.dockerImages), image)
+		}
+		// A pulled image is only tagged once the pull completes, so if it is present after a timed out pull it was not before
+		presentBeforePull = c.localImagePresent(ctx, image)
+		pullStart := time.Now()
+		err := c.pullImage(ctx, image)
+		c.timePhase(phasePull, pullStart)
+		var timeoutErr *pullTimeoutError
+		if errors.As(err, &timeoutErr) && !presentBeforePull {
+			c.cleanupTimedOutPull(image)
+		}
+		var notFound *imageNotFoundError
+		if errors.As(err, &notFound) {
+			c.recordMissingImage(image, err)
+			return nil
+		}
+		if c.skipUnconfiguredECRRegion(image, err) {
+			return nil
+		}
+		var regionErr *unconfiguredECRRegionError
+		if errors.As(err, &regionErr) {
+			c.countError(scanStagePull)
+			return err
+		}
+		if err != nil && ctx.Err() != nil {
+			return nil
+		}
+		// A failure to pull a single image should not abort the whole scan, the other workers carry on
 		if err != nil {
-			return nil, fmt.Errorf("loading AWS config: %s", err)
+			c.recordScanError(image, scanStagePull, err)
+			return nil
 		}
-		ecrClient := ecr.NewFromConfig(awsConfig)
+	}
+
+	pulled, err := c.retainLocalImage(image)
+	if err != nil {
+		c.recordScanError(image, scanStageInspect, err)
+		return nil
+	}
+	pulled.alreadyPresent = presentBeforePull
+	// Checked before the result is recorded, which releases the pod details of images which are not oversized in low memory mode
+	c.checkImageSize(image, pulled.size)
+
+	// A failure to inspect a single image should not abort the whole scan, but the pulled image must still be cleaned up
+	inspectStart := time.Now()
+	if err = c.inspectLocalImage(ctx, pulled); err != nil && ctx.Err() == nil {
+		c.recordScanError(image, scanStageInspect, err)
+	}
+	c.timePhase(phaseInspect, inspectStart)
 
-		ecrResp, err := ecrClient.GetAuthorizationToken(context.Background(), &ecr.GetAuthorizationTokenInput{})
+	if c.deferCleanup {
+		c.mu.Lock()
+		c.pulledImages = append(c.pulledImages, pulled)
+		c.mu.Unlock()
+		return nil
+	}
+	// A failure to remove the image is reported as a leaked image once the scan has finished
+	if err = c.cleanupImage(pulled); err != nil {
+		log.Printf("WARNING: %s", err)
+	}
+	return nil
+}
+
+// inspectLocalImage checks the history (and optionally metadata) of a pulled image for keywords and records the result
+// Images created before the since cutoff are skipped. The result is reused if the same image has already been checked under another ref
+func (c *Config) inspectLocalImage(ctx context.Context, pulled pulledImage) error {
+	image := pulled.imageRef
+	if !c.since.IsZero() {
+		created, err := c.localImageCreated(ctx, image)
 		if err != nil {
-			return nil, fmt.Errorf("getting ECR auth token: %s", err)
+			return err
 		}
+		if c.createdBeforeSince(image, created) {
+			return nil
+		}
+	}
 
-		decodedToken, err := base64.StdEncoding.DecodeString(*ecrResp.AuthorizationData[0].AuthorizationToken)
+	if c.checkRunsAsRoot {
+		user, err := c.localImageUser(ctx, image)
 		if err != nil {
-			return nil, fmt.Errorf("decoding ECR auth token: %s", err)
+			return err
 		}
-		credentialsSlice := strings.Split(string(decodedToken), ":")
-		jsonBytes, err := json.Marshal(map[string]string{"username": "AWS", "password": credentialsSlice[1]})
+		c.checkImageUser(image, user)
+	}
+
+	if result, seen := c.seenResult(pulled); seen {
+		c.recordResult(result)
+		return nil
+	}
+
+	result, err := c.checkImageHistoryForKeyWords(ctx, image)
+	if err != nil {
+		return err
+	}
+	if c.scanAnnotations {
+		if err = c.matchMetadataForKeyWords(ctx, image, &result); err != nil {
+			return err
+		}
+	}
+	if err = c.matchLocalConfigFields(ctx, image, &result); err != nil {
+		return err
+	}
+
+	c.rememberResult(pulled, result)
+	c.recordResult(result)
+	return nil
+}
+
+// estimateImageDiskUsage estimates the local disk used by an image from its size on the nodes (if -nodeImageStats is set), or
+// otherwise the compressed layer sizes in its registry manifest
+// Falls back to defaultImageSizeEstimate if the manifest cannot be read. Estimates larger than the whole budget are capped to it
+// so that the image can still be pulled on its own
+func (c *Config) estimateImageDiskUsage(ctx context.Context, image string) int64 {
+	// The kubelet reports the size of images cached on nodes, which saves a request to the registry
+	if e, ok := c.nodeImageFor(image); ok && e.sizeBytes > 0 {
+		if e.sizeBytes > c.maxDiskBytes {
+			return c.maxDiskBytes
+		}
+		return e.sizeBytes
+	}
+
+	size, err := c.remoteImageSize(ctx, image)
+	if err != nil {
+		log.Printf("unable to estimate the size of '%s', assuming %s: %s", image, units.HumanSize(float64(defaultImageSizeEstimate)), err)
+		size = defaultImageSizeEstimate
+	} else {
+		size *= diskUsageMultiplier
+	}
+
+	if size > c.maxDiskBytes {
+		size = c.maxDiskBytes
+	}
+	return size
+}
+
+// imageRefs returns the refs of all the images to scan, sorted so the scan order is predictable
+// Workers release the pod details of scanned images in low memory mode, so the scan iterates over this copy rather than the map
+func (c *Config) imageRefs() []string {
+	refs := make([]string, 0, len(c.dockerImages))
+	for image := range c.dockerImages {
+		refs = append(refs, image)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// skipScan returns whether an image does not need to be scanned, as it has already been checkpointed, is outside the sample, its
+// results have been carried forward from the baseline or it matches a skipImages pattern
+// Safe for concurrent use, as the workers checkpoint the images they finish whilst the remaining images are still being queued
+func (c *Config) skipScan(imageRef string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkpointed[imageRef] || c.unsampledImages[imageRef] || c.carriedForward[imageRef] || c.trustedImages[imageRef]
+}
+
+// startProgress records the image currently being processed so it can be displayed by the status server
+// Returns the position of the image in the scan, starting from 1
+func (c *Config) startProgress(imageRef string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.progress.currentImage = imageRef
+	c.progress.startedImages++
+	if c.compactProgress {
+		c.logProgressStep()
+	}
+	return c.progress.startedImages
+}
+
+// recordResult stores the result of scanning a single image. Safe for concurrent use
+// An image is only ever stored once. If it has already been recorded the matches are merged into the existing entry
+func (c *Config) recordResult(result offendingDockerImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.progress.processedImages++
+
+	entry := checkpointEntry{
+		ImageRef:            result.imageRef,
+		MatchFound:          result.matchFound,
+		MatchedKeywords:     result.matchedKeywords,
+		AbsentKeywords:      result.absentKeywords,
+		MatchedMetadata:     result.matchedMetadata,
+		MatchContexts:       result.matchContexts,
+		MatchedInstructions: result.matchedInstructions,
+		MatchedLayers:       result.matchedLayers,
+		MatchedRules:        result.matchedRules,
+		DecodedKeywords:     result.decodedKeywords,
+	}
+	c.checkpointResult(entry)
+	if result.matchFound && c.keepOffendingImages {
+		if c.offendingRefs == nil {
+			c.offendingRefs = make(map[string]bool)
+		}
+		c.offendingRefs[result.imageRef] = true
+	}
+	if c.baselineResults != nil {
+		c.recordBaselineResult(entry)
+	}
+
+	if c.lowMemory {
+		c.streamResult(result)
+		return
+	}
+
+	if !result.matchFound {
+		return
+	}
+
+	i, found := c.offendingImageIndex[result.imageRef]
+	if !found {
+		c.offendingImageIndex[result.imageRef] = len(c.offendingDockerImages)
+		c.offendingDockerImages = append(c.offendingDockerImages, result)
+		return
+	}
+	mergeOffendingImage(&c.offendingDockerImages[i], result)
+}
+
+// mergeOffendingImage merges the matches from a repeat scan of the same image into an existing result
+// Counts are not summed as both results come from the same image history
+func mergeOffendingImage(existing *offendingDockerImage, result offendingDockerImage) {
+	for keyword, count := range result.matchedKeywords {
+		if count > existing.matchedKeywords[keyword] {
+			existing.matchedKeywords[keyword] = count
+		}
+	}
+	existing.absentKeywords = appendUnique(existing.absentKeywords, result.absentKeywords...)
+	existing.matchedRules = appendUnique(existing.matchedRules, result.matchedRules...)
+	existing.decodedKeywords = appendUnique(existing.decodedKeywords, result.decodedKeywords...)
+	for keyword, instructions := range result.matchedInstructions {
+		if existing.matchedInstructions == nil {
+			existing.matchedInstructions = make(map[string][]string)
+		}
+		existing.matchedInstructions[keyword] = appendUnique(existing.matchedInstructions[keyword], instructions...)
+	}
+	for keyword, layers := range result.matchedLayers {
+		if existing.matchedLayers == nil {
+			existing.matchedLayers = make(map[string][]layerMatchDetail)
+		}
+		existing.matchedLayers[keyword] = appendUniqueLayerMatches(existing.matchedLayers[keyword], layers...)
+	}
+	for keyword, contexts := range result.matchContexts {
+		if existing.matchContexts == nil {
+			existing.matchContexts = make(map[string][]string)
+		}
+		existing.matchContexts[keyword] = appendUnique(existing.matchContexts[keyword], contexts...)
+	}
+	for keyword, metadata := range result.matchedMetadata {
+		if existing.matchedMetadata == nil {
+			existing.matchedMetadata = make(map[string][]string)
+		}
+		existing.matchedMetadata[keyword] = appendUnique(existing.matchedMetadata[keyword], metadata...)
+	}
+}
+
+// NewConfig returns a new Config with initialised Docker & K8s clients. Cancelling the context stops the up front ECR auth
+func NewConfig(ctx context.Context, opts Options) (*Config, error) {
+	cfg := &Config{stdout: opts.Stdout, stderr: opts.Stderr, compactProgress: !stderrIsTerminal(), startedAt: time.Now()}
+	if cfg.stdout == nil {
+		cfg.stdout = os.Stdout
+	}
+	if cfg.stderr == nil {
+		cfg.stderr = os.Stderr
+	}
+	cfg.findings = &lockedWriter{w: cfg.stdout}
+
+	cfg.imagesAccountAWSProfileName = opts.ImagesAccountAWSProfileName
+	cfg.clusterK8sContextName = opts.ClusterK8sContextName
+	inCluster, err := resolveInCluster(opts.ClusterK8sContextName, opts.InCluster)
+	if err != nil {
+		return nil, err
+	}
+	if inCluster {
+		cfg.inCluster = true
+		cfg.clusterK8sContextName = inClusterContextName
+	}
+	if err := validateNamespaceFilters(opts); err != nil {
+		return nil, err
+	}
+	cfg.dockerImageKeyWords = opts.DockerImageKeyWords
+	if err := validateKeywordFields(cfg.dockerImageKeyWords); err != nil {
+		return nil, err
+	}
+	cfg.dockerImages = make(map[string][]podDetails)
+	cfg.offendingDockerImages = make([]offendingDockerImage, 0)
+	cfg.offendingImageIndex = make(map[string]int)
+	cfg.ecrCredentials = make(map[string]ecrToken)
+	cfg.ecrRegions = opts.ECRRegions
+	cfg.serveAddress = opts.ServeAddress
+	cfg.expectedImagesPath = opts.ExpectedImagesPath
+	cfg.timestampFormat = opts.TimestampFormat
+	cfg.remote = opts.Remote
+	cfg.caseSensitive = opts.CaseSensitive
+	cfg.outputFormat = opts.OutputFormat
+	cfg.deferCleanup = opts.DeferCleanup
+	cfg.insecureRegistries = opts.InsecureRegistries
+	cfg.registryCAFile = opts.RegistryCAFile
+	cfg.keywordPoliciesPath = opts.KeywordPoliciesPath
+	cfg.inventoryOutput = opts.InventoryOutput
+	cfg.scanAnnotations = opts.ScanAnnotations
+	cfg.since = opts.Since
+	cfg.contextChars = opts.ContextChars
+	cfg.layerCache = make(map[string][]layerMatch)
+	cfg.skippedImages = make(map[string]string)
+	cfg.mutableTagImages = make(map[string]string)
+	cfg.unconfiguredECRRegions = make(map[string]bool)
+	cfg.remoteConcurrency = opts.RemoteConcurrency
+	cfg.concurrency = opts.Concurrency
+	cfg.syslog = opts.Syslog
+	cfg.syslogNetwork = opts.SyslogNetwork
+	cfg.syslogAddress = opts.SyslogAddress
+	cfg.syslogOnly = opts.SyslogOnly
+	cfg.resumeFrom = opts.ResumeFrom
+	cfg.namespace = opts.Namespace
+	cfg.cleanupConcurrency = opts.CleanupConcurrency
+	cfg.failFast = opts.FailFast
+	cfg.lowMemory = opts.LowMemory
+	cfg.nodeImageStats = opts.NodeImageStats
+	cfg.scanNodeCachedImages = opts.ScanNodeCachedImages
+	cfg.nodeInventory = opts.NodeInventory
+	cfg.runOnly = opts.RunOnly
+	cfg.maxImages = opts.MaxImages
+	cfg.seed = opts.Seed
+	cfg.ecrRegionOverrides = opts.ECRRegionOverrides
+	cfg.baselinePath = opts.BaselinePath
+	cfg.fullRescan = opts.FullRescan
+	cfg.redact = opts.Redact
+	cfg.outputAppend = opts.OutputAppend
+	cfg.checkRunsAsRoot = opts.CheckRunsAsRoot
+	cfg.dockerSave = opts.DockerSave
+	cfg.countMode = opts.CountMode
+	cfg.registryMirrors = opts.RegistryMirrors
+	cfg.podDetailFields = opts.PodDetailFields
+	cfg.keywordRulesPath = opts.KeywordRulesPath
+	cfg.groupBy = opts.GroupBy
+	cfg.failOnUnparseableRefs = opts.FailOnUnparseableRefs
+	cfg.debugAuth = opts.DebugAuth
+	cfg.decodeBase64 = opts.DecodeBase64
+	cfg.noPullIfPresent = opts.NoPullIfPresent
+	cfg.summaryOnly = opts.SummaryOnly
+	cfg.podEvents = opts.PodEvents
+	cfg.crdImageSourcesPath = opts.CRDImageSourcesPath
+	cfg.retryCleanup = opts.RetryCleanup
+	cfg.regexKeywords = opts.RegexKeywords
+	cfg.forcePull = opts.ForcePull
+	cfg.pullRetries = opts.PullRetries
+	cfg.includeNamespaces = opts.IncludeNamespaces
+	cfg.excludeNamespaces = opts.ExcludeNamespaces
+	cfg.dryRun = opts.DryRun
+	cfg.outputDir = opts.OutputDir
+	cfg.keepImages = opts.KeepImages
+	cfg.keepOffendingImages = opts.KeepOffendingImages
+	cfg.workloadTemplates = opts.WorkloadTemplates
+	cfg.pullTimeout = opts.PullTimeout
+	cfg.collapseReplicas = opts.CollapseReplicas
+	if cfg.skipImagePatterns, err = compileSkipImagePatterns(opts.SkipImages); err != nil {
+		return nil, err
+	}
+	if err := cfg.compileKeywordRegexps(cfg.dockerImageKeyWords); err != nil {
+		return nil, err
+	}
+	// The summary is the only output on stdout, so the findings are not printed
+	if cfg.summaryOnly {
+		cfg.findings = io.Discard
+	}
+	if len(opts.Workload) > 0 {
+		kind, name, err := ParseWorkload(opts.Workload)
 		if err != nil {
-			return nil, fmt.Errorf("marshalling ECR creds into JSON: %s", err)
+			return nil, err
 		}
-		cfg.ecrCredentials[region] = base64.StdEncoding.EncodeToString(jsonBytes)
+		cfg.workloadKind, cfg.workloadName = kind, name
+	}
+	cfg.maxImageSizeBytes = int64(opts.MaxImageSizeMB * 1024 * 1024)
+	cfg.maxDiskBytes = int64(opts.MaxDiskGB * (1 << 30))
+	cfg.localImageUsers = make(map[string]int)
+	cfg.errorCounts = make(map[string]int)
+	if cfg.remoteConcurrency < 1 {
+		cfg.remoteConcurrency = 1
+	}
+	if cfg.cleanupConcurrency < 1 {
+		cfg.cleanupConcurrency = 1
+	}
+	// Only one image is held in memory at a time in low memory mode, and the layer cache is not kept as it grows with every unique layer
+	if cfg.lowMemory {
+		cfg.concurrency, cfg.remoteConcurrency, cfg.cleanupConcurrency = 1, 1, 1
+		cfg.layerCache = nil
+	}
+	if len(cfg.timestampFormat) == 0 {
+		cfg.timestampFormat = DefaultTimestampFormat
+	}
+	if cfg.pullTimeout <= 0 {
+		cfg.pullTimeout = defaultPullTimeout
+	}
+	if err := ensureOutputDir(cfg.outputDir); err != nil {
+		return nil, err
+	}
+
+	// Auth is also needed for the override regions, even if no image's host is in them
+	for _, region := range cfg.ecrRegionOverrides {
+		if !sliceContains(cfg.ecrRegions, region) {
+			cfg.ecrRegions = append(cfg.ecrRegions, region)
+		}
+	}
+
+	// Get Docker login credentials via ECR API for each AWS region images are present in. Not needed if nothing is pulled
+	// Without any regions no AWS config is loaded and no AWS API calls are made until an ECR image is found
+	if cfg.nodeInventory {
+		cfg.imagesAccountAWSProfileName = ""
+	}
+	ecrAuthStart := time.Now()
+	for _, region := range cfg.ecrRegions {
+		if cfg.nodeInventory {
+			break
+		}
+		if _, err := cfg.refreshECRCredentials(ctx, region); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.ecrCredentials) > 0 {
+		cfg.timePhase(phaseECRAuth, ecrAuthStart)
 	}
 
-	// Docker client
-	dockerCli, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	dockerCli, err := newDockerClient(opts)
 	if err != nil {
-		return nil, fmt.Errorf("creating Docker client: %s", err)
+		return nil, err
 	}
 	cfg.dockerClient = dockerCli
 
-	// K8s client
-	k8sConfig, err := buildConfigWithContextFromFlags(clusterAccountProfile, filepath.Join(homedir.HomeDir(), ".kube", "config"))
+	cfg.registryTransport, err = newRegistryTransport(cfg.insecureRegistries, cfg.registryCAFile)
 	if err != nil {
-		return nil, fmt.Errorf("loading k8s config file: %s", err)
+		return nil, err
 	}
-	k8ClientSet, err := kubernetes.NewForConfig(k8sConfig)
+
+	k8ClientSet, err := newK8sClient(cfg.clusterK8sContextName, cfg.inCluster)
 	if err != nil {
-		return nil, fmt.Errorf("creating k8s client set: %s", err)
+		return nil, err
 	}
 	cfg.k8sClient = k8ClientSet
 
 	return cfg, nil
 }
 
+// newDockerClient returns a Docker client. Explicitly configured host and TLS settings take precedence over the DOCKER_* environment variables
+func newDockerClient(opts Options) (*dockerClient.Client, error) {
+	dockerOpts := []dockerClient.Opt{dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation()}
+	if len(opts.DockerHost) > 0 {
+		dockerOpts = append(dockerOpts, dockerClient.WithHost(opts.DockerHost))
+	}
+	if len(opts.DockerTLSCACert) > 0 || len(opts.DockerTLSCert) > 0 || len(opts.DockerTLSKey) > 0 {
+		dockerOpts = append(dockerOpts, dockerClient.WithTLSClientConfig(opts.DockerTLSCACert, opts.DockerTLSCert, opts.DockerTLSKey))
+	}
+	dockerCli, err := dockerClient.NewClientWithOpts(dockerOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating Docker client: %w", err)
+	}
+	return dockerCli, nil
+}
+
+// newK8sClient returns a K8s client set for the in-cluster service account, or for the context in ${HOME}/.kube/config
+func newK8sClient(contextName string, inCluster bool) (*kubernetes.Clientset, error) {
+	k8sConfig, err := newK8sRestConfig(contextName, inCluster)
+	if err != nil {
+		return nil, err
+	}
+	k8ClientSet, err := kubernetes.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating k8s client set: %w", err)
+	}
+	return k8ClientSet, nil
+}
+
 // buildConfigWithContextFromFlags returns a k8s client config which has overridden the context
 func buildConfigWithContextFromFlags(context string, kubeconfigPath string) (*rest.Config, error) {
 	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
@@ -149,13 +919,18 @@ func buildConfigWithContextFromFlags(context string, kubeconfigPath string) (*re
 		}).ClientConfig()
 }
 
+// resultsPath returns the path of a results file in the format '<prefix>-<k8s-context>-<timestamp>.<extension>', in the output directory
+func (c *Config) resultsPath(prefix, extension string) string {
+	return filepath.Join(c.outputDir, fmt.Sprintf("%s-%s-%s.%s", prefix, c.clusterK8sContextName, time.Now().Format(c.timestampFormat), extension))
+}
+
 // outputNonECRImages writes to a file all the container images in the cluster which are not stored in an AWS ECR registry
 func (c *Config) outputNonECRImages() error {
-	nonECRImageResultsPath := fmt.Sprintf("non-ecr-images-%s-%s.txt", c.clusterK8sContextName, time.Now().Format("2-Jan-2006-15:04"))
+	nonECRImageResultsPath := c.resultsPath("non-ecr-images", "txt")
 
-	f, err := os.OpenFile(nonECRImageResultsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := c.openResultsFile(nonECRImageResultsPath)
 	if err != nil {
-		return fmt.Errorf("opening file '%s': %s", nonECRImageResultsPath, err)
+		return err
 	}
 	defer func(f *os.File) {
 		err := f.Close()
@@ -164,16 +939,16 @@ func (c *Config) outputNonECRImages() error {
 		}
 	}(f)
 
-	for image, details := range c.dockerImages {
-		if !strings.Contains(image, "amazonaws.com") {
+	for _, image := range c.imageRefs() {
+		if !isECRImage(image) {
 			_, err := f.WriteString(fmt.Sprintf("%s\t", image))
-			for _, match := range details {
-				_, err = f.WriteString(fmt.Sprintf("(podName: %s, containerName: %s, namespace: %s) ", match.podName, match.containerName, match.namespace))
+			for _, match := range c.formatPods(c.dockerImages[image]) {
+				_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
 			}
 			_, err = f.WriteString("\n")
 
 			if err != nil {
-				return fmt.Errorf("writing results to '%s': %s", nonECRImageResultsPath, err)
+				return fmt.Errorf("writing results to '%s': %w", nonECRImageResultsPath, err)
 			}
 		}
 	}
@@ -184,12 +959,12 @@ func (c *Config) outputNonECRImages() error {
 
 // outputOffendingImages writes to a file all the container images in the cluster which have a history which have matched 1 or more keywords
 func (c *Config) outputOffendingImages() error {
-	offendingImageResultsPath := fmt.Sprintf("offending-images-%s-%s.txt", c.clusterK8sContextName, time.Now().Format("2-Jan-2006-15:04"))
+	offendingImageResultsPath := c.resultsPath("offending-images", "txt")
 
 	if len(c.offendingDockerImages) > 0 {
-		f, err := os.OpenFile(offendingImageResultsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f, err := c.openResultsFile(offendingImageResultsPath)
 		if err != nil {
-			return fmt.Errorf("opening file '%s': %s", offendingImageResultsPath, err)
+			return err
 		}
 		defer func(f *os.File) {
 			err := f.Close()
@@ -201,105 +976,226 @@ func (c *Config) outputOffendingImages() error {
 		for _, i := range c.offendingDockerImages {
 			details := c.dockerImages[i.imageRef]
 			_, err = f.WriteString(fmt.Sprintf("%s\t", i.imageRef))
-			for _, match := range details {
-				_, err = f.WriteString(fmt.Sprintf("(podName: %s, containerName: %s, namespace: %s, matched-keywords: %v) ", match.podName, match.containerName, match.namespace, i.matchedKeywords))
+			for _, match := range c.formatPods(details) {
+				_, err = f.WriteString(fmt.Sprintf("(%s, matched-keywords: %v, absent-keywords: %v, matched-rules: %v, decoded-keywords: %v, matched-instructions: %v, matched-metadata: %v, match-context: %q, matched-layers: %q) ", match, i.matchedKeywords, i.absentKeywords, i.matchedRules, i.decodedKeywords, i.matchedInstructions, i.matchedMetadata, i.matchContexts, formatLayerMatches(i.matchedLayers)))
 			}
 			_, err = f.WriteString("\n")
 			if err != nil {
-				return fmt.Errorf("writing results to '%s': %s", offendingImageResultsPath, err)
+				return fmt.Errorf("writing results to '%s': %w", offendingImageResultsPath, err)
 			}
 		}
 		log.Printf("Offending image results written to: %s", offendingImageResultsPath)
 
 	} else {
-		fmt.Println("No images matched keywords. Nothing to output.")
+		log.Println("No images matched keywords. Nothing to output.")
 	}
 
 	return nil
 }
 
 // queryAllContainerImageRefsInCluster queries for all the containers running as pods in the cluster and stores them in the Config for later processing
-func (c *Config) queryAllContainerImageRefsInCluster() error {
-	pods, err := c.k8sClient.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{})
+// If a namespace, or included namespaces, are set only the pods in those namespaces are queried, which only requires namespaced RBAC
+// permissions. Pods in excluded namespaces are dropped
+func (c *Config) queryAllContainerImageRefsInCluster(ctx context.Context) error {
+	pods, err := c.listPods(ctx)
 	if err != nil {
-		return fmt.Errorf("querying for all k8s pods: %s", err)
+		return err
 	}
-	log.Printf("Number of pods discovered in cluster: %d\n", len(pods.Items))
+	c.discovery.podsSeen += len(pods)
+
+	for _, pod := range pods {
+		workloadKind, workloadName := podWorkload(pod)
+
+		// The digest actually running is only known from the container statuses, which are keyed by container name
+		imageIDs := make(map[string]string)
+		for _, status := range pod.Status.ContainerStatuses {
+			imageIDs[status.Name] = imageDigest(status.ImageID)
+		}
+		for _, status := range pod.Status.InitContainerStatuses {
+			imageIDs[status.Name] = imageDigest(status.ImageID)
+		}
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			imageIDs[status.Name] = imageDigest(status.ImageID)
+		}
+
+		podIncluded := false
+		for _, container := range podContainers(pod.Spec) {
+			c.discovery.containersSeen++
+			if len(container.image) == 0 {
+				continue
+			}
 
-	for _, pod := range pods.Items {
-		for _, container := range pod.Spec.Containers {
 			pd := podDetails{
 				podName:       pod.Name,
-				containerName: container.Name,
+				containerName: container.name,
+				containerType: container.containerType,
 				namespace:     pod.Namespace,
+				workloadKind:  workloadKind,
+				workloadName:  workloadName,
+				imageDigest:   imageIDs[container.name],
+				nodeName:      pod.Spec.NodeName,
+				podUID:        string(pod.UID),
+				historical:    isTerminatedPod(pod),
+			}
+			if _, seen := c.dockerImages[container.image]; !seen {
+				if reason, mutable := mutableTag(container.image); mutable {
+					c.mutableTagImages[container.image] = reason
+				}
 			}
-			c.dockerImages[container.Image] = append(c.dockerImages[container.Image], pd)
+			c.dockerImages[container.image] = append(c.dockerImages[container.image], pd)
+			c.discovery.containersIncluded++
+			podIncluded = true
+		}
+		if podIncluded {
+			c.discovery.podsIncluded++
 		}
 	}
-	log.Printf("Number of unique container image refs: %d", len(c.dockerImages))
+	c.logDiscoverySummary()
 
 	return nil
 }
 
+// logDiscoverySummary logs how many pods and containers were found in the cluster and how many of them contributed images to the scan
+func (c *Config) logDiscoverySummary() {
+	log.Printf("Discovery summary: pods seen: %d, pods included: %d, containers seen: %d, containers included: %d, unique images: %d",
+		c.discovery.podsSeen, c.discovery.podsIncluded, c.discovery.containersSeen, c.discovery.containersIncluded, len(c.dockerImages))
+}
+
+// podWorkload returns the kind and name of the workload which owns the pod. Pods owned by a ReplicaSet are attributed to their Deployment
+// Pods without an owner are attributed to themselves
+func podWorkload(pod corev1.Pod) (string, string) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return "Pod", pod.Name
+	}
+
+	if hash, ok := pod.Labels["pod-template-hash"]; ok && owner.Kind == "ReplicaSet" {
+		return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+	}
+	return owner.Kind, owner.Name
+}
+
+// imageDigest extracts the 'sha256:<hex>' digest from a container status image ID such as 'docker-pullable://repo@sha256:<hex>'
+// Returns an empty string if the container has not yet started and no image ID has been reported
+func imageDigest(imageID string) string {
+	i := strings.LastIndex(imageID, "sha256:")
+	if i < 0 {
+		return ""
+	}
+	return imageID[i:]
+}
+
 // checkImageHistoryForKeyWords checks the history single Docker image for a set of keywords
 // Returns offendingDockerImage which includes whether a match has been found, and details of the matches if so
-func (c *Config) checkImageHistoryForKeyWords(imageRef string) (offendingDockerImage, error) {
-	var result offendingDockerImage
-	result.matchedKeywords = make(map[string]int)
-
-	history, err := c.dockerClient.ImageHistory(context.Background(), imageRef)
+func (c *Config) checkImageHistoryForKeyWords(ctx context.Context, imageRef string) (offendingDockerImage, error) {
+	var entries []historyEntry
+	var err error
+	if c.dockerSave {
+		entries, err = c.savedImageHistory(ctx, imageRef)
+	} else {
+		entries, err = localImageHistory(ctx, c.dockerClient, imageRef)
+	}
 	if err != nil {
-		return result, fmt.Errorf("querying image history for '%s': %s", imageRef, err)
+		return offendingDockerImage{}, err
 	}
 
-	for _, h := range history {
-		for _, keyword := range c.dockerImageKeyWords {
-			if strings.Contains(strings.ToLower(h.CreatedBy), strings.ToLower(keyword)) {
-				result.matchFound = true
-				result.imageRef = imageRef
-				result.matchedKeywords[keyword]++
-				fmt.Printf("FOUND: %+v\n", result)
-			}
-		}
-	}
-	return result, nil
+	return c.matchHistoryForKeyWords(imageRef, entries)
 }
 
-// pullImage pulls a single Docker image using the local Docker instance. Credentials are passed if it's an ECR registry
-func (c *Config) pullImage(imageReference string) error {
-	// Only pass the Docker credentials if it's an ECR registry. Credentials differ per AWS region
+// pullImage pulls a single Docker image using the local Docker instance, from its registry's mirror if it has one
+// The credentials are selected by the registry host pulled from, see registryAuth
+func (c *Config) pullImage(ctx context.Context, imageReference string) error {
 	var pullOptions types.ImagePullOptions
-
-	if strings.Contains(imageReference, "amazonaws.com") {
-		foundRegion := false
-		for _, region := range c.ecrRegions {
-			if strings.Contains(imageReference, fmt.Sprintf("dkr.ecr.%s.amazonaws.com", region)) {
-				pullOptions.RegistryAuth = c.ecrCredentials[region]
-				foundRegion = true
-			}
-		}
-		if !foundRegion {
-			return fmt.Errorf("unsupported ECR image region detected. Currently supported: %v", c.ecrRegions)
+	mirroredRef, mirrored := c.mirroredRef(imageReference)
+	if !mirrored {
+		auth, err := c.registryAuth(ctx, imageReference)
+		if err != nil {
+			return err
 		}
+		pullOptions.RegistryAuth = auth
+		return c.pullImageFrom(ctx, imageReference, imageReference, pullOptions)
 	}
 
-	events, err := c.dockerClient.ImagePull(context.Background(), imageReference, pullOptions)
+	auth, err := c.registryAuth(ctx, mirroredRef)
+	if err != nil {
+		return err
+	}
+	pullOptions.RegistryAuth = auth
+	if err = c.pullImageFrom(ctx, imageReference, mirroredRef, pullOptions); err != nil {
+		return err
+	}
+	return c.retagMirroredImage(ctx, mirroredRef, imageReference)
+}
+
+// defaultPullTimeout is how long a single pull can take before it is cancelled as stalled, unless PullTimeout is set
+const defaultPullTimeout = 10 * time.Minute
+
+// pullTimeoutError is returned when a pull is cancelled as it took longer than the pull timeout
+type pullTimeoutError struct {
+	imageRef string
+	timeout  time.Duration
+}
+
+func (e *pullTimeoutError) Error() string {
+	return fmt.Sprintf("timed out (%s) whilst attempting to download %s", e.timeout, e.imageRef)
+}
+
+// pullImageOnce makes a single attempt to pull an image from pullRef, and waits for the pull to complete
+// Stalled downloads are cancelled after the pull timeout
+func (c *Config) pullImageOnce(ctx context.Context, imageReference, pullRef string, pullOptions types.ImagePullOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, c.pullTimeout)
+	defer cancel()
+
+	events, err := c.dockerClient.ImagePull(ctx, pullRef, pullOptions)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &pullTimeoutError{imageRef: imageReference, timeout: c.pullTimeout}
+	}
+	if err != nil && isImageNotFound(err) {
+		return &imageNotFoundError{imageRef: imageReference, err: err}
+	}
 	if err != nil {
-		return fmt.Errorf("pulling image '%s': %s", imageReference, err)
+		return fmt.Errorf("pulling image '%s': %w", imageReference, err)
 	}
 
+	defer func() { _ = events.Close() }()
+
+	return waitForPull(ctx, events, imageReference, c.pullTimeout)
+}
+
+// waitForPull reads the JSON progress events of an image pull until the pull completes, which is either a final status or the end of
+// the stream. An error reported by the registry part way through the pull is returned as an error
+func waitForPull(ctx context.Context, events io.Reader, imageReference string, timeout time.Duration) error {
 	d := json.NewDecoder(events)
-	var event *Event
-	timeout := time.Now().Add(time.Minute * 10) // cancel stalled downloads
 	for {
-		if time.Now().After(timeout) {
-			return fmt.Errorf("timed out (10 minutes) whilst attempting to download %s", imageReference)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &pullTimeoutError{imageRef: imageReference, timeout: timeout}
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("pulling image '%s': %w", imageReference, err)
 		}
 
+		// A fresh event each time, so a 'null' line or a line without some fields never leaves the previous event's values behind
+		var event *Event
 		if err := d.Decode(&event); err != nil {
-			if err != io.EOF {
-				return fmt.Errorf("decoding Docker image pull JSON output: %s", err)
+			if err == io.EOF {
+				// The daemon closes the stream once the pull has finished, and reports any failure as an error event beforehand
+				return nil
 			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return &pullTimeoutError{imageRef: imageReference, timeout: timeout}
+			}
+			return fmt.Errorf("decoding Docker image pull JSON output: %w", err)
+		}
+		if event == nil {
+			continue
+		}
+
+		if len(event.Error) > 0 {
+			err := errors.New(event.Error)
+			if isImageNotFound(err) {
+				return &imageNotFoundError{imageRef: imageReference, err: err}
+			}
+			return fmt.Errorf("pulling image '%s': %w", imageReference, err)
 		}
 
 		// wait until the image is downloaded
@@ -309,13 +1205,26 @@ func (c *Config) pullImage(imageReference string) error {
 	}
 }
 
-// cleanupImage removes a single Docker image from the local cache
-func (c *Config) cleanupImage(imageReference string) error {
-	_, err := c.dockerClient.ImageRemove(context.Background(), imageReference, types.ImageRemoveOptions{Force: true, PruneChildren: true})
-	if err != nil {
-		return fmt.Errorf("cleaning up local image '%s': %s", imageReference, err)
+// isECRImage returns whether the image is stored in an AWS ECR registry
+func isECRImage(imageReference string) bool {
+	return strings.Contains(imageReference, "amazonaws.com")
+}
+
+// ecrRegionForImage returns the ECR region the image is stored in, parsed from its host, and checks its credentials are available
+// A matching region override takes precedence over the region in the image's host. Regions which were not configured are
+// authenticated on demand. Returns an unconfiguredECRRegionError if the region cannot be parsed or authenticated
+func (c *Config) ecrRegionForImage(ctx context.Context, imageReference string) (string, error) {
+	region, ok := c.ecrRegionOverride(imageReference)
+	if !ok {
+		region, ok = parseECRRegion(imageReference)
 	}
-	return nil
+	if !ok {
+		return "", &unconfiguredECRRegionError{imageRef: imageReference, configured: c.ecrRegions}
+	}
+	if _, err := c.ecrCredentialsForRegion(ctx, region); err != nil {
+		return "", &unconfiguredECRRegionError{imageRef: imageReference, region: region, configured: c.ecrRegions, cause: err}
+	}
+	return region, nil
 }
 
 // ValidateAWSRegions validates whether all the regions are valid AWS region codes
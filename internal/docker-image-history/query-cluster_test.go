@@ -0,0 +1,311 @@
+package docker_image_history
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+func TestWaitForPull(t *testing.T) {
+	tests := []struct {
+		name         string
+		stream       string
+		wantErr      string
+		wantNotFound bool
+	}{
+		{
+			name: "clean stream",
+			stream: `{"status":"Pulling from library/app","id":"1.0"}
+{"status":"Downloading","progressDetail":{"current":512,"total":1024},"id":"abc"}
+{"status":"Pull complete","id":"abc"}
+{"status":"Status: Downloaded newer image for app:1.0"}
+`,
+		},
+		{
+			name:   "stream ending without a final status",
+			stream: `{"status":"Pull complete","id":"abc"}` + "\n",
+		},
+		{
+			name:   "null events are skipped",
+			stream: "null\n" + `{"status":"Status: Image is up to date for app:1.0"}` + "\n",
+		},
+		{
+			name: "error event",
+			stream: `{"status":"Downloading","id":"abc"}
+{"errorDetail":{"message":"unexpected EOF"},"error":"unexpected EOF"}
+`,
+			wantErr: "pulling image 'app:1.0': unexpected EOF",
+		},
+		{
+			name:         "not found error event",
+			stream:       `{"error":"manifest for app:1.0 not found: manifest unknown"}` + "\n",
+			wantErr:      "manifest unknown",
+			wantNotFound: true,
+		},
+		{
+			name:    "truncated stream",
+			stream:  `{"status":"Pull complete","id":"abc"}` + "\n" + `{"status":"Downlo`,
+			wantErr: "decoding Docker image pull JSON output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := waitForPull(context.Background(), strings.NewReader(tt.stream), "app:1.0", time.Minute)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("expected the pull to complete, got: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing '%s', got: %v", tt.wantErr, err)
+			}
+			var notFound *imageNotFoundError
+			if errors.As(err, &notFound) != tt.wantNotFound {
+				t.Errorf("expected image not found to be %t, got: %v", tt.wantNotFound, err)
+			}
+		})
+	}
+}
+
+// stalledPullStream is a pull progress stream which stops sending events, as when a download stalls, until the pull is cancelled
+// onCancel is called once the pull has been cancelled, before the read fails
+type stalledPullStream struct {
+	ctx      context.Context
+	onCancel func()
+}
+
+func (s *stalledPullStream) Read([]byte) (int, error) {
+	<-s.ctx.Done()
+	if s.onCancel != nil {
+		s.onCancel()
+	}
+	return 0, s.ctx.Err()
+}
+
+func TestScanImageLocallyPullTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// completesOnCancel creates the image as the pull is cancelled, as when the last layer finishes just as the pull times out
+		completesOnCancel bool
+		wantRemoved       bool
+	}{
+		{name: "partial pull is discarded by the daemon"},
+		{name: "image completed as the pull timed out is removed", completesOnCancel: true, wantRemoved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+			const image = "registry.example.com/app:1.0"
+
+			client := newFakeImageClient(nil)
+			client.pull = func(ctx context.Context, ref string) (io.ReadCloser, error) {
+				stalled := &stalledPullStream{ctx: ctx}
+				if tt.completesOnCancel {
+					stalled.onCancel = func() { client.addImage(ref, "sha256:app", 1024) }
+				}
+				return io.NopCloser(io.MultiReader(strings.NewReader(`{"status":"Downloading","id":"abc"}`+"\n"), stalled)), nil
+			}
+			c := newTestConfig(client, "curl")
+			c.dockerImages[image] = []podDetails{{podName: "app-0", namespace: "default"}}
+			c.pullTimeout = 50 * time.Millisecond
+
+			if err := c.scanImageLocally(context.Background(), image, nil); err != nil {
+				t.Fatalf("expected the timed out pull not to abort the scan, got: %s", err)
+			}
+
+			if len(c.scanErrors) != 1 || c.scanErrors[0].imageRef != image || c.scanErrors[0].stage != scanStagePull {
+				t.Fatalf("expected a single pull scan error for '%s', got %+v", image, c.scanErrors)
+			}
+			var timeoutErr *pullTimeoutError
+			if !errors.As(c.scanErrors[0].err, &timeoutErr) {
+				t.Errorf("expected a pull timeout error, got: %s", c.scanErrors[0].err)
+			}
+			removed := len(client.removedRefs()) == 1 && client.removedRefs()[0] == image
+			if removed != tt.wantRemoved {
+				t.Errorf("expected removed to be %t, got the removed refs %v", tt.wantRemoved, client.removedRefs())
+			}
+			if c.localImagePresent(context.Background(), image) {
+				t.Error("expected the image not to be left in the local Docker instance")
+			}
+			if len(c.offendingDockerImages) > 0 {
+				t.Errorf("expected no results for an image which was not pulled, got %+v", c.offendingDockerImages)
+			}
+		})
+	}
+}
+
+func TestScanImageLocallyAlreadyPresent(t *testing.T) {
+	tests := []struct {
+		name            string
+		image           string
+		present         bool
+		noPullIfPresent bool
+		wantPulled      bool
+		wantRemoved     bool
+	}{
+		{name: "pinned tag already present is used as is", image: "registry.example.com/app:1.0", present: true},
+		{name: "mutable tag already present is pulled again but kept", image: "registry.example.com/app:latest", present: true, wantPulled: true},
+		{name: "mutable tag with noPullIfPresent is used as is", image: "registry.example.com/app:latest", present: true, noPullIfPresent: true},
+		{name: "image not present is pulled and removed", image: "registry.example.com/app:latest", wantPulled: true, wantRemoved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+			client := newFakeImageClient(map[string][]image.HistoryResponseItem{
+				tt.image: {{ID: "sha256:top", CreatedBy: "/bin/sh -c curl -sSL https://example.com/install.sh | sh"}},
+			})
+			pulls := 0
+			client.pull = func(_ context.Context, ref string) (io.ReadCloser, error) {
+				pulls++
+				client.addImage(ref, "sha256:app", 1024)
+				return io.NopCloser(strings.NewReader(`{"status":"Status: Downloaded newer image"}`)), nil
+			}
+			if tt.present {
+				client.addImage(tt.image, "sha256:app", 1024)
+			}
+			c := newTestConfig(client, "curl")
+			c.noPullIfPresent = tt.noPullIfPresent
+			c.dockerImages[tt.image] = []podDetails{{podName: "app-0", namespace: "default"}}
+
+			if err := c.scanImageLocally(context.Background(), tt.image, nil); err != nil {
+				t.Fatalf("scanning image: %s", err)
+			}
+			if (pulls > 0) != tt.wantPulled {
+				t.Errorf("expected pulled to be %t, got %d pulls", tt.wantPulled, pulls)
+			}
+			if removed := len(client.removedRefs()) > 0; removed != tt.wantRemoved {
+				t.Errorf("expected removed to be %t, got the removed refs %v", tt.wantRemoved, client.removedRefs())
+			}
+			if len(c.offendingDockerImages) != 1 {
+				t.Errorf("expected the image to be scanned and match, got %+v", c.offendingDockerImages)
+			}
+		})
+	}
+}
+
+func TestRecordResultSameImageInTwoNamespaces(t *testing.T) {
+	const image = "registry.example.com/app:1.0"
+	c := newTestConfig(nil, "curl", "wget")
+	c.dockerImages[image] = []podDetails{
+		{podName: "app-0", containerName: "app", namespace: "team-a"},
+		{podName: "app-0", containerName: "app", namespace: "team-b"},
+	}
+
+	// The same image is recorded once per namespace, as when it is found again by a workload or another discovery source
+	c.recordResult(offendingDockerImage{imageRef: image, matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+	c.recordResult(offendingDockerImage{imageRef: image, matchFound: true, matchedKeywords: map[string]int{"curl": 1, "wget": 2}})
+
+	if len(c.offendingDockerImages) != 1 {
+		t.Fatalf("expected exactly one offending entry, got %d: %+v", len(c.offendingDockerImages), c.offendingDockerImages)
+	}
+	if got := c.offendingDockerImages[0].matchedKeywords; got["curl"] != 1 || got["wget"] != 2 {
+		t.Errorf("expected the matches to be merged without summing, got %v", got)
+	}
+
+	report := c.buildJSONReport()
+	if len(report.OffendingImages) != 1 {
+		t.Fatalf("expected exactly one offending image in the JSON results, got %d", len(report.OffendingImages))
+	}
+	namespaces := make(map[string]bool)
+	for _, pod := range report.OffendingImages[0].Pods {
+		namespaces[pod.Namespace] = true
+	}
+	if !namespaces["team-a"] || !namespaces["team-b"] {
+		t.Errorf("expected the offending image to be attributed to both namespaces, got %+v", report.OffendingImages[0].Pods)
+	}
+}
+
+func TestScanImagesLocallyHistoryError(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	const broken = "registry.example.com/broken:1.0"
+	curl := []image.HistoryResponseItem{{ID: "<missing>", CreatedBy: "/bin/sh -c curl -sSL https://example.com"}}
+	client := newFakeImageClient(map[string][]image.HistoryResponseItem{
+		"registry.example.com/app:1.0": curl,
+		"registry.example.com/web:1.0": curl,
+	})
+	client.historyErrs = map[string]error{broken: errors.New("layer does not exist")}
+	c := newTestConfig(client, "curl")
+	c.concurrency = 2
+	for _, ref := range []string{"registry.example.com/app:1.0", broken, "registry.example.com/web:1.0"} {
+		c.dockerImages[ref] = []podDetails{{podName: "pod", namespace: "default"}}
+	}
+
+	if err := c.scanImagesLocally(context.Background(), c.imageRefs()); err != nil {
+		t.Fatalf("expected the scan to complete, got: %s", err)
+	}
+
+	if len(c.scanErrors) != 1 || c.scanErrors[0].imageRef != broken || c.scanErrors[0].stage != scanStageInspect {
+		t.Errorf("expected a single inspect scan error for '%s', got %+v", broken, c.scanErrors)
+	}
+	if len(c.offendingDockerImages) != 2 {
+		t.Errorf("expected the other 2 images to be scanned, got %+v", c.offendingDockerImages)
+	}
+	if removed := client.removedRefs(); len(removed) != 3 {
+		t.Errorf("expected all 3 pulled images to be removed, including the one which failed, got %v", removed)
+	}
+}
+
+// kubeconfigForTest is a kubeconfig with a single 'test' context. The server is never contacted when creating a client
+const kubeconfigForTest = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+  user:
+    token: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+
+func TestNewConfigWithoutECRRegions(t *testing.T) {
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, ".kube"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".kube", "config"), []byte(kubeconfigForTest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	// Any AWS call fails, as there are no credentials and the context is cancelled, so the config only loads if none are made
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, "aws-credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	opts := Options{
+		DockerImageKeyWords:         []string{"curl"},
+		ClusterK8sContextName:       "test",
+		ImagesAccountAWSProfileName: "missing-profile",
+		Stdout:                      io.Discard,
+		Stderr:                      io.Discard,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg, err := NewConfig(ctx, opts)
+	if err != nil {
+		t.Fatalf("expected the config to load without any AWS calls, got: %s", err)
+	}
+	if len(cfg.ecrCredentials) > 0 {
+		t.Errorf("expected no ECR credentials, got them for %d regions", len(cfg.ecrCredentials))
+	}
+
+	// The same options with a region do make an AWS call, which fails
+	opts.ECRRegions = []string{"eu-west-1"}
+	if _, err = NewConfig(ctx, opts); err == nil || !strings.Contains(err.Error(), "getting ECR auth token") {
+		t.Errorf("expected the ECR auth token request to fail once a region is configured, got: %v", err)
+	}
+}
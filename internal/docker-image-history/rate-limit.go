@@ -0,0 +1,66 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// pullRateLimitAttempts is the number of times an image is pulled whilst the registry is rate limiting pulls before giving up
+const pullRateLimitAttempts = 5
+
+// pullRateLimitRetryDelay is the delay before the first retry of a rate limited pull. It doubles after each rate limited attempt
+// The Docker daemon does not pass on the registry's Retry-After header in its pull errors, so the wait cannot be taken from the registry
+const pullRateLimitRetryDelay = 30 * time.Second
+
+// isRateLimited returns whether a pull failed because the registry is rate limiting pulls, e.g. Docker Hub's toomanyrequests
+func isRateLimited(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "toomanyrequests") || strings.Contains(msg, "429 too many requests")
+}
+
+// pullImageFrom pulls an image from pullRef, which is either the image ref itself or its mirror, retrying whilst the registry is
+// rate limiting pulls, backing off exponentially
+// Other transient failures are retried up to pullRetries times with exponential backoff and jitter. Permanent failures are not retried
+func (c *Config) pullImageFrom(ctx context.Context, imageReference, pullRef string, pullOptions types.ImagePullOptions) error {
+	rateLimitDelay, retryDelay := pullRateLimitRetryDelay, pullRetryDelay
+	rateLimitedAttempts, retries := 0, 0
+	for {
+		err := c.pullImageOnce(ctx, imageReference, pullRef, pullOptions)
+		if err == nil || isPermanentPullError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		var wait time.Duration
+		if isRateLimited(err) {
+			rateLimitedAttempts++
+			if rateLimitedAttempts == pullRateLimitAttempts {
+				return fmt.Errorf("still rate limited after %d attempts: %w", pullRateLimitAttempts, err)
+			}
+
+			wait = rateLimitDelay
+			rateLimitDelay *= 2
+			log.Printf("pulling '%s' was rate limited (attempt %d / %d), retrying in %s: %s", imageReference, rateLimitedAttempts, pullRateLimitAttempts, wait, err)
+		} else {
+			if retries == c.pullRetries {
+				if retries > 0 {
+					return fmt.Errorf("still failing after %d retries: %w", retries, err)
+				}
+				return err
+			}
+			retries++
+			wait = withJitter(retryDelay)
+			retryDelay *= 2
+			log.Printf("pulling '%s' failed (retry %d / %d), retrying in %s: %s", imageReference, retries, c.pullRetries, wait.Round(time.Millisecond), err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pulling image '%s': %w", imageReference, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package docker_image_history
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestIsRateLimited(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Docker Hub pull rate limit",
+			err:  errors.New("Error response from daemon: toomanyrequests: You have reached your pull rate limit. You may increase the limit by authenticating and upgrading: https://www.docker.com/increase-rate-limit"),
+			want: true,
+		},
+		{
+			name: "registry 429 response",
+			err:  errors.New("Error response from daemon: Head \"https://registry.example.com/v2/app/manifests/1.0\": 429 Too Many Requests"),
+			want: true,
+		},
+		{name: "missing manifest", err: errors.New("Error response from daemon: manifest unknown: manifest unknown")},
+		{name: "registry 503 response", err: errors.New("Error response from daemon: received unexpected HTTP status: 503 Service Unavailable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRateLimited(tt.err); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPullImageFromRateLimitedCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFakeImageClient(nil)
+	client.pull = func(context.Context, string) (io.ReadCloser, error) {
+		// Cancelled whilst the pull is rate limited, so the scan does not sit out the backoff
+		cancel()
+		return nil, errors.New("Error response from daemon: toomanyrequests: You have reached your pull rate limit")
+	}
+	c := newTestConfig(client)
+
+	err := c.pullImageFrom(ctx, "app:1.0", "app:1.0", types.ImagePullOptions{})
+	if err == nil {
+		t.Fatal("expected the rate limited pull to fail once cancelled")
+	}
+}
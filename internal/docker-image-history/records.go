@@ -0,0 +1,36 @@
+package docker_image_history
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// lockedWriter serialises writes to an underlying writer, so lines printed concurrently by the scan workers are never interleaved
+// Each line must be written with a single call to Write, e.g. a single fmt.Fprintf
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write implements io.Writer
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// recordFile is a file which records are appended to as they are produced by the scan workers
+// Each record is written whole with a single locked write, so records from different workers are never interleaved or torn
+type recordFile struct {
+	mu sync.Mutex
+	*os.File
+}
+
+// writeRecord writes a single record followed by a newline
+func (f *recordFile) writeRecord(record []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.Write(append(record, '\n'))
+	return err
+}
@@ -0,0 +1,150 @@
+package docker_image_history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// These tests exercise the writers from many goroutines at once. Run them with 'go test -race' to also check for data races
+
+func TestRecordFileConcurrentWrites(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "records.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := &recordFile{File: f}
+
+	const writers, recordsPerWriter = 16, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < recordsPerWriter; i++ {
+				// Records larger than a pipe buffer are the ones most likely to be torn by unsynchronised writes
+				line, _ := json.Marshal(checkpointEntry{ImageRef: fmt.Sprintf("app-%d:%d", w, i), AbsentKeywords: []string{strings.Repeat("x", 8192)}})
+				if err := records.writeRecord(line); err != nil {
+					t.Errorf("writing record: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err = records.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readRecordLines(t, f.Name())
+	if len(entries) != writers*recordsPerWriter {
+		t.Errorf("expected %d records, got %d", writers*recordsPerWriter, len(entries))
+	}
+}
+
+func TestLockedWriterConcurrentWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := &lockedWriter{w: &buf}
+
+	const writers, linesPerWriter = 16, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < linesPerWriter; j++ {
+				_, _ = fmt.Fprintf(w, "FOUND: {imageRef:app-%d:%d}\n", i, j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != writers*linesPerWriter {
+		t.Fatalf("expected %d lines, got %d", writers*linesPerWriter, len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "FOUND: {") || !strings.HasSuffix(line, "}") || strings.Count(line, "FOUND") != 1 {
+			t.Errorf("interleaved line: %q", line)
+		}
+	}
+}
+
+func TestConcurrentScanRecords(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	const images = 40
+	histories := make(map[string][]image.HistoryResponseItem)
+	client := newFakeImageClient(histories)
+	var findings bytes.Buffer
+	c := newTestConfig(client, "curl")
+	c.findings = &lockedWriter{w: &findings}
+	c.concurrency = 8
+	c.resumeFrom = filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	for i := 0; i < images; i++ {
+		ref := fmt.Sprintf("registry.example.com/app-%d:1.0", i)
+		histories[ref] = []image.HistoryResponseItem{{ID: "<missing>", CreatedBy: fmt.Sprintf("/bin/sh -c curl -o /tmp/%d https://example.com/%d", i, i)}}
+		c.dockerImages[ref] = []podDetails{{podName: fmt.Sprintf("app-%d", i), namespace: "default"}}
+	}
+
+	if err := c.openCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.scanImagesLocally(context.Background(), c.imageRefs()); err != nil {
+		t.Fatalf("scanning images: %s", err)
+	}
+	c.closeCheckpoint()
+
+	if len(c.offendingDockerImages) != images {
+		t.Errorf("expected %d offending images, got %d", images, len(c.offendingDockerImages))
+	}
+	entries := readRecordLines(t, c.resumeFrom)
+	// The first line is the header
+	if len(entries) != images+1 {
+		t.Errorf("expected the header and %d checkpointed images, got %d lines", images, len(entries))
+	}
+	lines := strings.Split(strings.TrimSuffix(findings.String(), "\n"), "\n")
+	if len(lines) != images {
+		t.Fatalf("expected %d findings, got %d", images, len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "FOUND: {") || strings.Count(line, "FOUND") != 1 {
+			t.Errorf("interleaved finding: %q", line)
+		}
+	}
+}
+
+// readRecordLines reads a JSON lines file, failing the test if any line does not parse
+func readRecordLines(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("unparseable line %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
@@ -0,0 +1,121 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// redactedText replaces secret values in the results when redact is set
+const redactedText = "[REDACTED]"
+
+// Tokens at least this long, with at least this much Shannon entropy per character, are treated as likely secrets when redacting
+const (
+	minSecretTokenLength   = 16
+	secretEntropyThreshold = 3.5
+)
+
+// isTokenDelimiter returns whether a character separates tokens in a history command, e.g. 'KEY=value' or '--token "value"'
+func isTokenDelimiter(r byte) bool {
+	return strings.IndexByte(" \t\n\r\"'`=:,;&|()<>[]{}", r) >= 0
+}
+
+// redactedMatchContext returns the context of a match with the token containing the match, and any other high entropy tokens, masked
+// The context starts with the offset of the match in the text, so it can still be located without revealing the secret
+func redactedMatchContext(text string, start, end, n int) string {
+	redacted, matchEnd := redactMatchedToken(text, start, end)
+	return fmt.Sprintf("offset %d: %s", start, matchContext(redacted, matchEnd-len(redactedText), matchEnd, n))
+}
+
+// redactMatchedToken masks the token containing the match between start and end, along with any other high entropy tokens. A value
+// assigned to the token (e.g. 'password=value' or 'token: value') is masked too, as matching the name of a secret is as sensitive as its value
+// Returns the redacted text and the offset in it of the end of the masked match
+func redactMatchedToken(text string, start, end int) (string, int) {
+	tokenStart, tokenEnd := start, end
+	for tokenStart > 0 && !isTokenDelimiter(text[tokenStart-1]) {
+		tokenStart--
+	}
+	for tokenEnd < len(text) && !isTokenDelimiter(text[tokenEnd]) {
+		tokenEnd++
+	}
+
+	redacted := redactHighEntropyTokens(text[:tokenStart]) + redactedText
+	matchEnd := len(redacted)
+	if tokenEnd < len(text) && (text[tokenEnd] == '=' || text[tokenEnd] == ':') {
+		valueStart := tokenEnd + 1
+		for valueStart < len(text) && (text[valueStart] == ' ' || text[valueStart] == '"' || text[valueStart] == '\'') {
+			valueStart++
+		}
+		valueEnd := valueStart
+		for valueEnd < len(text) && !isTokenDelimiter(text[valueEnd]) {
+			valueEnd++
+		}
+		if valueEnd > valueStart {
+			redacted += text[tokenEnd:valueStart] + redactedText
+			tokenEnd = valueEnd
+		}
+	}
+	return redacted + redactHighEntropyTokens(text[tokenEnd:]), matchEnd
+}
+
+// redactHighEntropyTokens masks every token in the text which looks like a secret
+func redactHighEntropyTokens(text string) string {
+	return redactTokens(text, isHighEntropyToken)
+}
+
+// redactBase64Tokens masks every token in the text which looks like a secret, or is base64 encoded text which may hide one
+func redactBase64Tokens(text string) string {
+	return redactTokens(text, func(token string) bool {
+		_, decoded := decodeBase64Token(token)
+		return decoded || isHighEntropyToken(token)
+	})
+}
+
+// isHighEntropyToken returns whether a token is long and random enough to look like a secret
+func isHighEntropyToken(token string) bool {
+	return len(token) >= minSecretTokenLength && shannonEntropy(token) >= secretEntropyThreshold
+}
+
+// redactTokens masks every token in the text for which secret returns true
+func redactTokens(text string, secret func(token string) bool) string {
+	var b strings.Builder
+	tokenStart := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && !isTokenDelimiter(text[i]) {
+			continue
+		}
+		token := text[tokenStart:i]
+		if secret(token) {
+			token = redactedText
+		}
+		b.WriteString(token)
+		if i < len(text) {
+			b.WriteByte(text[i])
+		}
+		tokenStart = i + 1
+	}
+	return b.String()
+}
+
+// shannonEntropy returns the Shannon entropy of a string in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// redactMetadata masks the value of a matched annotation or label (e.g. 'label key=value'), keeping which annotation or label matched
+func redactMetadata(metadata string) string {
+	key, _, found := strings.Cut(metadata, "=")
+	if !found {
+		return redactedText
+	}
+	return key + "=" + redactedText
+}
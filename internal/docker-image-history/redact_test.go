@@ -0,0 +1,58 @@
+package docker_image_history
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRedactLowEntropySecret(t *testing.T) {
+	const secret = "Summer2024Summer2024"
+	tests := []struct {
+		name         string
+		createdBy    string
+		keyword      string
+		decodeBase64 bool
+	}{
+		{name: "keyword matches the secret", createdBy: "/bin/sh -c echo password=" + secret + " > /etc/app.conf", keyword: "Summer2024"},
+		{name: "keyword matches the name of the secret", createdBy: "/bin/sh -c echo password=" + secret + " > /etc/app.conf", keyword: "password"},
+		{name: "keyword matches a quoted value", createdBy: "/bin/sh -c #(nop)  ENV DB_PASSWORD=\"" + secret + "\"", keyword: "db_password"},
+		{
+			name:         "keyword matches decoded base64",
+			createdBy:    "/bin/sh -c echo " + base64.StdEncoding.EncodeToString([]byte("password="+secret)) + " | base64 -d > /etc/app.conf",
+			keyword:      "password",
+			decodeBase64: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var findings bytes.Buffer
+			c := newTestConfig(nil, tt.keyword)
+			c.redact, c.contextChars, c.decodeBase64 = true, 40, tt.decodeBase64
+			c.findings = &findings
+
+			result, err := c.matchHistoryForKeyWords("app:1.0", []historyEntry{{createdBy: tt.createdBy}})
+			if err != nil {
+				t.Fatalf("matching history: %s", err)
+			}
+			if !result.matchFound {
+				t.Fatal("expected the image to match")
+			}
+			reported := map[string]string{
+				"matched layers": formatLayerMatches(result.matchedLayers),
+				"match contexts": fmt.Sprint(result.matchContexts),
+				"FOUND line":     findings.String(),
+			}
+			for name, text := range reported {
+				if strings.Contains(text, secret) || strings.Contains(text, base64.StdEncoding.EncodeToString([]byte("password="+secret))) {
+					t.Errorf("expected the secret to be redacted from the %s, got '%s'", name, text)
+				}
+				if !strings.Contains(text, redactedText) {
+					t.Errorf("expected the %s to be redacted, got '%s'", name, text)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package docker_image_history
+
+import (
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// refCache memoises parsed image refs, so a ref used by many pods (e.g. a sidecar) is only parsed once and every phase of the
+// scan sees the same result. Parse errors are cached too. Safe for concurrent use
+type refCache struct {
+	mu   sync.RWMutex
+	refs map[refCacheKey]parsedRef
+}
+
+// refCacheKey identifies a parsed ref. Refs to insecure registries are parsed with different options, so are cached separately
+type refCacheKey struct {
+	imageRef string
+	insecure bool
+}
+
+// parsedRef is the result of parsing a single image ref
+type parsedRef struct {
+	ref name.Reference
+	err error
+}
+
+// imageRefCache is the ref cache shared by the whole package
+var imageRefCache = &refCache{refs: make(map[refCacheKey]parsedRef)}
+
+// parseImageRef parses an image ref, returning the cached result if it has been parsed before
+// Refs are parsed with the default options (e.g. an image without a tag is 'latest'), plus name.Insecure if insecure is set
+func parseImageRef(imageRef string, insecure bool) (name.Reference, error) {
+	key := refCacheKey{imageRef: imageRef, insecure: insecure}
+
+	imageRefCache.mu.RLock()
+	parsed, found := imageRefCache.refs[key]
+	imageRefCache.mu.RUnlock()
+	if found {
+		return parsed.ref, parsed.err
+	}
+
+	var opts []name.Option
+	if insecure {
+		opts = append(opts, name.Insecure)
+	}
+	parsed.ref, parsed.err = name.ParseReference(imageRef, opts...)
+
+	imageRefCache.mu.Lock()
+	imageRefCache.refs[key] = parsed
+	imageRefCache.mu.Unlock()
+
+	return parsed.ref, parsed.err
+}
@@ -0,0 +1,42 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compileKeywordRegexps compiles the keywords as regular expressions when regexKeywords is set, so invalid patterns fail before any
+// images are scanned. The '!' and '<field>:' prefixes are not part of the pattern. Patterns are case-insensitive unless caseSensitive is set
+func (c *Config) compileKeywordRegexps(keywords []string) error {
+	if !c.regexKeywords {
+		return nil
+	}
+	if c.keywordRegexps == nil {
+		c.keywordRegexps = make(map[string]*regexp.Regexp)
+	}
+
+	for _, keyword := range keywords {
+		term, _ := parseNegatedKeyword(keyword)
+		_, term = parseKeywordField(term)
+		if _, compiled := c.keywordRegexps[term]; compiled {
+			continue
+		}
+
+		pattern := term
+		if !c.caseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("compiling the keyword '%s' as a regular expression: %w", keyword, err)
+		}
+		c.keywordRegexps[term] = re
+	}
+	return nil
+}
+
+// keywordRegexp returns the compiled regular expression for a keyword's term, if keywords are regular expressions
+func (c *Config) keywordRegexp(term string) (*regexp.Regexp, bool) {
+	re, ok := c.keywordRegexps[term]
+	return re, ok
+}
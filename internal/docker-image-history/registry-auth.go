@@ -0,0 +1,201 @@
+package docker_image_history
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// registryCredentials is the username and password used to log in to a registry. Each auth provider supplies its own username
+// Registries using token auth (e.g. a credential helper returning an identity token) supply a token in place of the password
+type registryCredentials struct {
+	username      string
+	password      string
+	identityToken string
+	registryToken string
+}
+
+// String masks the password and tokens, so the credentials are never logged in full if they are accidentally formatted with %v or %+v
+func (r registryCredentials) String() string {
+	return fmt.Sprintf("{username: %s, password: <redacted>, identityToken: <redacted>, registryToken: <redacted>}", r.username)
+}
+
+// GoString masks the password when the credentials are formatted with %#v
+func (r registryCredentials) GoString() string {
+	return r.String()
+}
+
+// empty returns whether no credentials are set
+func (r registryCredentials) empty() bool {
+	return r == registryCredentials{}
+}
+
+// authenticator returns the credentials as an authenticator for reading images from their registry
+func (r registryCredentials) authenticator() authn.Authenticator {
+	return authn.FromConfig(authn.AuthConfig{Username: r.username, Password: r.password, IdentityToken: r.identityToken, RegistryToken: r.registryToken})
+}
+
+// encode returns the credentials as base64 encoded JSON, ready to be used as the RegistryAuth when pulling images
+func (r registryCredentials) encode() (string, error) {
+	jsonBytes, err := json.Marshal(types.AuthConfig{Username: r.username, Password: r.password, IdentityToken: r.identityToken, RegistryToken: r.registryToken})
+	if err != nil {
+		return "", fmt.Errorf("marshalling registry creds into JSON: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(jsonBytes), nil
+}
+
+// decodeRegistryCredentials parses credentials previously encoded into a RegistryAuth string
+func decodeRegistryCredentials(encoded string) (registryCredentials, error) {
+	jsonBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return registryCredentials{}, fmt.Errorf("decoding registry creds: %w", err)
+	}
+	var creds types.AuthConfig
+	if err = json.Unmarshal(jsonBytes, &creds); err != nil {
+		return registryCredentials{}, fmt.Errorf("unmarshalling registry creds: %w", err)
+	}
+	return registryCredentials{username: creds.Username, password: creds.Password, identityToken: creds.IdentityToken, registryToken: creds.RegistryToken}, nil
+}
+
+// Environment variables read for the credentials of Google and Azure registries
+const (
+	googleCredentialsEnv  = "GOOGLE_APPLICATION_CREDENTIALS"
+	azureRegistryTokenEnv = "AZURE_CONTAINER_REGISTRY_TOKEN"
+	azureClientIDEnv      = "AZURE_CLIENT_ID"
+	azureClientSecretEnv  = "AZURE_CLIENT_SECRET"
+)
+
+// Usernames the registries expect with a Google service account key or an Azure access token as the password
+const (
+	googleKeyUsername  = "_json_key"
+	azureTokenUsername = "00000000-0000-0000-0000-000000000000"
+)
+
+// registryAuthProvider resolves the credentials for the registry hosts it matches
+// credentials returns false if no credentials are configured for it, in which case the local Docker config is used instead
+type registryAuthProvider struct {
+	name        string
+	matches     func(host string) bool
+	credentials func() (registryCredentials, bool, error)
+}
+
+// registryAuthProviders are checked in order for the registry host of each image. ECR is handled separately, as its credentials
+// are fetched per AWS region. Hosts which no provider matches (e.g. Docker Hub) use the local Docker config and its credential helpers
+var registryAuthProviders = []registryAuthProvider{
+	{name: "Google", matches: isGoogleRegistry, credentials: googleRegistryCredentials},
+	{name: "Azure", matches: isAzureRegistry, credentials: azureRegistryCredentials},
+}
+
+// isGoogleRegistry returns whether the host is Google Container Registry (e.g. 'eu.gcr.io') or Artifact Registry (e.g. 'europe-docker.pkg.dev')
+func isGoogleRegistry(host string) bool {
+	return host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, ".pkg.dev")
+}
+
+// isAzureRegistry returns whether the host is an Azure Container Registry, e.g. 'myregistry.azurecr.io'
+func isAzureRegistry(host string) bool {
+	return strings.HasSuffix(host, ".azurecr.io")
+}
+
+// googleRegistryCredentials returns the service account key in GOOGLE_APPLICATION_CREDENTIALS, which Google registries accept as
+// the password of the '_json_key' user
+func googleRegistryCredentials() (registryCredentials, bool, error) {
+	keyPath := os.Getenv(googleCredentialsEnv)
+	if len(keyPath) == 0 {
+		return registryCredentials{}, false, nil
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return registryCredentials{}, false, fmt.Errorf("reading Google service account key '%s' from %s: %w", keyPath, googleCredentialsEnv, err)
+	}
+	return registryCredentials{username: googleKeyUsername, password: string(key)}, true, nil
+}
+
+// azureRegistryCredentials returns the ACR access token in AZURE_CONTAINER_REGISTRY_TOKEN (e.g. from 'az acr login --expose-token'),
+// otherwise the service principal in AZURE_CLIENT_ID and AZURE_CLIENT_SECRET
+func azureRegistryCredentials() (registryCredentials, bool, error) {
+	if token := os.Getenv(azureRegistryTokenEnv); len(token) > 0 {
+		return registryCredentials{username: azureTokenUsername, password: token}, true, nil
+	}
+	clientID, clientSecret := os.Getenv(azureClientIDEnv), os.Getenv(azureClientSecretEnv)
+	if len(clientID) > 0 && len(clientSecret) > 0 {
+		return registryCredentials{username: clientID, password: clientSecret}, true, nil
+	}
+	return registryCredentials{}, false, nil
+}
+
+// providerCredentials returns the credentials of the provider which matches the registry host of the image ref, if it has any configured
+func providerCredentials(imageReference string) (registryCredentials, bool, error) {
+	host := registryHost(imageReference)
+	for _, p := range registryAuthProviders {
+		if !p.matches(host) {
+			continue
+		}
+		creds, ok, err := p.credentials()
+		if err != nil {
+			return registryCredentials{}, false, fmt.Errorf("%s registry '%s': %w", p.name, host, err)
+		}
+		return creds, ok, nil
+	}
+	return registryCredentials{}, false, nil
+}
+
+// registryAuth returns the RegistryAuth to pull an image with, selected by its registry host: the ECR credentials for its region,
+// the credentials of a matching provider, or otherwise those in the local Docker config (${HOME}/.docker/config.json) and its
+// credential helpers. Returns an empty string if the registry allows anonymous pulls
+func (c *Config) registryAuth(ctx context.Context, imageReference string) (string, error) {
+	if isECRImage(imageReference) {
+		region, err := c.ecrRegionForImage(ctx, imageReference)
+		if err != nil {
+			return "", err
+		}
+		return c.ecrCredentialsForRegion(ctx, region)
+	}
+
+	creds, ok, err := providerCredentials(imageReference)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return creds.encode()
+	}
+	return keychainRegistryAuth(imageReference)
+}
+
+// keychainRegistryAuth returns the credentials for a registry from the local Docker config (${HOME}/.docker/config.json) and its
+// credential helpers, encoded as the RegistryAuth to pull with. Returns an empty string if the registry allows anonymous pulls
+func keychainRegistryAuth(imageReference string) (string, error) {
+	ref, err := parseImageRef(imageReference, false)
+	if err != nil {
+		return "", fmt.Errorf("parsing image reference '%s': %w", imageReference, err)
+	}
+	authenticator, err := authn.DefaultKeychain.Resolve(ref.Context().Registry)
+	if err != nil {
+		return "", fmt.Errorf("resolving credentials for registry '%s': %w", ref.Context().RegistryStr(), err)
+	}
+	authConfig, err := authenticator.Authorization()
+	if err != nil {
+		return "", fmt.Errorf("reading credentials for registry '%s': %w", ref.Context().RegistryStr(), err)
+	}
+	return encodeAuthConfig(authConfig)
+}
+
+// encodeAuthConfig encodes the credentials resolved from the keychain, or returns an empty string if there are none
+// Token based credentials (identity and registry tokens) are passed on as well as a username and password
+func encodeAuthConfig(authConfig *authn.AuthConfig) (string, error) {
+	creds := registryCredentials{
+		username:      authConfig.Username,
+		password:      authConfig.Password,
+		identityToken: authConfig.IdentityToken,
+		registryToken: authConfig.RegistryToken,
+	}
+	if creds.empty() {
+		return "", nil
+	}
+	return creds.encode()
+}
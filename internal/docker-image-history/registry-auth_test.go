@@ -0,0 +1,87 @@
+package docker_image_history
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+func TestEncodeAuthConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		authConfig authn.AuthConfig
+		want       map[string]string
+	}{
+		{name: "no credentials"},
+		{
+			name:       "username and password",
+			authConfig: authn.AuthConfig{Username: "user", Password: "secret"},
+			want:       map[string]string{"username": "user", "password": "secret"},
+		},
+		{
+			name:       "identity token only",
+			authConfig: authn.AuthConfig{Username: "<token>", IdentityToken: "refresh-token"},
+			want:       map[string]string{"username": "<token>", "identitytoken": "refresh-token"},
+		},
+		{
+			name:       "registry token only",
+			authConfig: authn.AuthConfig{RegistryToken: "bearer-token"},
+			want:       map[string]string{"registrytoken": "bearer-token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := encodeAuthConfig(&tt.authConfig)
+			if err != nil {
+				t.Fatalf("encoding auth config: %s", err)
+			}
+			if tt.want == nil {
+				if len(encoded) != 0 {
+					t.Fatalf("expected no registry auth, got '%s'", encoded)
+				}
+				return
+			}
+
+			jsonBytes, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("decoding registry auth: %s", err)
+			}
+			var got map[string]string
+			if err = json.Unmarshal(jsonBytes, &got); err != nil {
+				t.Fatalf("unmarshalling registry auth: %s", err)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("expected '%s' to be '%s', got '%s'", key, value, got[key])
+				}
+			}
+
+			creds, err := decodeRegistryCredentials(encoded)
+			if err != nil {
+				t.Fatalf("decoding registry credentials: %s", err)
+			}
+			want := registryCredentials{
+				username:      tt.authConfig.Username,
+				password:      tt.authConfig.Password,
+				identityToken: tt.authConfig.IdentityToken,
+				registryToken: tt.authConfig.RegistryToken,
+			}
+			if creds != want {
+				t.Errorf("expected the credentials to round trip, got %#v", creds)
+			}
+		})
+	}
+}
+
+func TestRegistryCredentialsStringRedactsTokens(t *testing.T) {
+	creds := registryCredentials{username: "user", password: "secret", identityToken: "refresh-token", registryToken: "bearer-token"}
+
+	for _, secret := range []string{"secret", "refresh-token", "bearer-token"} {
+		if got := creds.String(); strings.Contains(got, secret) {
+			t.Errorf("expected '%s' to be redacted, got '%s'", secret, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// ParseRegistryMirrors parses a comma separated list of '<registry>=<mirror>' pairs into a map of registry host to mirror prefix
+// e.g. 'docker.io=mirror.internal/docker.io'. Docker Hub can be given as 'docker.io' or 'index.docker.io'
+func ParseRegistryMirrors(s string) (map[string]string, error) {
+	mirrors := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		registry, mirror, found := strings.Cut(pair, "=")
+		if !found || len(registry) == 0 || len(mirror) == 0 {
+			return nil, fmt.Errorf("expected '<registry>=<mirror>', got '%s'", pair)
+		}
+		if registry == "docker.io" {
+			registry = name.DefaultRegistry
+		}
+		mirrors[registry] = strings.TrimSuffix(mirror, "/")
+	}
+	return mirrors, nil
+}
+
+// mirroredRef returns the ref to pull an image from its registry's mirror, and whether its registry has a mirror
+// The repository and tag/digest are kept, e.g. 'nginx:1.23' becomes 'mirror.internal/docker.io/library/nginx:1.23'
+// ECR images are never mirrored as they are authenticated per region
+func (c *Config) mirroredRef(imageReference string) (string, bool) {
+	if len(c.registryMirrors) == 0 || isECRImage(imageReference) {
+		return "", false
+	}
+	ref, err := parseImageRef(imageReference, false)
+	if err != nil {
+		return "", false
+	}
+	mirror, ok := c.registryMirrors[ref.Context().RegistryStr()]
+	if !ok {
+		return "", false
+	}
+
+	mirrored := mirror + "/" + ref.Context().RepositoryStr()
+	if digest, isDigest := ref.(name.Digest); isDigest {
+		return mirrored + "@" + digest.DigestStr(), true
+	}
+	return mirrored + ":" + ref.Identifier(), true
+}
+
+// sourceRef returns the ref an image is read from, which is its mirror if its registry has one
+func (c *Config) sourceRef(imageReference string) string {
+	if mirrored, ok := c.mirroredRef(imageReference); ok {
+		return mirrored
+	}
+	return imageReference
+}
+
+// retagMirroredImage tags an image pulled from a mirror with its original ref and removes the mirror tag, so the rest of the
+// scan (history, cleanup and the results) only uses the original ref
+func (c *Config) retagMirroredImage(ctx context.Context, mirroredRef, imageReference string) error {
+	if err := c.dockerClient.ImageTag(ctx, mirroredRef, imageReference); err != nil {
+		return fmt.Errorf("tagging image pulled from mirror '%s' as '%s': %w", mirroredRef, imageReference, err)
+	}
+	// Not cancelled with the scan, so the mirror tag is not left behind once the image has been retagged
+	if _, err := c.dockerClient.ImageRemove(context.Background(), mirroredRef, types.ImageRemoveOptions{}); err != nil {
+		return fmt.Errorf("removing mirror tag '%s': %w", mirroredRef, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package docker_image_history
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// registryTransport routes registry requests to a transport which skips TLS verification for the configured insecure hosts
+// All other hosts are verified against the system CAs plus any custom CA
+type registryTransport struct {
+	secure        http.RoundTripper
+	insecure      http.RoundTripper
+	insecureHosts []string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if sliceContains(t.insecureHosts, req.URL.Host) {
+		return t.insecure.RoundTrip(req)
+	}
+	return t.secure.RoundTrip(req)
+}
+
+// newRegistryTransport returns the transport used to talk to registries directly, trusting the CA file and skipping verification for the insecure hosts
+func newRegistryTransport(insecureHosts []string, caFile string) (http.RoundTripper, error) {
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		log.Printf("loading system CA pool, continuing with an empty pool: %s", err)
+		rootCAs = x509.NewCertPool()
+	}
+
+	if len(caFile) > 0 {
+		caBytes, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading registry CA file '%s': %w", caFile, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no PEM certificates found in registry CA file '%s'", caFile)
+		}
+		log.Printf("Trusting the registry CA certificates in: %s", caFile)
+	}
+
+	secure := http.DefaultTransport.(*http.Transport).Clone()
+	secure.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+
+	insecure := http.DefaultTransport.(*http.Transport).Clone()
+	insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // only used for the hosts explicitly listed by the user
+
+	if len(insecureHosts) > 0 {
+		log.Printf("WARNING: TLS certificate verification is DISABLED for these registries: %v. Connections to them can be intercepted", insecureHosts)
+	}
+
+	return &registryTransport{secure: secure, insecure: insecure, insecureHosts: insecureHosts}, nil
+}
+
+// warnDaemonInsecureRegistries warns about insecure registries which the Docker daemon has not been configured to trust
+// Pulls are performed by the daemon, so TLS verification for them can only be relaxed in its own config (daemon.json or /etc/docker/certs.d)
+func (c *Config) warnDaemonInsecureRegistries(ctx context.Context) {
+	if len(c.insecureRegistries) == 0 && len(c.registryCAFile) == 0 {
+		return
+	}
+
+	if len(c.registryCAFile) > 0 {
+		log.Printf("WARNING: the registry CA file is only used by -remote. For pulls, install it on the Docker daemon in /etc/docker/certs.d/<host>/ca.crt")
+	}
+
+	info, err := c.dockerClient.Info(ctx)
+	if err != nil {
+		log.Printf("unable to query the Docker daemon's registry config: %s", err)
+		return
+	}
+
+	for _, host := range c.insecureRegistries {
+		if info.RegistryConfig != nil {
+			if index, ok := info.RegistryConfig.IndexConfigs[host]; ok && !index.Secure {
+				continue
+			}
+		}
+		log.Printf("WARNING: registry '%s' is not configured as insecure on the Docker daemon. Add it to 'insecure-registries' in daemon.json or pulls will fail TLS verification", host)
+	}
+}
@@ -0,0 +1,215 @@
+package docker_image_history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/sync/errgroup"
+)
+
+// scanImagesRemotely reads the history of each image directly from its registry rather than pulling it using the local Docker instance
+// Only the image manifest and config are downloaded, so there is no local disk usage and images are inspected concurrently
+func (c *Config) scanImagesRemotely(ctx context.Context, images []string) error {
+	log.Printf("Reading image history from the registries with a concurrency of %d", c.remoteConcurrency)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.remoteConcurrency)
+
+	for _, image := range images {
+		image := image
+		if c.skipScan(image) {
+			continue
+		}
+		g.Go(func() error {
+			return c.scanImageRemotely(ctx, image)
+		})
+	}
+
+	return g.Wait()
+}
+
+// scanImageRemotely reads the history of a single image from its registry and checks it for keywords
+// Returns an error only if the whole scan should be aborted (failFast and an unconfigured ECR region)
+func (c *Config) scanImageRemotely(ctx context.Context, image string) error {
+	c.startProgress(image)
+	defer c.timePhase(phaseInspect, time.Now())
+
+	history, created, err := c.remoteImageHistory(ctx, image)
+	var notFound *imageNotFoundError
+	if errors.As(err, &notFound) {
+		c.recordMissingImage(image, err)
+		return nil
+	}
+	if c.failFast && errors.As(err, new(*unconfiguredECRRegionError)) {
+		return err
+	}
+	if c.skipUnconfiguredECRRegion(image, err) {
+		return nil
+	}
+	if err != nil {
+		c.recordScanError(image, scanStageInspect, err)
+		return nil
+	}
+	if c.createdBeforeSince(image, created) {
+		return nil
+	}
+
+	if c.checkRunsAsRoot {
+		user, err := c.remoteImageUser(ctx, image)
+		if err != nil {
+			c.recordScanError(image, scanStageInspect, err)
+			return nil
+		}
+		c.checkImageUser(image, user)
+	}
+
+	if c.maxImageSizeBytes > 0 {
+		size, err := c.remoteImageSize(ctx, image)
+		if err != nil {
+			c.recordScanError(image, scanStageInspect, err)
+			return nil
+		}
+		c.checkImageSize(image, size)
+	}
+
+	result, err := c.matchHistoryForKeyWords(image, history)
+	if err != nil {
+		c.recordScanError(image, scanStageInspect, err)
+		return nil
+	}
+	if c.scanAnnotations {
+		if err = c.matchMetadataForKeyWords(ctx, image, &result); err != nil {
+			c.recordScanError(image, scanStageInspect, err)
+			return nil
+		}
+	}
+	if err = c.matchRemoteConfigFields(ctx, image, &result); err != nil {
+		c.recordScanError(image, scanStageInspect, err)
+		return nil
+	}
+
+	c.recordResult(result)
+	return nil
+}
+
+// remoteImage returns a handle to an image in its registry. Only the manifest is fetched until more data is requested from it
+// Multi-platform images are resolved to the linux/amd64 image
+func (c *Config) remoteImage(ctx context.Context, imageReference string) (v1.Image, error) {
+	sourceRef := c.sourceRef(imageReference)
+	ref, err := parseImageRef(sourceRef, sliceContains(c.insecureRegistries, registryHost(sourceRef)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing image reference '%s': %w", imageReference, err)
+	}
+
+	authOption, err := c.remoteAuthOption(ctx, sourceRef)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithTransport(c.registryTransport), authOption)
+	if err != nil && isImageNotFound(err) {
+		return nil, &imageNotFoundError{imageRef: imageReference, err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching remote image '%s': %w", imageReference, err)
+	}
+	return img, nil
+}
+
+// remoteImageSize returns the total compressed size of an image's config and layers from its registry manifest
+func (c *Config) remoteImageSize(ctx context.Context, imageReference string) (int64, error) {
+	img, err := c.remoteImage(ctx, imageReference)
+	if err != nil {
+		return 0, err
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, fmt.Errorf("fetching remote manifest for '%s': %w", imageReference, err)
+	}
+
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// remoteImageHistory reads the history of a single image from the config blob stored in its registry
+// Also returns when the image was created
+func (c *Config) remoteImageHistory(ctx context.Context, imageReference string) ([]historyEntry, time.Time, error) {
+	img, err := c.remoteImage(ctx, imageReference)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("fetching remote image config for '%s': %w", imageReference, err)
+	}
+
+	return configHistory(configFile), configFile.Created.Time, nil
+}
+
+// configHistory returns the history entries of an image config
+func configHistory(configFile *v1.ConfigFile) []historyEntry {
+	// Each history entry which is not an empty layer corresponds, in order, to a layer in the rootfs
+	entries := make([]historyEntry, 0, len(configFile.History))
+	layerIndex := 0
+	for i, h := range configFile.History {
+		entry := historyEntry{index: i, createdBy: h.CreatedBy, created: h.Created.Time}
+		if !h.EmptyLayer && layerIndex < len(configFile.RootFS.DiffIDs) {
+			entry.layerDigest = configFile.RootFS.DiffIDs[layerIndex].String()
+			layerIndex++
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// registryHost returns the registry host of an image ref, or 'index.docker.io' for Docker Hub images
+func registryHost(imageReference string) string {
+	ref, err := parseImageRef(imageReference, false)
+	if err != nil {
+		return ""
+	}
+	return ref.Context().RegistryStr()
+}
+
+// remoteAuthOption returns the credentials to use when reading an image from its registry
+// ECR images use the credentials generated for their region, and images on a registry with a matching auth provider use its
+// credentials. All others use the local Docker config (${HOME}/.docker/config.json)
+func (c *Config) remoteAuthOption(ctx context.Context, imageReference string) (remote.Option, error) {
+	if !isECRImage(imageReference) {
+		creds, ok, err := providerCredentials(imageReference)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return remote.WithAuth(creds.authenticator()), nil
+		}
+		return remote.WithAuthFromKeychain(authn.DefaultKeychain), nil
+	}
+
+	region, err := c.ecrRegionForImage(ctx, imageReference)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := c.ecrCredentialsForRegion(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := decodeRegistryCredentials(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("ECR region '%s': %w", region, err)
+	}
+
+	return remote.WithAuth(creds.authenticator()), nil
+}
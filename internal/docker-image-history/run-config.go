@@ -0,0 +1,158 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version is the version of the tool recorded in the results files
+// Set at build time with -ldflags "-X query-k8s-container-image-history/internal/docker-image-history.Version=<version>"
+var Version = "dev"
+
+// runConfig describes how a set of results was produced, so a results file is self-describing when read later
+type runConfig struct {
+	ToolVersion     string     `json:"toolVersion"`
+	ClusterContext  string     `json:"clusterContext"`
+	Namespaces      string     `json:"namespaces"`
+	Keywords        []string   `json:"keywords"`
+	KeywordMode     string     `json:"keywordMode"`
+	KeywordPolicies string     `json:"keywordPolicies,omitempty"`
+	KeywordRules    string     `json:"keywordRules,omitempty"`
+	ScanMode        string     `json:"scanMode"`
+	Since           *time.Time `json:"since,omitempty"`
+	GeneratedAt     time.Time  `json:"generatedAt"`
+	Counts          runCounts  `json:"counts"`
+}
+
+// runCounts is a summary of the images discovered and scanned
+type runCounts struct {
+	Pods             int            `json:"pods"`
+	Containers       int            `json:"containers"`
+	Images           int            `json:"images"`
+	ScannedImages    int            `json:"scannedImages"`
+	OffendingImages  int            `json:"offendingImages"`
+	ScanErrors       int            `json:"scanErrors"`
+	SkippedImages    int            `json:"skippedImages"`
+	MissingImages    int            `json:"missingImages"`
+	OversizedImages  int            `json:"oversizedImages"`
+	MutableTagImages int            `json:"mutableTagImages"`
+	UnsampledImages  int            `json:"unsampledImages"`
+	UnparseableRefs  int            `json:"unparseableImageRefs"`
+	ErrorsByStage    map[string]int `json:"errorsByStage"`
+}
+
+// runConfig returns the parameters and counts of the current run. Safe for concurrent use
+func (c *Config) runConfig() runConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rc := runConfig{
+		ToolVersion:     Version,
+		ClusterContext:  c.clusterK8sContextName,
+		Namespaces:      "all",
+		Keywords:        c.dockerImageKeyWords,
+		KeywordMode:     "case-insensitive substring",
+		KeywordPolicies: c.keywordPoliciesPath,
+		KeywordRules:    c.keywordRulesPath,
+		ScanMode:        "local",
+		GeneratedAt:     time.Now().UTC(),
+		Counts: runCounts{
+			Pods:             c.discovery.podsIncluded,
+			Containers:       c.discovery.containersIncluded,
+			Images:           len(c.dockerImages),
+			ScannedImages:    c.progress.processedImages,
+			OffendingImages:  len(c.offendingDockerImages) + c.streamedOffendingImages,
+			ScanErrors:       len(c.scanErrors),
+			SkippedImages:    len(c.skippedImages),
+			MissingImages:    len(c.missingImages),
+			OversizedImages:  len(c.oversizedImages),
+			MutableTagImages: len(c.mutableTagImages),
+			UnsampledImages:  len(c.unsampledImages),
+			UnparseableRefs:  len(c.unparseableImages),
+			ErrorsByStage:    c.errorsByStage(),
+		},
+	}
+	if len(c.namespace) > 0 {
+		rc.Namespaces = c.namespace
+	}
+	if c.caseSensitive {
+		rc.KeywordMode = "case-sensitive substring"
+	}
+	if c.countMode == CountModeOccurrences {
+		rc.KeywordMode += ", counting occurrences"
+	}
+	if c.runOnly {
+		rc.KeywordMode += ", RUN instructions only"
+	}
+	if c.decodeBase64 {
+		rc.KeywordMode += ", including base64 decoded tokens"
+	}
+	if c.remote {
+		rc.ScanMode = "remote"
+	} else if c.scanNodeCachedImages {
+		rc.ScanMode = "remote for node cached images, local for the rest"
+	}
+	if !c.since.IsZero() {
+		since := c.since.UTC()
+		rc.Since = &since
+	}
+	return rc
+}
+
+// resultsFileFlags returns the flags to open a results file with, either appending to or overwriting an existing file
+func resultsFileFlags(outputAppend bool) int {
+	if outputAppend {
+		return os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	}
+	return os.O_TRUNC | os.O_CREATE | os.O_WRONLY
+}
+
+// header returns the run config as '#' prefixed lines to start a text results file with
+func (rc runConfig) header() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Generated by query-k8s-container-image-history %s at %s\n", rc.ToolVersion, rc.GeneratedAt.Format(time.RFC3339)))
+	b.WriteString(fmt.Sprintf("# Cluster context: %s, namespaces: %s\n", rc.ClusterContext, rc.Namespaces))
+	b.WriteString(fmt.Sprintf("# Keywords: %v, keyword mode: %s, scan mode: %s\n", rc.Keywords, rc.KeywordMode, rc.ScanMode))
+	if len(rc.KeywordPolicies) > 0 {
+		b.WriteString(fmt.Sprintf("# Keyword policies: %s\n", rc.KeywordPolicies))
+	}
+	if len(rc.KeywordRules) > 0 {
+		b.WriteString(fmt.Sprintf("# Keyword rules: %s\n", rc.KeywordRules))
+	}
+	if rc.Since != nil {
+		b.WriteString(fmt.Sprintf("# Only images created after: %s\n", rc.Since.Format(time.RFC3339)))
+	}
+	b.WriteString(fmt.Sprintf("# Pods: %d, containers: %d, images: %d, scanned: %d, offending: %d, scan errors: %d, skipped: %d, missing from registry: %d, oversized: %d, mutable tags: %d\n",
+		rc.Counts.Pods, rc.Counts.Containers, rc.Counts.Images, rc.Counts.ScannedImages, rc.Counts.OffendingImages, rc.Counts.ScanErrors, rc.Counts.SkippedImages, rc.Counts.MissingImages, rc.Counts.OversizedImages, rc.Counts.MutableTagImages))
+	if rc.Counts.UnsampledImages > 0 {
+		b.WriteString(fmt.Sprintf("# Sampled: %d images were not scanned as -maxImages was set\n", rc.Counts.UnsampledImages))
+	}
+	if rc.Counts.UnparseableRefs > 0 {
+		b.WriteString(fmt.Sprintf("# Unparseable: %d image refs could not be parsed and were not scanned\n", rc.Counts.UnparseableRefs))
+	}
+	return b.String()
+}
+
+// openResultsFile opens a text results file for appending, or truncates it unless outputAppend is set
+// A header describing the run is written when the file is empty
+func (c *Config) openResultsFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, resultsFileFlags(c.outputAppend), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening file '%s': %w", path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("reading file info of '%s': %w", path, err)
+	}
+	if info.Size() == 0 {
+		if _, err = f.WriteString(c.runConfig().header()); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("writing header to '%s': %w", path, err)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,100 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// rootImage stores an image which runs as root, along with the user set in its config (empty if no USER instruction was used)
+type rootImage struct {
+	imageRef string
+	user     string
+}
+
+// runsAsRoot returns whether an image config user (the argument of the last USER instruction, e.g. 'app', '1000:1000') is root
+// No user, 'root' or UID 0 are root whatever the group
+func runsAsRoot(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+// checkImageUser records the image as running as root if its config user is root. Safe for concurrent use
+func (c *Config) checkImageUser(imageRef, user string) {
+	if !runsAsRoot(user) {
+		return
+	}
+	_, _ = fmt.Fprintf(c.findings, "RUNS AS ROOT: %s (user: %s)\n", imageRef, displayUser(user))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rootImages = append(c.rootImages, rootImage{imageRef: imageRef, user: user})
+}
+
+// displayUser returns the user for output, making it clear when no USER instruction was used
+func displayUser(user string) string {
+	if len(user) == 0 {
+		return "not set"
+	}
+	return user
+}
+
+// localImageUser returns the user a pulled image runs as, from its config
+func (c *Config) localImageUser(ctx context.Context, imageRef string) (string, error) {
+	inspect, _, err := c.dockerClient.ImageInspectWithRaw(ctx, imageRef)
+	if err != nil {
+		return "", fmt.Errorf("inspecting local image '%s': %w", imageRef, err)
+	}
+	if inspect.Config == nil {
+		return "", nil
+	}
+	return inspect.Config.User, nil
+}
+
+// remoteImageUser returns the user an image runs as, from the config blob stored in its registry
+func (c *Config) remoteImageUser(ctx context.Context, imageRef string) (string, error) {
+	img, err := c.remoteImage(ctx, imageRef)
+	if err != nil {
+		return "", err
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return "", fmt.Errorf("fetching remote image config for '%s': %w", imageRef, err)
+	}
+	return configFile.Config.User, nil
+}
+
+// outputRootImages writes to a file all the images which run as root, along with the pods running them
+func (c *Config) outputRootImages() error {
+	if len(c.rootImages) == 0 {
+		return nil
+	}
+
+	rootImagesResultsPath := c.resultsPath("runs-as-root", "txt")
+	f, err := c.openResultsFile(rootImagesResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", rootImagesResultsPath, err)
+		}
+	}(f)
+
+	for _, r := range c.rootImages {
+		_, err = f.WriteString(fmt.Sprintf("%s\t(user: %s) ", r.imageRef, displayUser(r.user)))
+		for _, match := range c.formatPods(c.dockerImages[r.imageRef]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", rootImagesResultsPath, err)
+		}
+	}
+	log.Printf("%d images run as root. Results written to: %s", len(c.rootImages), rootImagesResultsPath)
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package docker_image_history
+
+import (
+	"log"
+	"math/rand"
+)
+
+// sampleImages limits the scan to maxImages of the discovered images, for a quick spot-check of a large cluster
+// The images are sorted and truncated, or shuffled using the seed first if one is set, so a run can be repeated with the same sample
+// Images outside the sample are still discovered and reported as non ECR images, they are just not pulled or scanned
+func (c *Config) sampleImages() {
+	refs := c.imageRefs()
+	if c.maxImages <= 0 || len(refs) <= c.maxImages {
+		return
+	}
+
+	if c.seed != 0 {
+		rand.New(rand.NewSource(c.seed)).Shuffle(len(refs), func(i, j int) { refs[i], refs[j] = refs[j], refs[i] })
+	}
+
+	c.unsampledImages = make(map[string]bool, len(refs)-c.maxImages)
+	for _, image := range refs[c.maxImages:] {
+		c.unsampledImages[image] = true
+	}
+
+	if c.seed != 0 {
+		log.Printf("Sampling: scanning a random sample (seed %d) of %d of the %d images. %d images will not be scanned", c.seed, c.maxImages, len(refs), len(c.unsampledImages))
+	} else {
+		log.Printf("Sampling: scanning the first %d of the %d images sorted by ref. %d images will not be scanned", c.maxImages, len(refs), len(c.unsampledImages))
+	}
+}
@@ -0,0 +1,100 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Stages of scanning an image which can fail. Pull and inspect failures are recorded as scan errors without aborting the whole scan,
+// cleanup failures are reported as leaked images
+const (
+	scanStagePull    = "pull"
+	scanStageInspect = "inspect"
+	scanStageCleanup = "cleanup"
+)
+
+// errorSummaryStages are the stages counted in the error summary, in the order they are printed
+var errorSummaryStages = []string{scanStagePull, scanStageInspect, scanStageCleanup}
+
+// scanError stores an image which could not be scanned, and the stage it failed at
+type scanError struct {
+	imageRef string
+	stage    string
+	err      error
+}
+
+// recordScanError stores an image which failed to be scanned so the scan can continue with the other images. Safe for concurrent use
+func (c *Config) recordScanError(imageRef, stage string, err error) {
+	log.Printf("skipping image '%s' which failed to %s: %s", imageRef, stage, err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scanErrors = append(c.scanErrors, scanError{imageRef: imageRef, stage: stage, err: err})
+	c.progress.processedImages++
+	c.errorCounts[stage]++
+}
+
+// countError counts an image which failed at a stage which is not recorded as a scan error, for the error summary. Safe for concurrent use
+func (c *Config) countError(stage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errorCounts[stage]++
+}
+
+// errorsByStage returns the number of images which failed at each stage, including the stages without any failures
+// Must be called whilst holding c.mu
+func (c *Config) errorsByStage() map[string]int {
+	counts := make(map[string]int, len(errorSummaryStages))
+	for _, stage := range errorSummaryStages {
+		counts[stage] = c.errorCounts[stage]
+	}
+	return counts
+}
+
+// printErrorSummary prints a single line counting the failures at each stage to stderr, e.g. 'errors: pull=0 inspect=1 cleanup=0'
+// It is printed without the log prefix so CI pipelines can parse it
+func (c *Config) printErrorSummary() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make([]string, 0, len(errorSummaryStages))
+	for _, stage := range errorSummaryStages {
+		counts = append(counts, fmt.Sprintf("%s=%d", stage, c.errorCounts[stage]))
+	}
+	_, _ = fmt.Fprintf(c.stderr, "errors: %s\n", strings.Join(counts, " "))
+}
+
+// outputScanErrors writes to a file all the images which could not be scanned, along with the pods running them
+func (c *Config) outputScanErrors() error {
+	if len(c.scanErrors) == 0 {
+		return nil
+	}
+
+	scanErrorsResultsPath := c.resultsPath("scan-errors", "txt")
+	f, err := c.openResultsFile(scanErrorsResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", scanErrorsResultsPath, err)
+		}
+	}(f)
+
+	for _, e := range c.scanErrors {
+		_, err = f.WriteString(fmt.Sprintf("%s\t(stage: %s, error: %s) ", e.imageRef, e.stage, e.err))
+		for _, match := range c.formatPods(c.dockerImages[e.imageRef]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", scanErrorsResultsPath, err)
+		}
+	}
+	log.Printf("%d images could not be scanned. Details written to: %s", len(c.scanErrors), scanErrorsResultsPath)
+
+	return nil
+}
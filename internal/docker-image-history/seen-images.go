@@ -0,0 +1,75 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// seenImageKey identifies a pulled image by its local image ID and the keywords it is checked for, as keyword policies can check
+// the same image for different keywords when it runs under different refs in different namespaces
+func (c *Config) seenImageKey(image pulledImage) string {
+	return image.imageID + "|" + strings.Join(c.keywordsForImage(image.imageRef), ",")
+}
+
+// seenResult returns the result of a pulled image whose history has already been checked this run under another ref, e.g. a tag and
+// the digest it points to, re-attributed to this ref so that the pods running either ref are reported. Safe for concurrent use
+// Refs of the same image which are checked at the same time by different workers are both checked
+func (c *Config) seenResult(image pulledImage) (offendingDockerImage, bool) {
+	c.mu.Lock()
+	seen, ok := c.seenImages[c.seenImageKey(image)]
+	c.mu.Unlock()
+	if !ok {
+		return offendingDockerImage{}, false
+	}
+
+	log.Printf("'%s' is the same image as '%s', which has already been scanned. Reusing its result", image.imageRef, seen.imageRef)
+	result := cloneResult(seen)
+	result.imageRef = image.imageRef
+	if result.matchFound {
+		_, _ = fmt.Fprintf(c.findings, "FOUND (same image as %s): %+v\n", seen.imageRef, result)
+	}
+	return result, true
+}
+
+// rememberResult records the result of a pulled image so that other refs of the same image are not checked again. Safe for concurrent use
+func (c *Config) rememberResult(image pulledImage, result offendingDockerImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seenImages == nil {
+		c.seenImages = make(map[string]offendingDockerImage)
+	}
+	key := c.seenImageKey(image)
+	if _, ok := c.seenImages[key]; !ok {
+		c.seenImages[key] = cloneResult(result)
+	}
+}
+
+// cloneResult copies a result, so merging a repeat scan into one ref's result does not change the result of another
+func cloneResult(result offendingDockerImage) offendingDockerImage {
+	clone := result
+	clone.matchedKeywords = make(map[string]int, len(result.matchedKeywords))
+	for keyword, count := range result.matchedKeywords {
+		clone.matchedKeywords[keyword] = count
+	}
+	clone.absentKeywords = append([]string(nil), result.absentKeywords...)
+	clone.matchedRules = append([]string(nil), result.matchedRules...)
+	clone.decodedKeywords = append([]string(nil), result.decodedKeywords...)
+	clone.matchedInstructions = cloneStringsMap(result.matchedInstructions)
+	clone.matchedLayers = cloneLayerMatches(result.matchedLayers)
+	clone.matchContexts = cloneStringsMap(result.matchContexts)
+	clone.matchedMetadata = cloneStringsMap(result.matchedMetadata)
+	return clone
+}
+
+// cloneStringsMap copies a map of keywords to strings. Returns nil for a nil map
+func cloneStringsMap(m map[string][]string) map[string][]string {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string][]string, len(m))
+	for k, v := range m {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
@@ -0,0 +1,113 @@
+package docker_image_history
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// statusPageTemplate renders the scan progress and the offending images found so far. The page refreshes itself every 5 seconds
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>query-k8s-container-image-history - {{.Context}}</title>
+</head>
+<body>
+<h1>Image history scan: {{.Context}}</h1>
+{{if .Finished}}<p>Scan complete. Processed {{.Processed}} / {{.Total}} images.</p>
+{{else}}<p>Processed {{.Processed}} / {{.Total}} images. Currently processing: <code>{{.Current}}</code></p>
+{{end}}
+<p>Keywords: {{range .Keywords}}<code>{{.}}</code> {{end}}</p>
+<h2>Offending images ({{len .Offending}})</h2>
+{{range .Offending}}
+<h3><code>{{.ImageRef}}</code></h3>
+<p>Matched keywords: {{range $k, $v := .MatchedKeywords}}<code>{{$k}}</code> ({{$v}}) {{end}}</p>
+{{if .AbsentKeywords}}<p>Absent keywords: {{range .AbsentKeywords}}<code>{{.}}</code> {{end}}</p>{{end}}
+<ul>
+{{range .Pods}}<li>podName: {{.PodName}}, containerName: {{.ContainerName}}, namespace: {{.Namespace}}</li>
+{{end}}</ul>
+{{else}}<p>No images have matched the keywords yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// statusPage is the data rendered into statusPageTemplate
+type statusPage struct {
+	Context   string
+	Keywords  []string
+	Total     int
+	Processed int
+	Current   string
+	Finished  bool
+	Offending []statusPageImage
+}
+
+// statusPageImage is a single offending image rendered into statusPageTemplate
+type statusPageImage struct {
+	ImageRef        string
+	MatchedKeywords map[string]int
+	AbsentKeywords  []string
+	Pods            []statusPagePod
+}
+
+// statusPagePod provides the K8s context for an offending image rendered into statusPageTemplate
+type statusPagePod struct {
+	PodName       string
+	ContainerName string
+	Namespace     string
+}
+
+// startStatusServer starts a HTTP server in the background which renders the current scan progress and offending images
+func (c *Config) startStatusServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", c.handleStatusPage)
+
+	go func() {
+		log.Printf("Serving scan progress on http://%s", c.serveAddress)
+		if err := http.ListenAndServe(c.serveAddress, mux); err != nil {
+			log.Printf("status server stopped: %s", err)
+		}
+	}()
+}
+
+// handleStatusPage renders the status page from a snapshot of the current scan state
+func (c *Config) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := statusPageTemplate.Execute(w, c.statusSnapshot()); err != nil {
+		log.Printf("rendering status page: %s", err)
+	}
+}
+
+// statusSnapshot copies the scan state whilst holding the lock so the page can be rendered without blocking the scan
+// The results are deep copied, as the workers keep merging matches into them whilst the page is rendered
+func (c *Config) statusSnapshot() statusPage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	page := statusPage{
+		Context:   c.clusterK8sContextName,
+		Keywords:  append([]string(nil), c.dockerImageKeyWords...),
+		Total:     c.progress.totalImages,
+		Processed: c.progress.processedImages,
+		Current:   c.progress.currentImage,
+		Finished:  c.progress.finished,
+	}
+
+	for _, i := range c.offendingDockerImages {
+		result := cloneResult(i)
+		image := statusPageImage{ImageRef: result.imageRef, MatchedKeywords: result.matchedKeywords, AbsentKeywords: result.absentKeywords}
+		for _, pd := range c.dockerImages[i.imageRef] {
+			image.Pods = append(image.Pods, statusPagePod{PodName: pd.podName, ContainerName: pd.containerName, Namespace: pd.namespace})
+		}
+		page.Offending = append(page.Offending, image)
+	}
+
+	return page
+}
@@ -0,0 +1,51 @@
+package docker_image_history
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStatusSnapshotIsCopied(t *testing.T) {
+	c := newTestConfig(nil, "curl", "wget")
+	c.dockerImages["app:1.0"] = []podDetails{{podName: "app-0", containerName: "app", namespace: "default"}}
+	c.recordResult(offendingDockerImage{imageRef: "app:1.0", matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+
+	page := c.statusSnapshot()
+	c.recordResult(offendingDockerImage{imageRef: "app:1.0", matchFound: true, matchedKeywords: map[string]int{"curl": 2, "wget": 1}})
+
+	if len(page.Offending) != 1 {
+		t.Fatalf("expected 1 offending image, got %d", len(page.Offending))
+	}
+	if got := page.Offending[0].MatchedKeywords; len(got) != 1 || got["curl"] != 1 {
+		t.Errorf("expected the snapshot to keep the matches when it was taken, got %v", got)
+	}
+}
+
+func TestStatusPageConcurrentWithScan(t *testing.T) {
+	c := newTestConfig(nil, "curl")
+	c.recordResult(offendingDockerImage{imageRef: "app:1.0", matchFound: true, matchedKeywords: map[string]int{"curl": 1}})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			c.recordResult(offendingDockerImage{
+				imageRef:        "app:1.0",
+				matchFound:      true,
+				matchedKeywords: map[string]int{"curl": 1, strings.Repeat("k", i%10+1): 1},
+				absentKeywords:  []string{"!useradd"},
+			})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		w := httptest.NewRecorder()
+		c.handleStatusPage(w, httptest.NewRequest("GET", "/", nil))
+		if !strings.Contains(w.Body.String(), "app:1.0") {
+			t.Fatalf("expected the status page to list the offending image, got %s", w.Body.String())
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ParseSince parses the cutoff for the since filter, either an RFC3339 timestamp or a duration before now (e.g. '168h')
+func ParseSince(since string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither an RFC3339 timestamp nor a duration", since)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// createdBeforeSince returns whether an image was created before the since cutoff and so should not be scanned
+func (c *Config) createdBeforeSince(imageRef string, created time.Time) bool {
+	if c.since.IsZero() || !created.Before(c.since) {
+		return false
+	}
+	c.recordSkippedImage(imageRef, fmt.Sprintf("created %s, before the since cutoff %s", created.Format(time.RFC3339), c.since.Format(time.RFC3339)))
+	return true
+}
+
+// localImageCreated returns when a pulled image was built, from its config in the local Docker instance
+func (c *Config) localImageCreated(ctx context.Context, imageRef string) (time.Time, error) {
+	inspect, _, err := c.dockerClient.ImageInspectWithRaw(ctx, imageRef)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("inspecting local image '%s': %w", imageRef, err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing created date of '%s': %w", imageRef, err)
+	}
+	return created, nil
+}
+
+// recordSkippedImage stores an image which was deliberately not scanned. Safe for concurrent use
+func (c *Config) recordSkippedImage(imageRef, reason string) {
+	log.Printf("Skipping image '%s': %s", imageRef, reason)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skippedImages[imageRef] = reason
+	c.progress.processedImages++
+	c.checkpointResult(checkpointEntry{ImageRef: imageRef, SkippedReason: reason})
+}
@@ -0,0 +1,79 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// skipImagePattern is an image ref pattern from skipImages, along with the expression it is matched with
+type skipImagePattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// compileSkipImagePatterns compiles the skipImages patterns. A pattern without wildcards matches the refs which start with it, e.g.
+// a registry host or repository. In a pattern with wildcards '*' matches any text, including '/' and ':', and '?' a single character,
+// and the whole ref must match, e.g. '*:latest' or 'quay.io/vendor/*'
+func compileSkipImagePatterns(patterns []string) ([]skipImagePattern, error) {
+	compiled := make([]skipImagePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		expr := regexp.QuoteMeta(pattern)
+		if strings.ContainsAny(pattern, "*?") {
+			expr = "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr) + "$"
+		} else {
+			expr = "^" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip image pattern '%s': %w", pattern, err)
+		}
+		compiled = append(compiled, skipImagePattern{pattern: pattern, re: re})
+	}
+	return compiled, nil
+}
+
+// matchSkipImagePattern returns the first skipImages pattern which matches the image ref as written, or its fully qualified form
+// (e.g. 'index.docker.io/library/nginx:1.23' for 'nginx:1.23') so registry host patterns also match Docker Hub images
+func (c *Config) matchSkipImagePattern(imageRef string) (string, bool) {
+	candidates := []string{imageRef}
+	if ref, err := parseImageRef(imageRef, false); err == nil && ref.Name() != imageRef {
+		candidates = append(candidates, ref.Name())
+	}
+	for _, p := range c.skipImagePatterns {
+		for _, candidate := range candidates {
+			if p.re.MatchString(candidate) {
+				return p.pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// skipTrustedImages marks the discovered images which match a skipImages pattern as skipped, so they are neither pulled nor scanned
+// They are logged and listed in the skipped images results along with the pattern they matched
+func (c *Config) skipTrustedImages() {
+	if len(c.skipImagePatterns) == 0 {
+		return
+	}
+
+	c.trustedImages = make(map[string]bool)
+	for _, image := range c.imageRefs() {
+		if c.skipScan(image) {
+			continue
+		}
+		pattern, matched := c.matchSkipImagePattern(image)
+		if !matched {
+			continue
+		}
+		log.Printf("Skipping image '%s' as it matches the skipImages pattern '%s'", image, pattern)
+		c.trustedImages[image] = true
+		c.skippedImages[image] = fmt.Sprintf("matches skipImages pattern '%s'", pattern)
+		c.progress.processedImages++
+	}
+	log.Printf("Skipping %d images which match the skipImages patterns", len(c.trustedImages))
+}
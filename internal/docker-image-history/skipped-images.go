@@ -0,0 +1,77 @@
+package docker_image_history
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// skipUnconfiguredECRRegion records an ECR image in a region which has not been configured as skipped, unless fail fast is set
+// Returns whether the image was skipped
+func (c *Config) skipUnconfiguredECRRegion(imageRef string, err error) bool {
+	var regionErr *unconfiguredECRRegionError
+	if c.failFast || !errors.As(err, &regionErr) {
+		return false
+	}
+
+	// Not checkpointed, so the image is scanned if the region is configured when resuming
+	log.Printf("WARNING: skipping image '%s' in the unconfigured ECR region '%s'", imageRef, regionErr.region)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skippedImages[imageRef] = fmt.Sprintf("unconfigured ECR region '%s'", regionErr.region)
+	c.unconfiguredECRRegions[regionErr.region] = true
+	c.progress.processedImages++
+	return true
+}
+
+// logUnconfiguredECRRegions logs the ECR regions which images were skipped for as they have not been configured
+func (c *Config) logUnconfiguredECRRegions() {
+	if len(c.unconfiguredECRRegions) == 0 {
+		return
+	}
+	regions := make([]string, 0, len(c.unconfiguredECRRegions))
+	for region := range c.unconfiguredECRRegions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	log.Printf("WARNING: images were skipped as these ECR regions are not configured: %v. Add them to -ecrRegions to scan them", regions)
+}
+
+// outputSkippedImages writes to a file all the images which were deliberately not scanned and why, along with the pods running them
+func (c *Config) outputSkippedImages() error {
+	if len(c.skippedImages) == 0 {
+		return nil
+	}
+
+	skippedImagesResultsPath := c.resultsPath("skipped-images", "txt")
+	f, err := c.openResultsFile(skippedImagesResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", skippedImagesResultsPath, err)
+		}
+	}(f)
+
+	for _, image := range c.imageRefs() {
+		reason, skipped := c.skippedImages[image]
+		if !skipped {
+			continue
+		}
+		_, err = f.WriteString(fmt.Sprintf("%s\t(skipped: %s) ", image, reason))
+		for _, match := range c.formatPods(c.dockerImages[image]) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", skippedImagesResultsPath, err)
+		}
+	}
+	log.Printf("Skipped images written to: %s", skippedImagesResultsPath)
+
+	return nil
+}
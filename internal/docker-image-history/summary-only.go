@@ -0,0 +1,42 @@
+package docker_image_history
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ciSummary is the single JSON object printed to stdout in summary only mode, for CI pipelines which only gate on pass/fail
+type ciSummary struct {
+	OffendingCount int  `json:"offendingCount"`
+	NonECRCount    int  `json:"nonECRCount"`
+	Clean          bool `json:"clean"`
+}
+
+// Clean returns whether the scan found no offending images. Non ECR images do not affect it
+func (c *Config) Clean() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.offendingDockerImages)+c.streamedOffendingImages == 0
+}
+
+// printSummary prints the number of offending and non ECR images, and whether the scan was clean, to stdout as a single JSON object
+func (c *Config) printSummary() error {
+	summary := ciSummary{Clean: c.Clean()}
+	c.mu.Lock()
+	summary.OffendingCount = len(c.offendingDockerImages) + c.streamedOffendingImages
+	c.mu.Unlock()
+	for image := range c.dockerImages {
+		if !isECRImage(image) {
+			summary.NonECRCount++
+		}
+	}
+
+	jsonBytes, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("marshalling summary into JSON: %w", err)
+	}
+	if _, err = fmt.Fprintln(c.stdout, string(jsonBytes)); err != nil {
+		return fmt.Errorf("writing summary: %w", err)
+	}
+	return nil
+}
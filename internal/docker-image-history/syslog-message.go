@@ -0,0 +1,24 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// syslogMessage formats an offending image as a single line of key=value pairs, so it can be parsed by log pipelines
+func (c *Config) syslogMessage(i offendingDockerImage) string {
+	keywords := make([]string, 0, len(i.matchedKeywords))
+	for keyword, count := range i.matchedKeywords {
+		keywords = append(keywords, fmt.Sprintf("%s:%d", keyword, count))
+	}
+	sort.Strings(keywords)
+
+	pods := make([]string, 0, len(c.dockerImages[i.imageRef]))
+	for _, pd := range c.dockerImages[i.imageRef] {
+		pods = append(pods, fmt.Sprintf("%s/%s/%s", pd.namespace, pd.podName, pd.containerName))
+	}
+
+	return fmt.Sprintf("event=offending_image cluster=%q image=%q matched_keywords=%q absent_keywords=%q matched_rules=%q pods=%q",
+		c.clusterK8sContextName, i.imageRef, strings.Join(keywords, ","), strings.Join(i.absentKeywords, ","), strings.Join(i.matchedRules, ","), strings.Join(pods, ","))
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package docker_image_history
+
+import "fmt"
+
+// outputSyslog is not supported as log/syslog is not available on this platform
+func (c *Config) outputSyslog() error {
+	return fmt.Errorf("syslog output is not supported on this platform")
+}
@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+)
+
+// syslogTag is the program name attached to each syslog message
+const syslogTag = "query-k8s-container-image-history"
+
+// outputSyslog sends each offending image to syslog as a single structured message
+// An empty network and address connects to the local syslog daemon
+func (c *Config) outputSyslog() error {
+	w, err := syslog.Dial(c.syslogNetwork, c.syslogAddress, syslog.LOG_WARNING|syslog.LOG_USER, syslogTag)
+	if err != nil {
+		return fmt.Errorf("connecting to syslog: %w", err)
+	}
+	defer func(w *syslog.Writer) {
+		err := w.Close()
+		if err != nil {
+			log.Printf("problem closing syslog connection: %s", err)
+		}
+	}(w)
+
+	for _, i := range c.offendingDockerImages {
+		if err = w.Warning(c.syslogMessage(i)); err != nil {
+			return fmt.Errorf("writing '%s' to syslog: %w", i.imageRef, err)
+		}
+	}
+	log.Printf("Sent %d offending images to syslog", len(c.offendingDockerImages))
+
+	return nil
+}
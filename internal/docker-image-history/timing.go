@@ -0,0 +1,113 @@
+package docker_image_history
+
+import (
+	"log"
+	"time"
+)
+
+// The phases of a scan which are timed. Pull, inspect and cleanup are timed per image, so their durations are summed across the
+// concurrent workers and can add up to more than the time the scan took
+const (
+	phaseDiscovery = "discovery"
+	phaseECRAuth   = "ecr-auth"
+	phasePull      = "pull"
+	phaseInspect   = "inspect"
+	phaseCleanup   = "cleanup"
+	phaseOutput    = "output"
+)
+
+// timedPhases are the timed phases in the order they are reported
+var timedPhases = []string{phaseDiscovery, phaseECRAuth, phasePull, phaseInspect, phaseCleanup, phaseOutput}
+
+// perImagePhases are the phases which are timed once for each image
+var perImagePhases = map[string]bool{phasePull: true, phaseInspect: true, phaseCleanup: true}
+
+// phaseTiming is the total time spent in a phase, and the number of times it ran (i.e. images for the per image phases)
+type phaseTiming struct {
+	duration time.Duration
+	count    int
+}
+
+// jsonTimings is the time breakdown of a scan in the JSON results
+type jsonTimings struct {
+	TotalSeconds           float64           `json:"totalSeconds"`
+	AverageSecondsPerImage float64           `json:"averageSecondsPerImage"`
+	Phases                 []jsonPhaseTiming `json:"phases"`
+}
+
+// jsonPhaseTiming is the time spent in a single phase. Images and the average are only set for the per image phases
+type jsonPhaseTiming struct {
+	Phase                  string  `json:"phase"`
+	Seconds                float64 `json:"seconds"`
+	Images                 int     `json:"images,omitempty"`
+	AverageSecondsPerImage float64 `json:"averageSecondsPerImage,omitempty"`
+}
+
+// timePhase adds the time since start to a phase. Intended to be deferred, e.g. defer c.timePhase(phasePull, time.Now())
+// Safe for concurrent use
+func (c *Config) timePhase(phase string, start time.Time) {
+	elapsed := time.Since(start)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timings == nil {
+		c.timings = make(map[string]phaseTiming)
+	}
+	t := c.timings[phase]
+	t.duration += elapsed
+	t.count++
+	c.timings[phase] = t
+}
+
+// perImage returns the average duration of a phase per image, or per scanned image for the whole run
+func perImage(d time.Duration, images int) time.Duration {
+	if images == 0 {
+		return 0
+	}
+	return d / time.Duration(images)
+}
+
+// logTimings logs the total time of the run and how it was spent in each phase, so it is clear whether pulling or inspecting
+// images dominates
+func (c *Config) logTimings() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total := time.Since(c.startedAt)
+	log.Printf("Timings: total %s, %s per scanned image", total.Round(time.Millisecond), perImage(total, c.progress.processedImages).Round(time.Millisecond))
+	for _, phase := range timedPhases {
+		t, ok := c.timings[phase]
+		if !ok {
+			continue
+		}
+		if perImagePhases[phase] {
+			log.Printf("  %-10s %s (%d images, %s per image)", phase, t.duration.Round(time.Millisecond), t.count, perImage(t.duration, t.count).Round(time.Millisecond))
+			continue
+		}
+		log.Printf("  %-10s %s", phase, t.duration.Round(time.Millisecond))
+	}
+}
+
+// jsonTimings returns the time breakdown of the run so far. The output phase only includes the outputs written before the JSON results
+// Must be called whilst holding c.mu
+func (c *Config) jsonTimings() jsonTimings {
+	total := time.Since(c.startedAt)
+	timings := jsonTimings{
+		TotalSeconds:           total.Seconds(),
+		AverageSecondsPerImage: perImage(total, c.progress.processedImages).Seconds(),
+		Phases:                 make([]jsonPhaseTiming, 0, len(timedPhases)),
+	}
+	for _, phase := range timedPhases {
+		t, ok := c.timings[phase]
+		if !ok {
+			continue
+		}
+		p := jsonPhaseTiming{Phase: phase, Seconds: t.duration.Seconds()}
+		if perImagePhases[phase] {
+			p.Images = t.count
+			p.AverageSecondsPerImage = perImage(t.duration, t.count).Seconds()
+		}
+		timings.Phases = append(timings.Phases, p)
+	}
+	return timings
+}
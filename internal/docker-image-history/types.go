@@ -1,35 +1,332 @@
 package docker_image_history
 
 import (
-	dockerClient "github.com/docker/docker/client"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Options stores the user supplied settings used to build a Config
+type Options struct {
+	DockerImageKeyWords         []string
+	ClusterK8sContextName       string
+	ImagesAccountAWSProfileName string
+	ECRRegions                  []string
+	ServeAddress                string
+	ExpectedImagesPath          string
+	TimestampFormat             string
+	Remote                      bool
+	RemoteConcurrency           int
+	DockerHost                  string
+	DockerTLSCACert             string
+	DockerTLSCert               string
+	DockerTLSKey                string
+	CaseSensitive               bool
+	OutputFormat                string
+	DeferCleanup                bool
+	InsecureRegistries          []string
+	RegistryCAFile              string
+	Concurrency                 int
+	MaxDiskGB                   float64
+	KeywordPoliciesPath         string
+	InventoryOutput             bool
+	ScanAnnotations             bool
+	Since                       time.Time
+	ContextChars                int
+	Syslog                      bool
+	SyslogNetwork               string
+	SyslogAddress               string
+	SyslogOnly                  bool
+	ResumeFrom                  string
+	Namespace                   string
+	CleanupConcurrency          int
+	FailFast                    bool
+	MaxImageSizeMB              float64
+	LowMemory                   bool
+	NodeImageStats              bool
+	ScanNodeCachedImages        bool
+	NodeInventory               bool
+	RunOnly                     bool
+	MaxImages                   int
+	Seed                        int64
+	ECRRegionOverrides          map[string]string
+	BaselinePath                string
+	FullRescan                  bool
+	Redact                      bool
+	OutputAppend                bool
+	CheckRunsAsRoot             bool
+	DockerSave                  bool
+	CountMode                   string
+	RegistryMirrors             map[string]string
+	PodDetailFields             []string
+	KeywordRulesPath            string
+	GroupBy                     string
+	Workload                    string
+	FailOnUnparseableRefs       bool
+	DebugAuth                   bool
+	DecodeBase64                bool
+	NoPullIfPresent             bool
+	SummaryOnly                 bool
+	PodEvents                   bool
+	CRDImageSourcesPath         string
+	RetryCleanup                bool
+	RegexKeywords               bool
+	ForcePull                   bool
+	PullRetries                 int
+	InCluster                   bool
+	IncludeNamespaces           []string
+	ExcludeNamespaces           []string
+	DryRun                      bool
+	OutputDir                   string
+	KeepImages                  bool
+	KeepOffendingImages         bool
+	WorkloadTemplates           bool
+	SkipImages                  []string
+	PullTimeout                 time.Duration
+	CollapseReplicas            bool
+
+	// Stdout and Stderr are where the findings and summaries are printed. They default to os.Stdout and os.Stderr
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
 // Config stores the Docker & K8s clients as well as the results from searching for keywords in image history
 type Config struct {
 	dockerImageKeyWords         []string
 	dockerImages                map[string][]podDetails
 	offendingDockerImages       []offendingDockerImage
-	dockerClient                *dockerClient.Client
-	ecrCredentials              map[string]string
+	offendingImageIndex         map[string]int
+	dockerClient                imageClient
+	ecrCredentialsMu            sync.Mutex
+	ecrCredentials              map[string]ecrToken
+	ecrCredentialErrors         map[string]error
+	ecrCredentialFetches        singleflight.Group
 	ecrRegions                  []string
 	k8sClient                   *kubernetes.Clientset
 	clusterK8sContextName       string
 	imagesAccountAWSProfileName string
+	serveAddress                string
+	expectedImagesPath          string
+	timestampFormat             string
+	remote                      bool
+	remoteConcurrency           int
+	caseSensitive               bool
+	outputFormat                string
+	deferCleanup                bool
+	pulledImages                []pulledImage
+	localImageUsers             map[string]int
+	cleanupAttempts             []cleanupAttempt
+	leakedImages                []leakedImage
+	removedImages               int
+	reclaimedBytes              int64
+	insecureRegistries          []string
+	registryCAFile              string
+	registryTransport           http.RoundTripper
+	concurrency                 int
+	maxDiskBytes                int64
+	keywordPoliciesPath         string
+	namespaceKeywords           map[string][]string
+	inventoryOutput             bool
+	scanErrors                  []scanError
+	missingImages               []missingImage
+	mutableTagImages            map[string]string
+	scanAnnotations             bool
+	since                       time.Time
+	skippedImages               map[string]string
+	unconfiguredECRRegions      map[string]bool
+	contextChars                int
+	syslog                      bool
+	syslogNetwork               string
+	syslogAddress               string
+	syslogOnly                  bool
+	resumeFrom                  string
+	checkpoint                  *recordFile
+	checkpointed                map[string]bool
+	namespace                   string
+	cleanupConcurrency          int
+	failFast                    bool
+	maxImageSizeBytes           int64
+	oversizedImages             []oversizedImage
+
+	// findings is where each match is printed as it is found. Progress and diagnostics are logged to stderr, so that redirecting
+	// stdout only captures the findings
+	findings                io.Writer
+	stdout                  io.Writer
+	stderr                  io.Writer
+	lowMemory               bool
+	offendingStream         *recordFile
+	offendingStreamPath     string
+	streamedOffendingImages int
+	nodeImageStats          bool
+	scanNodeCachedImages    bool
+	nodeImages              map[string]*nodeImage
+	nodeInventory           bool
+	runOnly                 bool
+	maxImages               int
+	seed                    int64
+	unsampledImages         map[string]bool
+	ecrRegionOverrides      map[string]string
+	baselinePath            string
+	fullRescan              bool
+	imageDigests            map[string]string
+	baselineResults         map[string]checkpointEntry
+	carriedForward          map[string]bool
+	inconsistentDigests     []inconsistentDigest
+	compactProgress         bool
+	imageIndex              map[string]int
+	redact                  bool
+	outputAppend            bool
+	checkRunsAsRoot         bool
+	rootImages              []rootImage
+	dockerSave              bool
+	countMode               string
+	registryMirrors         map[string]string
+	podDetailFields         []string
+	keywordRulesPath        string
+	keywordRules            keywordRules
+	keywordRuleTerms        []string
+	groupBy                 string
+	workloadKind            string
+	workloadName            string
+	failOnUnparseableRefs   bool
+	unparseableImages       []unparseableImage
+	debugAuth               bool
+	decodeBase64            bool
+	noPullIfPresent         bool
+	summaryOnly             bool
+	podEvents               bool
+	matchers                []Matcher
+	crdImageSourcesPath     string
+	retryCleanup            bool
+	regexKeywords           bool
+	keywordRegexps          map[string]*regexp.Regexp
+	forcePull               bool
+	pullRetries             int
+	inCluster               bool
+	includeNamespaces       []string
+	excludeNamespaces       []string
+	dryRun                  bool
+	outputDir               string
+	keepImages              bool
+	keepOffendingImages     bool
+	// offendingRefs are the image refs which matched keywords, when keepOffendingImages is set
+	offendingRefs     map[string]bool
+	keptImageIDs      map[string]bool
+	keptBytes         int64
+	workloadTemplates bool
+	skipImagePatterns []skipImagePattern
+	// trustedImages are the images which match a skipImages pattern, so are not scanned
+	trustedImages    map[string]bool
+	pullTimeout      time.Duration
+	collapseReplicas bool
+
+	// layerCache stores the keyword matches per history layer, so layers shared between images are only matched once
+	layerCacheMu     sync.Mutex
+	layerCache       map[string][]layerMatch
+	layerCacheHits   int
+	layerCacheMisses int
+
+	// mu guards the scan results and progress, which are read by the status server whilst the scan is running
+	mu       sync.Mutex
+	progress scanProgress
+
+	discovery discoveryStats
+
+	// errorCounts is the number of images which failed at each stage of the scan, guarded by mu
+	errorCounts map[string]int
+
+	// seenImages is the result of each image whose history has been checked, keyed by seenImageKey, guarded by mu
+	seenImages map[string]offendingDockerImage
+
+	// startedAt is when the run started. timings is the total time spent in each phase of the scan, guarded by mu
+	startedAt time.Time
+	timings   map[string]phaseTiming
+
+	// fetchECRToken generates the credentials of a region. It is fetchECRCredentials unless replaced by a test
+	fetchECRToken func(ctx context.Context, profile, region string, debugAuth bool) (ecrToken, error)
+}
+
+// discoveryStats counts the pods and containers found in the cluster, and how many were included in the scan after filtering
+type discoveryStats struct {
+	podsSeen           int
+	podsIncluded       int
+	containersSeen     int
+	containersIncluded int
+}
+
+// scanProgress tracks how far through the cluster's images the scan is
+type scanProgress struct {
+	totalImages     int
+	startedImages   int
+	processedImages int
+	currentImage    string
+	finished        bool
+
+	// loggedStep is the last progressLogStepPercent step logged when progress is compact
+	loggedStep int
 }
 
 // podDetails provides K8s context for any images which have been matched in the cluster
+// historical is set for pods which have terminated (e.g. completed Job pods), or no longer exist and were found in the pod events
 type podDetails struct {
 	podName       string
 	containerName string
+	containerType string
 	namespace     string
+	workloadKind  string
+	workloadName  string
+	imageDigest   string
+	nodeName      string
+	podUID        string
+	historical    bool
 }
 
 // offendingDockerImage stores a result of an image which has been matched against the target keywords
+// matchedKeywords are positive keywords found in the history, absentKeywords are negated keywords missing from the history
+// matchedMetadata are the manifest annotations and config labels which matched each keyword
+// matchContexts are the matches along with their surrounding text in the history, when contextChars is set
+// matchedInstructions are the Dockerfile instructions (e.g. RUN, COPY) of the history entries which matched each keyword
+// matchedLayers are the history layers which matched each keyword, with their index, full instruction and creation time
+// matchedRules are the names of the keyword rules triggered by the history
+// decodedKeywords are the matched keywords which were only found after base64 decoding the history, when decodeBase64 is set
 type offendingDockerImage struct {
-	matchFound      bool
-	imageRef        string
-	matchedKeywords map[string]int
+	matchFound          bool
+	imageRef            string
+	matchedKeywords     map[string]int
+	absentKeywords      []string
+	matchedMetadata     map[string][]string
+	matchContexts       map[string][]string
+	matchedInstructions map[string][]string
+	matchedLayers       map[string][]layerMatchDetail
+	matchedRules        []string
+	decodedKeywords     []string
+}
+
+// historyEntry is a single layer of an image's history, regardless of whether it was read from the local Docker instance or a remote registry
+// layerDigest is only set when the source reports the digest of the layer, and created when it reports the layer's creation time
+// index is the position of the entry in the history, counting from the base layer at 0
+type historyEntry struct {
+	index       int
+	createdBy   string
+	layerDigest string
+	created     time.Time
+}
+
+// layerMatch is a keyword found in a single history layer, and the offsets of the match in the instruction
+// If decoded is set the keyword was only found after base64 decoding the instruction, and the offsets are in the decoded text
+type layerMatch struct {
+	keyword     string
+	negated     bool
+	start       int
+	end         int
+	occurrences int
+	decoded     bool
 }
 
 // Event stores the data parsed from each Docker image pull log
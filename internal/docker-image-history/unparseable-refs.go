@@ -0,0 +1,82 @@
+package docker_image_history
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// unparseableImage is a container image ref whose registry host cannot be parsed, along with the pods running it
+type unparseableImage struct {
+	imageRef string
+	err      error
+	pods     []podDetails
+}
+
+// jsonUnparseableImage is an image ref which cannot be parsed, along with the pods running it
+type jsonUnparseableImage struct {
+	ImageRef string    `json:"imageRef"`
+	Error    string    `json:"error"`
+	Pods     []jsonPod `json:"pods"`
+}
+
+// findUnparseableImages removes the image refs whose registry host cannot be parsed (e.g. malformed or mutated by an admission
+// controller) from the images to scan, as they cannot be pulled or read from a registry. They are reported separately instead
+func (c *Config) findUnparseableImages() {
+	for image, details := range c.dockerImages {
+		if _, err := parseImageRef(image, false); err != nil {
+			log.Printf("WARNING: unable to parse the image ref '%s', it will not be scanned: %s", image, err)
+			c.unparseableImages = append(c.unparseableImages, unparseableImage{imageRef: image, err: err, pods: details})
+			delete(c.dockerImages, image)
+			delete(c.mutableTagImages, image)
+		}
+	}
+	sort.Slice(c.unparseableImages, func(i, j int) bool { return c.unparseableImages[i].imageRef < c.unparseableImages[j].imageRef })
+}
+
+// jsonUnparseableImages returns the image refs which cannot be parsed for the JSON results
+func (c *Config) jsonUnparseableImages() []jsonUnparseableImage {
+	images := make([]jsonUnparseableImage, 0, len(c.unparseableImages))
+	for _, u := range c.unparseableImages {
+		image := jsonUnparseableImage{ImageRef: u.imageRef, Error: u.err.Error(), Pods: make([]jsonPod, 0, len(u.pods))}
+		for _, pd := range u.pods {
+			image.Pods = append(image.Pods, c.jsonPod(pd))
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// outputUnparseableImages writes to a file all the image refs in the cluster which cannot be parsed, and the pods running them
+func (c *Config) outputUnparseableImages() error {
+	if len(c.unparseableImages) == 0 {
+		return nil
+	}
+
+	unparseableResultsPath := c.resultsPath("unparseable-image-refs", "txt")
+	f, err := c.openResultsFile(unparseableResultsPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Printf("problem closing file '%s': %s", unparseableResultsPath, err)
+		}
+	}(f)
+
+	for _, u := range c.unparseableImages {
+		_, err = f.WriteString(fmt.Sprintf("%q\t(error: %s) ", u.imageRef, u.err))
+		for _, match := range c.formatPods(u.pods) {
+			_, err = f.WriteString(fmt.Sprintf("(%s) ", match))
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return fmt.Errorf("writing results to '%s': %w", unparseableResultsPath, err)
+		}
+	}
+	log.Printf("%d image refs cannot be parsed. Results written to: %s", len(c.unparseableImages), unparseableResultsPath)
+
+	return nil
+}
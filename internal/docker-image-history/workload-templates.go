@@ -0,0 +1,124 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadTemplate is the pod template declared by a workload controller
+type workloadTemplate struct {
+	kind      string
+	name      string
+	namespace string
+	spec      corev1.PodSpec
+}
+
+// listWorkloadTemplates lists the pod templates of every Deployment, StatefulSet, DaemonSet and CronJob in a namespace, or across
+// all namespaces if it is empty
+func (c *Config) listWorkloadTemplates(ctx context.Context, namespace string) ([]workloadTemplate, error) {
+	var templates []workloadTemplate
+
+	deployments, err := c.k8sClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing Deployments: %w", err)
+	}
+	for _, d := range deployments.Items {
+		templates = append(templates, workloadTemplate{kind: "Deployment", name: d.Name, namespace: d.Namespace, spec: d.Spec.Template.Spec})
+	}
+
+	statefulSets, err := c.k8sClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing StatefulSets: %w", err)
+	}
+	for _, s := range statefulSets.Items {
+		templates = append(templates, workloadTemplate{kind: "StatefulSet", name: s.Name, namespace: s.Namespace, spec: s.Spec.Template.Spec})
+	}
+
+	daemonSets, err := c.k8sClient.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing DaemonSets: %w", err)
+	}
+	for _, d := range daemonSets.Items {
+		templates = append(templates, workloadTemplate{kind: "DaemonSet", name: d.Name, namespace: d.Namespace, spec: d.Spec.Template.Spec})
+	}
+
+	cronJobs, err := c.k8sClient.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing CronJobs: %w", err)
+	}
+	for _, j := range cronJobs.Items {
+		templates = append(templates, workloadTemplate{kind: "CronJob", name: j.Name, namespace: j.Namespace, spec: j.Spec.JobTemplate.Spec.Template.Spec})
+	}
+
+	return templates, nil
+}
+
+// queryWorkloadTemplateImages adds the container images declared in the pod templates of the workload controllers, so the images
+// of workloads which are scaled to zero, suspended or crash-looping are scanned even though no pod is running them. Each image is
+// attributed to its workload in place of a pod name (e.g. 'Deployment/my-app'). Containers already found in a running pod of the
+// same workload are not added again
+func (c *Config) queryWorkloadTemplateImages(ctx context.Context) error {
+	added := 0
+	for _, namespace := range c.listNamespaces() {
+		templates, err := c.listWorkloadTemplates(ctx, namespace)
+		if err != nil {
+			if len(namespace) > 0 {
+				return fmt.Errorf("querying for k8s workloads in namespace '%s': %w", namespace, err)
+			}
+			return fmt.Errorf("querying for all k8s workloads: %w", err)
+		}
+		for _, t := range templates {
+			if c.namespaceExcluded(t.namespace) {
+				continue
+			}
+			added += c.addWorkloadTemplateImages(t)
+		}
+	}
+	log.Printf("Found %d containers in workload pod templates which are not running in any pod. %d unique images in total", added, len(c.dockerImages))
+
+	return nil
+}
+
+// addWorkloadTemplateImages adds the container images in a workload's pod template. Containers already found in a running pod of the
+// workload are skipped. Returns the number of containers added
+func (c *Config) addWorkloadTemplateImages(t workloadTemplate) int {
+	added := 0
+	for _, container := range podContainers(t.spec) {
+		c.discovery.containersSeen++
+		if len(container.image) == 0 || c.workloadContainerSeen(container.image, t, container.name) {
+			continue
+		}
+
+		pd := podDetails{
+			podName:       fmt.Sprintf("%s/%s", t.kind, t.name),
+			containerName: container.name,
+			containerType: container.containerType,
+			namespace:     t.namespace,
+			workloadKind:  t.kind,
+			workloadName:  t.name,
+		}
+		if _, seen := c.dockerImages[container.image]; !seen {
+			if reason, mutable := mutableTag(container.image); mutable {
+				c.mutableTagImages[container.image] = reason
+			}
+		}
+		c.dockerImages[container.image] = append(c.dockerImages[container.image], pd)
+		c.discovery.containersIncluded++
+		added++
+	}
+	return added
+}
+
+// workloadContainerSeen returns whether a container of the workload has already been found running the image
+func (c *Config) workloadContainerSeen(image string, t workloadTemplate, containerName string) bool {
+	for _, pd := range c.dockerImages[image] {
+		if pd.namespace == t.namespace && pd.workloadKind == t.kind && pd.workloadName == t.name && pd.containerName == containerName {
+			return true
+		}
+	}
+	return false
+}
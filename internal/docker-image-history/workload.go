@@ -0,0 +1,87 @@
+package docker_image_history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadKinds maps the supported workload kinds, as accepted by -workload, to their K8s kind
+var workloadKinds = map[string]string{
+	"deployment":  "Deployment",
+	"statefulset": "StatefulSet",
+	"daemonset":   "DaemonSet",
+	"job":         "Job",
+	"cronjob":     "CronJob",
+}
+
+// ParseWorkload parses a workload in the format '<kind>/<name>', e.g. 'deployment/my-app'. The kind is case-insensitive
+// Returns the K8s kind and the name of the workload
+func ParseWorkload(s string) (string, string, error) {
+	kind, name, found := strings.Cut(s, "/")
+	k8sKind, supported := workloadKinds[strings.ToLower(kind)]
+	if !found || len(name) == 0 || strings.Contains(name, "/") || !supported {
+		return "", "", fmt.Errorf("invalid workload '%s', expected '<kind>/<name>' where kind is one of deployment, statefulset, daemonset, job or cronjob", s)
+	}
+	return k8sKind, name, nil
+}
+
+// workloadPodTemplate fetches the workload and returns its pod template
+func (c *Config) workloadPodTemplate(ctx context.Context) (corev1.PodTemplateSpec, error) {
+	var (
+		template corev1.PodTemplateSpec
+		err      error
+	)
+	switch c.workloadKind {
+	case "Deployment":
+		deployment, getErr := c.k8sClient.AppsV1().Deployments(c.namespace).Get(ctx, c.workloadName, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			template = deployment.Spec.Template
+		}
+	case "StatefulSet":
+		statefulSet, getErr := c.k8sClient.AppsV1().StatefulSets(c.namespace).Get(ctx, c.workloadName, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			template = statefulSet.Spec.Template
+		}
+	case "DaemonSet":
+		daemonSet, getErr := c.k8sClient.AppsV1().DaemonSets(c.namespace).Get(ctx, c.workloadName, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			template = daemonSet.Spec.Template
+		}
+	case "Job":
+		job, getErr := c.k8sClient.BatchV1().Jobs(c.namespace).Get(ctx, c.workloadName, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			template = job.Spec.Template
+		}
+	case "CronJob":
+		cronJob, getErr := c.k8sClient.BatchV1().CronJobs(c.namespace).Get(ctx, c.workloadName, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			template = cronJob.Spec.JobTemplate.Spec.Template
+		}
+	default:
+		err = fmt.Errorf("unsupported workload kind '%s'", c.workloadKind)
+	}
+	if err != nil {
+		return template, fmt.Errorf("fetching %s '%s' in namespace '%s': %w", c.workloadKind, c.workloadName, c.namespace, err)
+	}
+	return template, nil
+}
+
+// queryWorkloadImages stores the container images in a single workload's pod template for later processing, instead of the images of
+// every running pod. Only requires permission to get the workload. As no pods are listed, each image is attributed to the workload
+// itself in place of a pod name (e.g. 'Deployment/my-app')
+func (c *Config) queryWorkloadImages(ctx context.Context) error {
+	template, err := c.workloadPodTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.addWorkloadTemplateImages(workloadTemplate{kind: c.workloadKind, name: c.workloadName, namespace: c.namespace, spec: template.Spec})
+	log.Printf("Found %d images in the pod template of %s '%s' in namespace '%s'", len(c.dockerImages), c.workloadKind, c.workloadName, c.namespace)
+
+	return nil
+}
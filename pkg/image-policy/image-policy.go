@@ -0,0 +1,52 @@
+// Package image_policy checks the history of container images for keywords, without K8s or AWS
+// It is intended to be imported from Go tests to assert built images do not contain forbidden commands:
+//
+//	matches, err := image_policy.ImageMatchesKeywords(ctx, "my-app:test", []string{"curl", "wget"})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if len(matches) > 0 {
+//		t.Errorf("image history contains forbidden commands: %v", matches)
+//	}
+package image_policy
+
+import (
+	"context"
+
+	"query-k8s-container-image-history/internal/docker-image-history"
+)
+
+// HistoryClient is the subset of the Docker client used to read the history of a local image
+// It can be replaced with a fake in unit tests
+type HistoryClient = docker_image_history.HistoryClient
+
+// HistoryEntry is a single layer of an image's history, as passed to a Matcher
+type HistoryEntry = docker_image_history.HistoryEntry
+
+// Match is a finding in a single history layer, reported under its Keyword
+type Match = docker_image_history.Match
+
+// Matcher finds matches in each history layer of an image, for matching logic beyond keywords. Must be safe for concurrent use
+type Matcher = docker_image_history.Matcher
+
+// NewKeywordMatcher returns the built-in keyword matching as a Matcher, so it can be combined with custom matchers
+func NewKeywordMatcher(keywords []string, caseSensitive bool) Matcher {
+	return docker_image_history.NewKeywordMatcher(keywords, caseSensitive)
+}
+
+// ImageMatchesKeywords checks the history of an image in the local Docker instance for keywords
+// Returns the number of history entries each keyword matched. Negated keywords ('!keyword') are included with a count of 0 when absent
+func ImageMatchesKeywords(ctx context.Context, imageRef string, keywords []string) (map[string]int, error) {
+	return docker_image_history.ImageMatchesKeywords(ctx, imageRef, keywords)
+}
+
+// ImageMatchesKeywordsWithClient is the same as ImageMatchesKeywords, but reads the image history using the client
+func ImageMatchesKeywordsWithClient(ctx context.Context, client HistoryClient, imageRef string, keywords []string) (map[string]int, error) {
+	return docker_image_history.ImageMatchesKeywordsWithClient(ctx, client, imageRef, keywords)
+}
+
+// ImageMatchesWithClient checks the history of an image with the matchers, reading the image history using the client
+// Returns the number of history entries each match keyword was found in
+func ImageMatchesWithClient(ctx context.Context, client HistoryClient, imageRef string, matchers []Matcher) (map[string]int, error) {
+	return docker_image_history.ImageMatchesWithClient(ctx, client, imageRef, matchers)
+}